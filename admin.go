@@ -0,0 +1,348 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Chave do contexto gin que indica se a requisição pode ver CPFs sem máscara
+const ctxCPFUnmask = "cpf_unmask"
+
+// adminEnabled indica se as rotas /admin devem ser registradas; ADMIN_ENABLED=false as desabilita
+func adminEnabled() bool {
+	return cfg.Auth.AdminEnabled
+}
+
+// registerAdminRoutes registra as rotas de diagnóstico e de gestão de chaves em /admin.
+// ADMIN_TOKEN é a credencial inicial de administrador, usada para criar as primeiras chaves.
+func registerAdminRoutes(router *gin.Engine) {
+	if !adminEnabled() {
+		slog.Warn("rotas /admin desabilitadas por ADMIN_ENABLED=false")
+		return
+	}
+
+	admin := router.Group("/admin", authenticate(), cpfUnmask())
+	admin.GET("/db", requireRole(roleAdmin), adminDBHandler)
+	admin.GET("/cpf/:codigo", requireRole(roleAdmin), adminCPFHandler)
+	admin.DELETE("/cpf/cache", requireRole(roleAdmin, roleEditor), adminClearCPFCacheHandler)
+	admin.GET("/cache", requireRole(roleAdmin, roleEditor), adminCacheStatsHandler)
+	admin.DELETE("/linha/cache", requireRole(roleAdmin, roleEditor), adminClearLinhaCacheHandler)
+
+	admin.GET("/api-keys", requireRole(roleAdmin), listAPIKeysHandler)
+	admin.POST("/api-keys", requireRole(roleAdmin), createAPIKeyHandler)
+	admin.DELETE("/api-keys/:id", requireRole(roleAdmin), revokeAPIKeyHandler)
+}
+
+// cpfUnmask libera CPFs completos com ?mostrar_cpf=true, apenas para credenciais com o papel cpf
+func cpfUnmask() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if mostrar, _ := strconv.ParseBool(c.Query("mostrar_cpf")); mostrar {
+			principal, _ := c.Get(ctxPrincipal)
+			if p, ok := principal.(*Principal); !ok || !p.Has(roleCPF) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Credencial sem permissão para exibir CPFs"})
+				return
+			}
+			c.Set(ctxCPFUnmask, true)
+		}
+		c.Next()
+	}
+}
+
+// maskCPF mascara um CPF mantendo apenas os dígitos centrais, ex.: ***.456.789-**
+func maskCPF(cpf string) string {
+	var digitos strings.Builder
+	for _, r := range cpf {
+		if r >= '0' && r <= '9' {
+			digitos.WriteRune(r)
+		}
+	}
+
+	d := digitos.String()
+	if len(d) != 11 {
+		if cpf == "" {
+			return ""
+		}
+		return "***"
+	}
+	return "***." + d[3:6] + "." + d[6:9] + "-**"
+}
+
+// cpfForResponse devolve o CPF mascarado, salvo quando a requisição tem permissão para vê-lo completo
+func cpfForResponse(c *gin.Context, cpf string) string {
+	if c.GetBool(ctxCPFUnmask) {
+		return cpf
+	}
+	return maskCPF(cpf)
+}
+
+// adminDBHandler é o diagnóstico completo do banco, com amostras de registros da tabela pessoa
+func adminDBHandler(c *gin.Context) {
+	envVars := []string{"DATABASE_URL", "DATABASE_PUBLIC_URL", "POSTGRES_URL"}
+	envStatus := make(map[string]string)
+	for _, envVar := range envVars {
+		value := os.Getenv(envVar)
+		if value != "" {
+			envStatus[envVar] = redact(value)
+		} else {
+			envStatus[envVar] = "não definida"
+		}
+	}
+
+	ctx, cancel := statementContext(c.Request.Context())
+	defer cancel()
+
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":       "error",
+			"error":        err.Error(),
+			"env_vars":     envStatus,
+			"db_connected": false,
+		})
+		return
+	}
+
+	if db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":       "error",
+			"error":        "pool de conexões não inicializado",
+			"env_vars":     envStatus,
+			"db_connected": false,
+		})
+		return
+	}
+
+	// Testar ping
+	pingErr := db.PingContext(ctx)
+	if pingErr != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":       "error",
+			"error":        pingErr.Error(),
+			"env_vars":     envStatus,
+			"db_connected": false,
+		})
+		return
+	}
+
+	// Contar registros
+	var totalRecords int
+	db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pessoa").Scan(&totalRecords)
+
+	// Buscar alguns registros com CPF
+	rows, _ := db.QueryContext(ctx, "SELECT id_pessoa, cod_identificador, cpf, funcao, status FROM pessoa WHERE cpf IS NOT NULL AND cpf != '' LIMIT 5")
+	var sampleRecords []map[string]interface{}
+	if rows != nil {
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			var cod int
+			var cpfSample sql.NullString
+			var funcao sql.NullString
+			var status bool
+			if err := rows.Scan(&id, &cod, &cpfSample, &funcao, &status); err == nil {
+				cpfStr := ""
+				if cpfSample.Valid {
+					cpfStr = cpfSample.String
+				}
+				funcaoStr := ""
+				if funcao.Valid {
+					funcaoStr = funcao.String
+				}
+				sampleRecords = append(sampleRecords, map[string]interface{}{
+					"id_pessoa":         id,
+					"cod_identificador": cod,
+					"cpf":               cpfForResponse(c, cpfStr),
+					"cpf_length":        len(cpfStr),
+					"funcao":            funcaoStr,
+					"status":            status,
+				})
+			}
+		}
+	}
+
+	// Buscar alguns registros sem CPF
+	rowsNoCPF, _ := db.QueryContext(ctx, "SELECT id_pessoa, cod_identificador, cpf, funcao FROM pessoa WHERE cpf IS NULL OR cpf = '' LIMIT 5")
+	var recordsNoCPF []map[string]interface{}
+	if rowsNoCPF != nil {
+		defer rowsNoCPF.Close()
+		for rowsNoCPF.Next() {
+			var id int
+			var cod int
+			var cpfSample sql.NullString
+			var funcao sql.NullString
+			if err := rowsNoCPF.Scan(&id, &cod, &cpfSample, &funcao); err == nil {
+				cpfStr := ""
+				if cpfSample.Valid {
+					cpfStr = cpfSample.String
+				}
+				funcaoStr := ""
+				if funcao.Valid {
+					funcaoStr = funcao.String
+				}
+				recordsNoCPF = append(recordsNoCPF, map[string]interface{}{
+					"id_pessoa":         id,
+					"cod_identificador": cod,
+					"cpf":               cpfForResponse(c, cpfStr),
+					"cpf_length":        len(cpfStr),
+					"funcao":            funcaoStr,
+				})
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":           "success",
+		"db_connected":     true,
+		"env_vars":         envStatus,
+		"total_records":    totalRecords,
+		"records_with_cpf": sampleRecords,
+		"records_no_cpf":   recordsNoCPF,
+	})
+}
+
+// adminCPFHandler testa a consulta de CPF de um código identificador
+func adminCPFHandler(c *gin.Context) {
+	codigo := c.Param("codigo")
+	slog.DebugContext(c.Request.Context(), "consulta de CPF de diagnóstico", "codigo", codigo)
+
+	// Limpar cache para forçar nova busca
+	cpfCache.Delete(codigo)
+
+	ctx, cancel := statementContext(c.Request.Context())
+	defer cancel()
+
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "error",
+			"message": "Não foi possível conectar ao banco de dados",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// Converter para inteiro
+	codInt, errConv := strconv.Atoi(codigo)
+	var queryParam interface{}
+	if errConv == nil {
+		queryParam = codInt
+	} else {
+		queryParam = codigo
+	}
+
+	var cpf sql.NullString
+	query := "SELECT cpf FROM pessoa WHERE cod_identificador = $1"
+	err = db.QueryRowContext(ctx, query, queryParam).Scan(&cpf)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusOK, gin.H{
+				"status":           "not_found",
+				"message":          fmt.Sprintf("CPF não encontrado para código: %s", codigo),
+				"codigo":           codigo,
+				"query_param":      queryParam,
+				"query_param_type": fmt.Sprintf("%T", queryParam),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":      "error",
+			"message":     "Erro ao consultar CPF",
+			"error":       err.Error(),
+			"codigo":      codigo,
+			"query_param": queryParam,
+		})
+		return
+	}
+
+	cpfValue := ""
+	if cpf.Valid {
+		cpfValue = cpf.String
+	}
+
+	// Testar também uma consulta para ver todos os registros
+	var totalRecords int
+	db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pessoa").Scan(&totalRecords)
+
+	rows, _ := db.QueryContext(ctx, "SELECT cod_identificador, cpf FROM pessoa LIMIT 10")
+	var sampleRecords []map[string]interface{}
+	if rows != nil {
+		defer rows.Close()
+		for rows.Next() {
+			var cod int
+			var cpfSample sql.NullString
+			if err := rows.Scan(&cod, &cpfSample); err == nil {
+				cpfStr := ""
+				if cpfSample.Valid {
+					cpfStr = cpfSample.String
+				}
+				sampleRecords = append(sampleRecords, map[string]interface{}{
+					"cod_identificador": cod,
+					"cpf":               cpfForResponse(c, cpfStr),
+					"cpf_length":        len(cpfStr),
+				})
+			}
+		}
+	}
+
+	// Testar também usando a função getCPFByCodIdentificador
+	cpfFromFunction, errFromFunction := getCPFByCodIdentificador(c.Request.Context(), codigo)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":            "success",
+		"total_records":     totalRecords,
+		"codigo":            codigo,
+		"cpf_direct_query":  cpfForResponse(c, cpfValue),
+		"cpf_from_function": cpfForResponse(c, cpfFromFunction),
+		"cpf_valid":         cpf.Valid,
+		"query_param":       queryParam,
+		"query_param_type":  fmt.Sprintf("%T", queryParam),
+		"sample_records":    sampleRecords,
+		"function_error": func() string {
+			if errFromFunction != nil {
+				return errFromFunction.Error()
+			}
+			return ""
+		}(),
+	})
+}
+
+// adminClearCPFCacheHandler limpa o cache de CPF
+func adminClearCPFCacheHandler(c *gin.Context) {
+	cpfCache.Clear()
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Cache de CPF limpo",
+	})
+}
+
+// adminClearLinhaCacheHandler limpa o cache de parâmetros de linha
+func adminClearLinhaCacheHandler(c *gin.Context) {
+	linhaCache.Clear()
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Cache de linhas limpo",
+	})
+}
+
+// adminCacheStatsHandler mostra tamanho, acertos e falhas dos caches de CPF e de linha
+func adminCacheStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"cpf":    cpfCache.Stats(),
+		"linha":  linhaCache.Stats(),
+		"config": gin.H{
+			"ttl":          cfg.Cache.TTL.String(),
+			"negative_ttl": cfg.Cache.NegativeTTL.String(),
+			"max_entries":  cfg.Cache.MaxEntries,
+			"notify":       cfg.Cache.Notify,
+		},
+	})
+}
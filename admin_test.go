@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminRouter(t *testing.T, token, unmaskToken string, enabled bool) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	comConfig(t, func(c *Config) {
+		c.Auth.AdminToken = token
+		c.Auth.AdminUnmaskToken = unmaskToken
+		c.Auth.AdminEnabled = enabled
+	})
+
+	router := gin.New()
+	registerAdminRoutes(router)
+	return router
+}
+
+func adminRequest(router *gin.Engine, method, target, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestMaskCPF testa a máscara de CPFs com e sem pontuação
+func TestMaskCPF(t *testing.T) {
+	assert.Equal(t, "***.456.789-**", maskCPF("123.456.789-01"))
+	assert.Equal(t, "***.456.789-**", maskCPF("12345678901"))
+	assert.Equal(t, "***", maskCPF("123"))
+	assert.Equal(t, "", maskCPF(""))
+}
+
+// TestAdminRoutes_Auth testa a exigência da credencial de administrador
+func TestAdminRoutes_Auth(t *testing.T) {
+	router := adminRouter(t, "segredo", "", true)
+	mock := comBancoMock(t)
+	mock.ExpectQuery("FROM api_keys WHERE hash").WithArgs(hashAPIKey("errado"), int64(60000)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "nome", "papeis", "registrar_uso"}))
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"Sem credencial", "", http.StatusUnauthorized},
+		{"Credencial errada", "errado", http.StatusUnauthorized},
+		{"Credencial de administrador", "segredo", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := adminRequest(router, "DELETE", "/admin/cpf/cache", tt.token)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+
+	w := adminRequest(router, "DELETE", "/debug/cpf/cache", "segredo")
+	assert.Equal(t, http.StatusNotFound, w.Code, "Rotas /debug não existem mais")
+}
+
+// TestAdminRoutes_Disabled testa que as rotas não são registradas com ADMIN_ENABLED=false
+func TestAdminRoutes_Disabled(t *testing.T) {
+	w := adminRequest(adminRouter(t, "segredo", "", false), "DELETE", "/admin/cpf/cache", "segredo")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestAdminCPFHandler_Masking testa a máscara do CPF e a exibição completa apenas com permissão
+func TestAdminCPFHandler_Masking(t *testing.T) {
+	router := adminRouter(t, "segredo", "mostrar", true)
+
+	consultar := func(target, token string) *httptest.ResponseRecorder {
+		mock := comBancoMock(t)
+		mock.ExpectQuery("SELECT cpf FROM pessoa").WithArgs(951716).
+			WillReturnRows(sqlmock.NewRows([]string{"cpf"}).AddRow("123.456.789-01"))
+		mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery("SELECT cod_identificador, cpf FROM pessoa").
+			WillReturnRows(sqlmock.NewRows([]string{"cod_identificador", "cpf"}).AddRow(951716, "123.456.789-01"))
+		mock.ExpectQuery("SELECT cpf FROM pessoa").WithArgs(951716).
+			WillReturnRows(sqlmock.NewRows([]string{"cpf"}).AddRow("123.456.789-01"))
+		return adminRequest(router, "GET", target, token)
+	}
+
+	w := consultar("/admin/cpf/951716", "segredo")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "123.456.789-01")
+
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "***.456.789-**", resp["cpf_direct_query"])
+	assert.Equal(t, "***.456.789-**", resp["cpf_from_function"])
+
+	w = adminRequest(router, "GET", "/admin/cpf/951716?mostrar_cpf=true", "segredo")
+	assert.Equal(t, http.StatusForbidden, w.Code, "Credencial comum não pode exibir CPFs")
+
+	w = consultar("/admin/cpf/951716?mostrar_cpf=true", "mostrar")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "123.456.789-01", resp["cpf_direct_query"])
+}
+
+// TestAdminCacheHandlers testa as estatísticas e a limpeza dos caches de CPF e de linha
+func TestAdminCacheHandlers(t *testing.T) {
+	router := adminRouter(t, "segredo", "", true)
+	semBanco(t)
+	cpfCache.Set("951716", "123.456.789-01", false)
+	linhaCache.Set("1001", nil, true)
+
+	w := adminRequest(router, "GET", "/admin/cache", "segredo")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		CPF   CacheStats `json:"cpf"`
+		Linha CacheStats `json:"linha"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.CPF.Entradas)
+	assert.Equal(t, 1, resp.Linha.Negativas)
+	assert.NotContains(t, w.Body.String(), "123.456.789-01")
+
+	assert.Equal(t, http.StatusOK, adminRequest(router, "DELETE", "/admin/linha/cache", "segredo").Code)
+	assert.False(t, linhaCache.Contains("1001"))
+	assert.True(t, cpfCache.Contains("951716"))
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Papéis aceitos nas chaves de API e nos tokens JWT
+const (
+	roleUploader = "uploader" // envia BTCs para conversão e validação
+	roleViewer   = "viewer"   // consulta layouts e resultados
+	roleEditor   = "editor"   // mantém dados mestres (motoristas, linhas) e seus caches
+	roleAdmin    = "admin"    // diagnóstico e gestão de chaves; satisfaz qualquer papel
+	roleCPF      = "cpf"      // pode ver CPFs completos nas rotas de diagnóstico
+)
+
+var validRoles = map[string]bool{roleUploader: true, roleViewer: true, roleEditor: true, roleAdmin: true, roleCPF: true}
+
+// Chave do contexto gin com a credencial autenticada
+const ctxPrincipal = "principal"
+
+// Principal é a identidade autenticada de uma requisição
+type Principal struct {
+	Nome   string   `json:"nome"`
+	Papeis []string `json:"papeis"`
+	// Origem indica como a credencial foi validada: "bootstrap", "api_key", "jwt" ou "desabilitada"
+	Origem string `json:"origem"`
+}
+
+// Has indica se o principal tem algum dos papéis; admin satisfaz qualquer papel exceto cpf
+func (p *Principal) Has(roles ...string) bool {
+	for _, papel := range p.Papeis {
+		for _, role := range roles {
+			if papel == role || (papel == roleAdmin && role != roleCPF) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authDisabled indica se a autenticação foi desligada (AUTH_DISABLED=true, apenas desenvolvimento local)
+func authDisabled() bool {
+	return cfg.Auth.Disabled
+}
+
+// credentialFromRequest lê a credencial de "Authorization: Bearer" ou do cabeçalho X-API-Key
+func credentialFromRequest(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return strings.TrimSpace(c.GetHeader("X-API-Key"))
+}
+
+// authenticate identifica a credencial da requisição e guarda o Principal no contexto.
+// A ordem é: credenciais de bootstrap (ADMIN_TOKEN, ADMIN_UNMASK_TOKEN), JWT assinado com
+// JWT_SECRET e chaves de API da tabela api_keys.
+func authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authDisabled() {
+			c.Set(ctxPrincipal, &Principal{Nome: "anonimo", Papeis: []string{roleAdmin}, Origem: "desabilitada"})
+			c.Next()
+			return
+		}
+
+		credencial := credentialFromRequest(c)
+		if credencial == "" {
+			abortUnauthorized(c, "Credencial não enviada")
+			return
+		}
+
+		principal, err := resolvePrincipal(c.Request.Context(), credencial)
+		if err != nil {
+			if errors.Is(err, errCredencialInvalida) {
+				abortUnauthorized(c, err.Error())
+				return
+			}
+			slog.ErrorContext(c.Request.Context(), "erro ao validar credencial", "erro", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Não foi possível validar a credencial"})
+			return
+		}
+
+		c.Set(ctxPrincipal, principal)
+		c.Next()
+	}
+}
+
+var errCredencialInvalida = errors.New("credencial inválida")
+
+func abortUnauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer realm="btc-api"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+}
+
+// resolvePrincipal valida a credencial; errCredencialInvalida indica credencial recusada
+func resolvePrincipal(ctx context.Context, credencial string) (*Principal, error) {
+	if tokenMatches(credencial, cfg.Auth.AdminUnmaskToken) {
+		return &Principal{Nome: "bootstrap", Papeis: []string{roleAdmin, roleCPF}, Origem: "bootstrap"}, nil
+	}
+	if tokenMatches(credencial, cfg.Auth.AdminToken) {
+		return &Principal{Nome: "bootstrap", Papeis: []string{roleAdmin}, Origem: "bootstrap"}, nil
+	}
+
+	if strings.Count(credencial, ".") == 2 {
+		secret := cfg.Auth.JWTSecret
+		if secret == "" {
+			return nil, errCredencialInvalida
+		}
+		return verifyJWT(credencial, []byte(secret), time.Now())
+	}
+
+	return lookupAPIKey(ctx, credencial)
+}
+
+// tokenMatches compara a credencial em tempo constante; token vazio nunca é aceito
+func tokenMatches(credencial, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(credencial), []byte(token)) == 1
+}
+
+// requireRole permite a requisição se o principal tiver algum dos papéis
+func requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, _ := c.Get(ctxPrincipal)
+		p, ok := principal.(*Principal)
+		if !ok {
+			abortUnauthorized(c, "Credencial não enviada")
+			return
+		}
+		if !p.Has(roles...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("Credencial sem o papel necessário (%s)", strings.Join(roles, " ou ")),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// jwtClaims são as claims aceitas nos tokens; "roles" é aceito como sinônimo de "papeis"
+type jwtClaims struct {
+	Sub    string   `json:"sub"`
+	Papeis []string `json:"papeis"`
+	Roles  []string `json:"roles"`
+	Exp    int64    `json:"exp"`
+	Nbf    int64    `json:"nbf"`
+}
+
+// verifyJWT valida um JWT HS256 e devolve o principal com os papéis das claims.
+// Tokens sem exp são recusados.
+func verifyJWT(token string, secret []byte, now time.Time) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errCredencialInvalida
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errCredencialInvalida
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(headerJSON, &header) != nil || header.Alg != "HS256" {
+		return nil, errCredencialInvalida
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errCredencialInvalida
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errCredencialInvalida
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errCredencialInvalida
+	}
+	if claims.Exp == 0 || now.Unix() >= claims.Exp || (claims.Nbf != 0 && now.Unix() < claims.Nbf) {
+		return nil, fmt.Errorf("%w: token expirado ou ainda não válido", errCredencialInvalida)
+	}
+
+	papeis := append(claims.Papeis, claims.Roles...)
+	return &Principal{Nome: claims.Sub, Papeis: papeis, Origem: "jwt"}, nil
+}
+
+// hashAPIKey calcula o hash armazenado de uma chave de API
+func hashAPIKey(chave string) string {
+	sum := sha256.Sum256([]byte(chave))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateAPIKey gera uma chave no formato btc_<prefixo>_<segredo>
+func generateAPIKey() (chave, prefixo string, err error) {
+	id := make([]byte, 4)
+	segredo := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(segredo); err != nil {
+		return "", "", err
+	}
+	prefixo = "btc_" + hex.EncodeToString(id)
+	return prefixo + "_" + base64.RawURLEncoding.EncodeToString(segredo), prefixo, nil
+}
+
+// apiKeyUsoIntervalo é a precisão de ultimo_uso das chaves de API
+const apiKeyUsoIntervalo = time.Minute
+
+// lookupAPIKey busca uma chave de API ativa pelo hash e registra o último uso
+func lookupAPIKey(ctx context.Context, chave string) (*Principal, error) {
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+
+	var id int
+	var nome string
+	var papeis []string
+	var registrarUso bool
+	err = db.QueryRowContext(ctx, `
+		SELECT id, nome, papeis, ultimo_uso IS NULL OR ultimo_uso < now() - $2 * interval '1 millisecond'
+		FROM api_keys WHERE hash = $1 AND revogado_em IS NULL
+	`, hashAPIKey(chave), apiKeyUsoIntervalo.Milliseconds()).Scan(&id, &nome, pq.Array(&papeis), &registrarUso)
+	if err == sql.ErrNoRows {
+		return nil, errCredencialInvalida
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar chave de API: %w", err)
+	}
+
+	// ultimo_uso é gravado no máximo uma vez por apiKeyUsoIntervalo, e não a cada requisição
+	if registrarUso {
+		if _, err := db.ExecContext(ctx, "UPDATE api_keys SET ultimo_uso = now() WHERE id = $1", id); err != nil {
+			slog.WarnContext(ctx, "não foi possível registrar o uso da chave de API", "id", id, "erro", err)
+		}
+	}
+
+	return &Principal{Nome: nome, Papeis: papeis, Origem: "api_key"}, nil
+}
+
+// APIKey é uma chave de API como listada na administração (sem o hash)
+type APIKey struct {
+	ID         int        `json:"id"`
+	Nome       string     `json:"nome"`
+	Prefixo    string     `json:"prefixo"`
+	Papeis     []string   `json:"papeis"`
+	CriadoEm   time.Time  `json:"criado_em"`
+	UltimoUso  *time.Time `json:"ultimo_uso,omitempty"`
+	RevogadoEm *time.Time `json:"revogado_em,omitempty"`
+}
+
+// createAPIKeyHandler cria uma chave de API; a chave só é devolvida nesta resposta
+func createAPIKeyHandler(c *gin.Context) {
+	var req struct {
+		Nome   string   `json:"nome"`
+		Papeis []string `json:"papeis"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Nome) == "" || len(req.Papeis) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Informe nome e papeis"})
+		return
+	}
+	for _, papel := range req.Papeis {
+		if !validRoles[papel] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Papel desconhecido: %s", papel)})
+			return
+		}
+	}
+
+	ctx, cancel := statementContext(c.Request.Context())
+	defer cancel()
+
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	chave, prefixo, err := generateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao gerar chave"})
+		return
+	}
+
+	key := APIKey{Nome: strings.TrimSpace(req.Nome), Prefixo: prefixo, Papeis: req.Papeis}
+	err = db.QueryRowContext(ctx,
+		"INSERT INTO api_keys (nome, prefixo, hash, papeis) VALUES ($1, $2, $3, $4) RETURNING id, criado_em",
+		key.Nome, key.Prefixo, hashAPIKey(chave), pq.Array(key.Papeis),
+	).Scan(&key.ID, &key.CriadoEm)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "chave de API criada", "prefixo", key.Prefixo, "nome", key.Nome, "papeis", key.Papeis)
+	c.JSON(http.StatusCreated, gin.H{
+		"chave":   chave,
+		"api_key": key,
+		"message": "Guarde a chave: ela não será exibida novamente",
+	})
+}
+
+// listAPIKeysHandler lista as chaves de API, incluindo as revogadas
+func listAPIKeysHandler(c *gin.Context) {
+	ctx, cancel := statementContext(c.Request.Context())
+	defer cancel()
+
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT id, nome, prefixo, papeis, criado_em, ultimo_uso, revogado_em FROM api_keys ORDER BY id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var ultimoUso, revogadoEm sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Nome, &key.Prefixo, pq.Array(&key.Papeis), &key.CriadoEm, &ultimoUso, &revogadoEm); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if ultimoUso.Valid {
+			key.UltimoUso = &ultimoUso.Time
+		}
+		if revogadoEm.Valid {
+			key.RevogadoEm = &revogadoEm.Time
+		}
+		keys = append(keys, key)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// revokeAPIKeyHandler revoga uma chave de API; a chave deixa de ser aceita imediatamente
+func revokeAPIKeyHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+
+	ctx, cancel := statementContext(c.Request.Context())
+	defer cancel()
+
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := db.ExecContext(ctx, "UPDATE api_keys SET revogado_em = now() WHERE id = $1 AND revogado_em IS NULL", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chave não encontrada ou já revogada"})
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "chave de API revogada", "id", id)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Chave revogada"})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signJWT monta um JWT com o cabeçalho e as claims informados, assinado com HS256
+func signJWT(t *testing.T, header string, claims interface{}, secret string) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TestVerifyJWT testa assinatura, algoritmo e validade dos tokens
+func TestVerifyJWT(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	valido := map[string]interface{}{"sub": "frontend", "papeis": []string{roleUploader}, "exp": now.Add(time.Hour).Unix()}
+
+	p, err := verifyJWT(signJWT(t, hs256, valido, "segredo"), []byte("segredo"), now)
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Nome: "frontend", Papeis: []string{roleUploader}, Origem: "jwt"}, p)
+
+	comRoles := map[string]interface{}{"sub": "ci", "roles": []string{roleViewer}, "exp": now.Add(time.Hour).Unix()}
+	p, err = verifyJWT(signJWT(t, hs256, comRoles, "segredo"), []byte("segredo"), now)
+	require.NoError(t, err)
+	assert.True(t, p.Has(roleViewer))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"Segredo errado", signJWT(t, hs256, valido, "outro")},
+		{"Algoritmo none", signJWT(t, `{"alg":"none"}`, valido, "segredo")},
+		{"Expirado", signJWT(t, hs256, map[string]interface{}{"sub": "x", "exp": now.Add(-time.Minute).Unix()}, "segredo")},
+		{"Sem exp", signJWT(t, hs256, map[string]interface{}{"sub": "x"}, "segredo")},
+		{"Malformado", "a.b.c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyJWT(tt.token, []byte("segredo"), now)
+			assert.ErrorIs(t, err, errCredencialInvalida)
+		})
+	}
+}
+
+// TestPrincipalHas testa o mapeamento de papéis, com admin satisfazendo todos menos cpf
+func TestPrincipalHas(t *testing.T) {
+	admin := &Principal{Papeis: []string{roleAdmin}}
+	assert.True(t, admin.Has(roleUploader))
+	assert.True(t, admin.Has(roleEditor))
+	assert.False(t, admin.Has(roleCPF))
+
+	viewer := &Principal{Papeis: []string{roleViewer}}
+	assert.True(t, viewer.Has(roleViewer, roleUploader))
+	assert.False(t, viewer.Has(roleUploader))
+}
+
+// TestGenerateAPIKey testa o formato da chave e que o hash não contém a chave
+func TestGenerateAPIKey(t *testing.T) {
+	chave, prefixo, err := generateAPIKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(chave, prefixo+"_"))
+	assert.Len(t, prefixo, len("btc_")+8)
+	assert.Len(t, hashAPIKey(chave), 64)
+	assert.NotContains(t, hashAPIKey(chave), chave)
+}
+
+// TestNewRouter_Auth testa a exigência de credencial e papel nas rotas da API
+func TestNewRouter_Auth(t *testing.T) {
+	mock := comBancoMock(t)
+	comConfig(t, func(c *Config) { c.Auth.JWTSecret = "segredo" })
+	router := newRouter()
+
+	viewer := signJWT(t, `{"alg":"HS256"}`, map[string]interface{}{"sub": "painel", "papeis": []string{roleViewer}, "exp": time.Now().Add(time.Hour).Unix()}, "segredo")
+
+	request := func(method, target, header, credencial string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, target, nil)
+		if credencial != "" {
+			req.Header.Set(header, credencial)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("POST", "/upload", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, request("POST", "/upload", "Authorization", "Bearer "+viewer).Code, "viewer não envia BTCs")
+	assert.Equal(t, http.StatusOK, request("GET", "/layouts", "Authorization", "Bearer "+viewer).Code)
+	assert.Equal(t, http.StatusNotFound, request("GET", "/health/db", "", "").Code, "Substituído por /health/ready")
+
+	colunasChave := []string{"id", "nome", "papeis", "registrar_uso"}
+	mock.ExpectQuery("FROM api_keys WHERE hash").WithArgs(hashAPIKey("btc_chave"), int64(60000)).
+		WillReturnRows(sqlmock.NewRows(colunasChave).AddRow(7, "integracao", "{uploader}", true))
+	mock.ExpectExec("UPDATE api_keys SET ultimo_uso").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/upload", "X-API-Key", "btc_chave").Code, "Chave válida chega ao handler")
+
+	mock.ExpectQuery("FROM api_keys WHERE hash").WithArgs(hashAPIKey("btc_chave"), int64(60000)).
+		WillReturnRows(sqlmock.NewRows(colunasChave).AddRow(7, "integracao", "{uploader}", false))
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/upload", "X-API-Key", "btc_chave").Code, "Uso recente não é gravado de novo")
+
+	mock.ExpectQuery("FROM api_keys WHERE hash").WithArgs(hashAPIKey("revogada"), int64(60000)).
+		WillReturnRows(sqlmock.NewRows(colunasChave))
+	assert.Equal(t, http.StatusUnauthorized, request("POST", "/upload", "X-API-Key", "revogada").Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestAPIKeyHandlers testa a criação, listagem e revogação de chaves pelo administrador
+func TestAPIKeyHandlers(t *testing.T) {
+	router := adminRouter(t, "segredo", "", true)
+	mock := comBancoMock(t)
+	criadoEm := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("INSERT INTO api_keys").
+		WithArgs("frontend", sqlmock.AnyArg(), sqlmock.AnyArg(), `{"uploader","viewer"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "criado_em"}).AddRow(1, criadoEm))
+
+	body := bytes.NewBufferString(`{"nome":"frontend","papeis":["uploader","viewer"]}`)
+	req, _ := http.NewRequest("POST", "/admin/api-keys", body)
+	req.Header.Set("Authorization", "Bearer segredo")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created struct {
+		Chave  string `json:"chave"`
+		APIKey APIKey `json:"api_key"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.True(t, strings.HasPrefix(created.Chave, created.APIKey.Prefixo+"_"))
+	assert.Equal(t, 1, created.APIKey.ID)
+
+	w = adminRequest(router, "POST", "/admin/api-keys", "segredo")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mock.ExpectQuery("SELECT id, nome, prefixo, papeis").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "nome", "prefixo", "papeis", "criado_em", "ultimo_uso", "revogado_em"}).
+			AddRow(1, "frontend", "btc_0a1b2c3d", "{uploader}", criadoEm, nil, nil))
+	w = adminRequest(router, "GET", "/admin/api-keys", "segredo")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "hash")
+	assert.Contains(t, w.Body.String(), "btc_0a1b2c3d")
+
+	mock.ExpectExec("UPDATE api_keys SET revogado_em").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE api_keys SET revogado_em").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, http.StatusOK, adminRequest(router, "DELETE", "/admin/api-keys/1", "segredo").Code)
+	assert.Equal(t, http.StatusNotFound, adminRequest(router, "DELETE", "/admin/api-keys/2", "segredo").Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BatchFile representa um arquivo XML de BTC recebido em um lote de upload
+type BatchFile struct {
+	Nome     string
+	Conteudo []byte
+}
+
+// FileSummary resume o processamento de um arquivo do lote
+type FileSummary struct {
+	Arquivo             string `json:"arquivo"`
+	Hash                string `json:"hash"`
+	Btcs                int    `json:"btcs"`
+	Operacoes           int    `json:"operacoes"`
+	OperacoesDuplicadas int    `json:"operacoes_duplicadas"`
+	ArquivoDuplicado    bool   `json:"arquivo_duplicado,omitempty"`
+	Erro                string `json:"erro,omitempty"`
+}
+
+// BatchResult contém o resultado do processamento de um lote de arquivos
+type BatchResult struct {
+	CSVPath  string        `json:"-"`
+	Rows     []GroupedData `json:"-"`
+	Linhas   int           `json:"linhas"`
+	Arquivos []FileSummary `json:"arquivos"`
+	// Duplicados lista o conteúdo já processado em uploads anteriores, quando aceito por política ou override
+	Duplicados *DuplicateReport `json:"duplicados,omitempty"`
+	Tempos     BatchTiming      `json:"tempos"`
+	// Incompleto indica operações sem CPF ou dados da linha por falha do banco (?banco_indisponivel=marcar)
+	Incompleto           bool `json:"incompleto,omitempty"`
+	OperacoesIncompletas int  `json:"operacoes_incompletas,omitempty"`
+	// registro é o lote a gravar no histórico de uploads com BatchOptions.DeferRegister
+	registro *uploadRegistro
+}
+
+// operacaoKey monta a chave natural de uma operação (veículo, início, linha e roleta inicial)
+func operacaoKey(operacao Operacao) string {
+	return strings.Join([]string{
+		strings.TrimSpace(operacao.Veiculo),
+		strings.TrimSpace(operacao.Datainicio),
+		strings.TrimSpace(operacao.Linha),
+		strings.TrimSpace(operacao.RoletaInicial),
+	}, "|")
+}
+
+// contentHash calcula o SHA-256 do conteúdo de um arquivo
+func contentHash(conteudo []byte) string {
+	sum := sha256.Sum256(conteudo)
+	return hex.EncodeToString(sum[:])
+}
+
+// BatchOptions controla verificações opcionais do processamento de um lote
+type BatchOptions struct {
+	// CheckDuplicates consulta o histórico de uploads e registra o lote após o processamento
+	CheckDuplicates bool
+	// DuplicatePolicy define o que fazer com conteúdo já processado: "reject" ou "warn"
+	DuplicatePolicy string
+	// Force processa o lote mesmo quando há conteúdo já processado
+	Force bool
+	// Layout define colunas e formatação do CSV; nil usa o layout padrão (ANTT)
+	Layout *ExportLayout
+	// Degradado define o que fazer quando o banco falha no enriquecimento: "falhar" (padrão) ou "marcar"
+	Degradado string
+	// DeferRegister deixa o registro no histórico para quem entrega a saída (jobs): o lote fica em
+	// BatchResult e é gravado com completeJob, para uma nova tentativa não o ver como repetido
+	DeferRegister bool
+}
+
+// parsedFile é um arquivo do lote já decodificado
+type parsedFile struct {
+	BatchFile
+	Hash string
+	Btcs Btcs
+	// resumo é o índice do arquivo em BatchResult.Arquivos
+	resumo int
+}
+
+// ProcessBatch processa vários arquivos de BTC como um único lote e grava um CSV consolidado.
+// O sentido das viagens é sequenciado ao longo de todo o lote e operações repetidas
+// (mesma chave natural) ou arquivos com conteúdo idêntico são descartados.
+// Com o banco indisponível o lote é recusado com DegradedError; para gerar o CSV marcado com a
+// coluna status_enriquecimento, use ProcessBatchWithOptions com Degradado: degradadoMarcar.
+func ProcessBatch(files []BatchFile, csvPath string) (*BatchResult, error) {
+	return ProcessBatchWithOptions(files, csvPath, BatchOptions{Degradado: degradadoFalhar})
+}
+
+// ProcessBatchWithOptions processa um lote aplicando as verificações definidas em opts
+func ProcessBatchWithOptions(files []BatchFile, csvPath string, opts BatchOptions) (*BatchResult, error) {
+	return ProcessBatchContext(context.Background(), files, csvPath, opts)
+}
+
+// ProcessBatchContext é ProcessBatchWithOptions com as consultas ao banco ligadas a ctx.
+// Cancelado ctx (ex.: o cliente desconectou), o processamento para na operação seguinte,
+// o CSV parcial é removido e o lote não é registrado no histórico.
+func ProcessBatchContext(ctx context.Context, files []BatchFile, csvPath string, opts BatchOptions) (*BatchResult, error) {
+	ctx, span := tracer().Start(ctx, "lote", trace.WithAttributes(
+		attribute.Int("btc.arquivos", len(files)),
+		attribute.Int("btc.bytes", batchBytes(files)),
+	))
+	defer span.End()
+
+	result, err := processBatch(ctx, files, csvPath, opts)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.Int("btc.linhas", result.Linhas),
+		attribute.Int("btc.operacoes_incompletas", result.OperacoesIncompletas),
+	)
+	return result, nil
+}
+
+// processBatch executa as fases do lote (parse, enrich, write), cada uma com o seu span
+func processBatch(ctx context.Context, files []BatchFile, csvPath string, opts BatchOptions) (*BatchResult, error) {
+	result := &BatchResult{CSVPath: csvPath}
+
+	_, fase := startPhase(ctx, faseParse)
+	parsed, err := parseBatch(files, result)
+	fase.End(err, attribute.Int("btc.operacoes", countOperacoes(parsed)))
+	if err != nil {
+		return nil, err
+	}
+	progresso := progressFrom(ctx)
+	progresso.lidos(result, countOperacoes(parsed))
+
+	if opts.CheckDuplicates {
+		report, err := checkDuplicates(ctx, parsed)
+		if err != nil && ctx.Err() != nil {
+			return nil, fmt.Errorf("processamento cancelado: %w", ctx.Err())
+		} else if err != nil {
+			slog.WarnContext(ctx, "não foi possível verificar uploads repetidos", "erro", err)
+		} else if !report.Empty() {
+			if opts.DuplicatePolicy != "warn" && !opts.Force {
+				return nil, &DuplicateUploadError{Report: report}
+			}
+			result.Duplicados = report
+			progresso.aviso("%d arquivo(s) e %d operação(ões) já processados em uploads anteriores",
+				len(report.ArquivosRepetidos), len(report.OperacoesRepetidas))
+		}
+	}
+
+	enrichCtx, fase := startPhase(ctx, faseEnriquecimento)
+	enrichCtx, uso := withCacheUsage(enrichCtx)
+	enriched, err := enrichBatch(enrichCtx, parsed, result, true)
+	fase.End(err, append(uso.attributes(), attribute.Int("btc.operacoes", len(enriched)))...)
+	if err != nil {
+		return nil, err
+	}
+
+	operacoesData := make([]GroupedData, 0, len(enriched))
+	registros := make([]operacaoRegistro, 0, len(enriched))
+	var primeiroErroBanco error
+	for _, e := range enriched {
+		operacoesData = append(operacoesData, e.Dados)
+		registros = append(registros, operacaoRegistro{Chave: e.Chave, Hash: e.Hash, Operacao: e.Operacao})
+		if len(e.Info.ErrosBanco) > 0 {
+			result.OperacoesIncompletas++
+			if primeiroErroBanco == nil {
+				primeiroErroBanco = e.Info.ErrosBanco[0]
+			}
+		}
+	}
+
+	// Operações sem enriquecimento por falha do banco: recusar o lote ou marcar a saída
+	if result.OperacoesIncompletas > 0 {
+		if opts.Degradado != degradadoMarcar {
+			return nil, &DegradedError{Incompletas: result.OperacoesIncompletas, Total: len(enriched), Err: primeiroErroBanco}
+		}
+		result.Incompleto = true
+		progresso.aviso("%d operações sem enriquecimento por falha do banco", result.OperacoesIncompletas)
+		slog.WarnContext(ctx, "operações sem enriquecimento por falha do banco; saída marcada como incompleta",
+			"incompletas", result.OperacoesIncompletas, "total", len(enriched), "erro", primeiroErroBanco)
+	}
+
+	// Sem csvPath as linhas ficam apenas em result.Rows (saída em JSON ou XLSX)
+	if csvPath != "" {
+		layout := opts.Layout
+		if layout == nil {
+			layout = defaultLayout()
+		}
+		if result.Incompleto {
+			layout = withStatusColumn(layout)
+		}
+		_, fase := startPhase(ctx, faseEscrita, attribute.String("btc.layout", layout.Nome))
+		err := writeLayoutFile(csvPath, layout, operacoesData)
+		fase.End(err, attribute.Int("btc.linhas", len(operacoesData)))
+		if err != nil {
+			os.Remove(csvPath)
+			return nil, err
+		}
+		progresso.escritas(len(operacoesData))
+	}
+
+	// Cancelado durante a gravação: a saída não será entregue nem o lote registrado
+	if err := ctx.Err(); err != nil {
+		if csvPath != "" {
+			os.Remove(csvPath)
+		}
+		return nil, fmt.Errorf("processamento cancelado: %w", err)
+	}
+
+	if opts.CheckDuplicates && opts.DeferRegister {
+		result.registro = &uploadRegistro{files: parsed, registros: registros}
+	} else if opts.CheckDuplicates {
+		if err := registerUpload(ctx, parsed, registros); err != nil {
+			slog.WarnContext(ctx, "não foi possível registrar o lote no histórico de uploads", "erro", err)
+		}
+	}
+
+	result.Rows = operacoesData
+	result.Linhas = len(operacoesData)
+	recordBatchMetrics(result, enriched)
+	slog.InfoContext(ctx, "lote processado", "arquivos", len(result.Arquivos), "operacoes", len(enriched),
+		"linhas", result.Linhas, "incompletas", result.OperacoesIncompletas)
+	return result, nil
+}
+
+// batchBytes soma o tamanho dos arquivos do lote
+func batchBytes(files []BatchFile) int {
+	total := 0
+	for _, f := range files {
+		total += len(f.Conteudo)
+	}
+	return total
+}
+
+// countOperacoes conta as operações dos arquivos decodificados, antes de descartar as repetidas
+func countOperacoes(parsed []parsedFile) int {
+	total := 0
+	for _, f := range parsed {
+		for _, btc := range f.Btcs.Btc {
+			total += len(btc.Operacoes.Operacao)
+		}
+	}
+	return total
+}
+
+// parseBatch decodifica os arquivos do lote, descartando arquivos com conteúdo idêntico.
+// Arquivos inválidos são registrados no resumo; só há erro se nenhum arquivo puder ser lido
+// ou se o lote exceder os limites de estrutura (*XMLLimitError).
+func parseBatch(files []BatchFile, result *BatchResult) ([]parsedFile, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("nenhum arquivo XML no lote")
+	}
+	// Limites de estrutura recusam o lote inteiro, antes de decodificar qualquer arquivo
+	if err := checkBatchLimits(files); err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string)
+	var parsed []parsedFile
+	var firstErr error
+
+	for _, f := range files {
+		summary := FileSummary{Arquivo: f.Nome, Hash: contentHash(f.Conteudo)}
+
+		if original, existe := hashes[summary.Hash]; existe {
+			summary.ArquivoDuplicado = true
+			summary.Erro = fmt.Sprintf("conteúdo idêntico a %s", original)
+			result.Arquivos = append(result.Arquivos, summary)
+			continue
+		}
+		hashes[summary.Hash] = f.Nome
+
+		var btcs Btcs
+		if err := xml.Unmarshal(f.Conteudo, &btcs); err != nil {
+			summary.Erro = err.Error()
+			result.Arquivos = append(result.Arquivos, summary)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", f.Nome, err)
+			}
+			continue
+		}
+
+		summary.Btcs = len(btcs.Btc)
+		parsed = append(parsed, parsedFile{BatchFile: f, Hash: summary.Hash, Btcs: btcs, resumo: len(result.Arquivos)})
+		result.Arquivos = append(result.Arquivos, summary)
+	}
+
+	// Nenhum arquivo pôde ser lido: devolver o erro do primeiro
+	if firstErr != nil && len(parsed) == 0 {
+		return nil, firstErr
+	}
+
+	return parsed, nil
+}
+
+// enrichedOperacao é uma operação do lote já enriquecida, com sua origem no arquivo
+type enrichedOperacao struct {
+	Arquivo  string
+	Hash     string
+	Doc      string
+	Matdmtu  string
+	Indice   int
+	Chave    string
+	Operacao Operacao
+	Dados    GroupedData
+	Info     enrichmentInfo
+	// Err é o erro de enriquecimento quando strict é falso
+	Err error
+}
+
+// enrichBatch enriquece as operações do lote, sequenciando o sentido ao longo de todos os arquivos.
+// Com strict, o primeiro erro interrompe o lote; sem strict, o erro fica registrado na operação.
+func enrichBatch(ctx context.Context, parsed []parsedFile, result *BatchResult, strict bool) ([]enrichedOperacao, error) {
+	placas := vehiclePlates()
+	progresso := progressFrom(ctx)
+
+	// Motoristas e linhas do lote inteiro são carregados antes, com uma consulta por tabela
+	prefetchMasterData(ctx, parsed, &result.Tempos)
+	inicio := time.Now()
+	defer func() { result.Tempos.EnriquecimentoMs = time.Since(inicio).Milliseconds() }()
+
+	linhaCount := make(map[string]int)
+	operacoesVistas := make(map[string]bool)
+	var enriched []enrichedOperacao
+
+	for _, f := range parsed {
+		summary := &result.Arquivos[f.resumo]
+		for _, btc := range f.Btcs.Btc {
+			for i, operacao := range btc.Operacoes.Operacao {
+				chave := operacaoKey(operacao)
+				if operacoesVistas[chave] {
+					summary.OperacoesDuplicadas++
+					continue
+				}
+				operacoesVistas[chave] = true
+
+				// Calcular sentido
+				linhaCount[operacao.Linha]++
+				sentido := "GO-DF"
+				if linhaCount[operacao.Linha]%2 == 0 {
+					sentido = "DF-GO"
+				}
+
+				operacaoData, info, err := buildGroupedData(ctx, btc, operacao, sentido, placas)
+				if ctx.Err() != nil {
+					return nil, fmt.Errorf("processamento cancelado em %s (btc %s): %w", f.Nome, btc.Doc, ctx.Err())
+				}
+				if err != nil && strict {
+					return nil, fmt.Errorf("%s (btc %s): %w", f.Nome, btc.Doc, err)
+				}
+				if err == nil {
+					summary.Operacoes++
+				}
+				progresso.enriquecida()
+
+				enriched = append(enriched, enrichedOperacao{
+					Arquivo:  f.Nome,
+					Hash:     f.Hash,
+					Doc:      btc.Doc,
+					Matdmtu:  strings.TrimSpace(btc.Matdmtu),
+					Indice:   i,
+					Chave:    chave,
+					Operacao: operacao,
+					Dados:    operacaoData,
+					Info:     info,
+					Err:      err,
+				})
+			}
+		}
+	}
+
+	return enriched, nil
+}
+
+// expandUpload converte um arquivo enviado em arquivos XML do lote. O tipo vem do conteúdo, não
+// do nome: zip e gzip (.tar.gz) são descompactados, XML segue como está e o resto é recusado
+// com ErrConteudoNaoSuportado. A descompactação respeita upload.max_expanded_bytes e max_files.
+func expandUpload(nome string, conteudo []byte) ([]BatchFile, error) {
+	switch sniffContent(conteudo) {
+	case conteudoZip:
+		return expandZip(nome, conteudo)
+	case conteudoGzip:
+		return expandTarGz(nome, conteudo)
+	case conteudoXML:
+		return []BatchFile{{Nome: nome, Conteudo: conteudo}}, nil
+	}
+	return nil, fmt.Errorf("%w: %s não é XML, .zip nem .tar.gz", ErrConteudoNaoSuportado, nome)
+}
+
+// addArchiveEntry acrescenta uma entrada descompactada, conferindo o conteúdo e o número de arquivos
+func addArchiveEntry(files []BatchFile, nome string, data []byte) ([]BatchFile, error) {
+	if sniffContent(data) != conteudoXML {
+		return nil, fmt.Errorf("%w: %s não é XML", ErrConteudoNaoSuportado, nome)
+	}
+	if len(files) >= cfg.Upload.MaxFiles {
+		return nil, fmt.Errorf("%w: mais de %d arquivos XML", ErrUploadGrande, cfg.Upload.MaxFiles)
+	}
+	return append(files, BatchFile{Nome: nome, Conteudo: data}), nil
+}
+
+// isXMLEntry indica se uma entrada de arquivo compactado deve ser processada
+func isXMLEntry(nome string) bool {
+	base := path.Base(nome)
+	if strings.HasPrefix(base, ".") || strings.HasPrefix(nome, "__MACOSX/") {
+		return false
+	}
+	return strings.EqualFold(path.Ext(base), ".xml")
+}
+
+func expandZip(nome string, conteudo []byte) ([]BatchFile, error) {
+	reader, err := zip.NewReader(bytes.NewReader(conteudo), int64(len(conteudo)))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir arquivo zip %s: %w", nome, err)
+	}
+
+	var files []BatchFile
+	restante := int64(cfg.Upload.MaxExpandedBytes)
+	for _, entry := range reader.File {
+		if entry.FileInfo().IsDir() || !isXMLEntry(entry.Name) {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler %s em %s: %w", entry.Name, nome, err)
+		}
+		data, err := readLimited(rc, nome, &restante)
+		rc.Close()
+		if errors.Is(err, ErrUploadGrande) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler %s em %s: %w", entry.Name, nome, err)
+		}
+		if files, err = addArchiveEntry(files, nome+"/"+entry.Name, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("arquivo %s não contém XML", nome)
+	}
+	return files, nil
+}
+
+func expandTarGz(nome string, conteudo []byte) ([]BatchFile, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(conteudo))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir arquivo gzip %s: %w", nome, err)
+	}
+	defer gz.Close()
+
+	var files []BatchFile
+	restante := int64(cfg.Upload.MaxExpandedBytes)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler arquivo tar %s: %w", nome, err)
+		}
+		if header.Typeflag != tar.TypeReg || !isXMLEntry(header.Name) {
+			continue
+		}
+		data, err := readLimited(tr, nome, &restante)
+		if errors.Is(err, ErrUploadGrande) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler %s em %s: %w", header.Name, nome, err)
+		}
+		if files, err = addArchiveEntry(files, nome+"/"+header.Name, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("arquivo %s não contém XML", nome)
+	}
+	return files, nil
+}
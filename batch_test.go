@@ -0,0 +1,324 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// semBanco marca o pool como indisponível para que os testes não tentem conectar ao banco
+func semBanco(t *testing.T) {
+	t.Helper()
+
+	originalDBConn := dbConn
+	dbConn = connManagerFalho(errors.New("banco desabilitado no teste"))
+
+	cpfCache.Clear()
+	linhaCache.Clear()
+	originalBreaker := dbBreaker
+	dbBreaker = newCircuitBreaker(defaultConfig().DB)
+
+	t.Cleanup(func() {
+		dbBreaker = originalBreaker
+		dbConn = originalDBConn
+	})
+}
+
+// semCadastro simula o banco sem parâmetros para as linhas informadas: os caches já sabem que
+// elas não existem, então o lote é processado sem consultar o banco e sem ficar incompleto
+func semCadastro(t *testing.T, linhas ...string) {
+	t.Helper()
+	semBanco(t)
+	for _, linha := range linhas {
+		linhaCache.Set(linha, nil, true)
+	}
+}
+
+// operacaoXML monta uma operação no formato de elementos usado pelo exportador de BTC
+func operacaoXML(veiculo, linha, roleta, inicio, fim string) string {
+	return fmt.Sprintf(`<operacao>
+        <codigoEmpresa>1</codigoEmpresa>
+        <veiculo>%s</veiculo>
+        <linha>%s</linha>
+        <roletaInicial>%s</roletaInicial>
+        <roletaFinal>9999</roletaFinal>
+        <totalPassageiros>30</totalPassageiros>
+        <passageiros>
+          <passageiro><tipo>1</tipo><qtd>20</qtd></passageiro>
+          <passageiro><tipo>4</tipo><qtd>10</qtd></passageiro>
+        </passageiros>
+        <datainicio>%s</datainicio>
+        <datafim>%s</datafim>
+      </operacao>`, veiculo, linha, roleta, inicio, fim)
+}
+
+// btcXML monta um arquivo de BTC com um único btc contendo as operações informadas
+func btcXML(doc, matdmtu string, operacoes ...string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<btcs DataIni="2024-01-15" DataFim="2024-01-15">
+  <btc>
+    <doc>%s</doc>
+    <matdmtu>%s</matdmtu>
+    <operacoes>
+      %s
+    </operacoes>
+  </btc>
+</btcs>`, doc, matdmtu, strings.Join(operacoes, "\n      "))
+}
+
+// readCSV lê o CSV gerado e devolve todas as linhas, incluindo o cabeçalho
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = ';'
+	rows, err := reader.ReadAll()
+	require.NoError(t, err)
+	return rows
+}
+
+// TestProcessBatch_SentidoAcrossFiles testa se o sentido é sequenciado ao longo do lote
+func TestProcessBatch_SentidoAcrossFiles(t *testing.T) {
+	semBanco(t)
+
+	files := []BatchFile{
+		{Nome: "garagem1.xml", Conteudo: []byte(btcXML("1", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00")))},
+		{Nome: "garagem2.xml", Conteudo: []byte(btcXML("2", "", operacaoXML("1002", "1001", "200", "2024-01-15 10:00:00", "2024-01-15 11:00:00")))},
+	}
+
+	csvPath := filepath.Join(t.TempDir(), "output.csv")
+	result, err := ProcessBatchWithOptions(files, csvPath, BatchOptions{Degradado: degradadoMarcar})
+	require.NoError(t, err)
+
+	rows := readCSV(t, csvPath)
+	require.Len(t, rows, 3, "Cabeçalho mais uma linha por arquivo")
+	assert.Equal(t, "GO-DF", rows[1][3], "Primeira ocorrência da linha deve ser GO-DF")
+	assert.Equal(t, "DF-GO", rows[2][3], "Segunda ocorrência, em outro arquivo, deve ser DF-GO")
+
+	assert.Equal(t, 2, result.Linhas)
+	require.Len(t, result.Arquivos, 2)
+	assert.Equal(t, 1, result.Arquivos[0].Operacoes)
+	assert.Equal(t, 1, result.Arquivos[1].Operacoes)
+}
+
+// TestProcessBatch_DuplicateOperations testa o descarte de operações repetidas entre arquivos
+func TestProcessBatch_DuplicateOperations(t *testing.T) {
+	semBanco(t)
+
+	op := operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00")
+	files := []BatchFile{
+		{Nome: "a.xml", Conteudo: []byte(btcXML("1", "", op))},
+		{Nome: "b.xml", Conteudo: []byte(btcXML("2", "", op, operacaoXML("1001", "1001", "300", "2024-01-15 12:00:00", "2024-01-15 13:00:00")))},
+	}
+
+	csvPath := filepath.Join(t.TempDir(), "output.csv")
+	result, err := ProcessBatchWithOptions(files, csvPath, BatchOptions{Degradado: degradadoMarcar})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, result.Linhas)
+	assert.Equal(t, 0, result.Arquivos[0].OperacoesDuplicadas)
+	assert.Equal(t, 1, result.Arquivos[1].OperacoesDuplicadas)
+	assert.Equal(t, 1, result.Arquivos[1].Operacoes)
+}
+
+// TestProcessBatch_DuplicateFile testa o descarte de arquivos com conteúdo idêntico
+func TestProcessBatch_DuplicateFile(t *testing.T) {
+	semBanco(t)
+
+	conteudo := []byte(btcXML("1", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00")))
+	files := []BatchFile{
+		{Nome: "a.xml", Conteudo: conteudo},
+		{Nome: "copia.xml", Conteudo: conteudo},
+	}
+
+	result, err := ProcessBatchWithOptions(files, filepath.Join(t.TempDir(), "output.csv"), BatchOptions{Degradado: degradadoMarcar})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, result.Linhas)
+	assert.True(t, result.Arquivos[1].ArquivoDuplicado)
+	assert.Contains(t, result.Arquivos[1].Erro, "a.xml")
+}
+
+// TestProcessBatch_InvalidFileInBatch testa que um XML inválido não derruba o lote inteiro
+func TestProcessBatch_InvalidFileInBatch(t *testing.T) {
+	semBanco(t)
+
+	files := []BatchFile{
+		{Nome: "quebrado.xml", Conteudo: []byte("XML inválido")},
+		{Nome: "ok.xml", Conteudo: []byte(btcXML("1", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00")))},
+	}
+
+	result, err := ProcessBatchWithOptions(files, filepath.Join(t.TempDir(), "output.csv"), BatchOptions{Degradado: degradadoMarcar})
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, result.Arquivos[0].Erro)
+	assert.Empty(t, result.Arquivos[1].Erro)
+	assert.Equal(t, 1, result.Linhas)
+
+	_, err = ProcessBatch(files[:1], filepath.Join(t.TempDir(), "output.csv"))
+	assert.Error(t, err, "Lote sem nenhum arquivo válido deve falhar")
+}
+
+// TestExpandUpload_Zip testa a extração de XMLs de um arquivo zip
+func TestExpandUpload_Zip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, nome := range []string{"a.xml", "sub/b.XML", "leia-me.txt", "__MACOSX/._a.xml"} {
+		w, err := zw.Create(nome)
+		require.NoError(t, err)
+		w.Write([]byte("<btcs/>"))
+	}
+	require.NoError(t, zw.Close())
+
+	files, err := expandUpload("lote.zip", buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "lote.zip/a.xml", files[0].Nome)
+	assert.Equal(t, "lote.zip/sub/b.XML", files[1].Nome)
+}
+
+// TestExpandUpload_TarGz testa a extração de XMLs de um arquivo .tar.gz
+func TestExpandUpload_TarGz(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	conteudo := []byte("<btcs/>")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "a.xml", Mode: 0600, Size: int64(len(conteudo)), Typeflag: tar.TypeReg}))
+	tw.Write(conteudo)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	files, err := expandUpload("lote.tar.gz", buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "lote.tar.gz/a.xml", files[0].Nome)
+	assert.Equal(t, conteudo, files[0].Conteudo)
+
+	_, err = expandUpload("vazio.zip", []byte("não é zip"))
+	assert.Error(t, err)
+}
+
+// TestUploadHandler_MultipleFiles testa o envio de vários arquivos em uma única requisição
+func TestUploadHandler_MultipleFiles(t *testing.T) {
+	semCadastro(t, "1001", "1002")
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	router := gin.New()
+	router.POST("/upload", uploadHandler)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for i, linha := range []string{"1001", "1002"} {
+		part, err := writer.CreateFormFile("file", fmt.Sprintf("garagem%d.xml", i))
+		require.NoError(t, err)
+		part.Write([]byte(btcXML("1", "", operacaoXML("1001", linha, "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"))))
+	}
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	assert.Len(t, strings.Split(strings.TrimSpace(w.Body.String()), "\n"), 3)
+
+	var summary BatchResult
+	require.NoError(t, json.Unmarshal([]byte(w.Header().Get("X-Upload-Summary")), &summary))
+	assert.Equal(t, 2, summary.Linhas)
+	assert.Len(t, summary.Arquivos, 2)
+}
+
+// TestProcessBatchContext_Canceled testa que o lote cancelado para antes de gravar a saída
+func TestProcessBatchContext_Canceled(t *testing.T) {
+	semCadastro(t, "1001")
+	files := []BatchFile{{Nome: "garagem.xml", Conteudo: []byte(btcXML("1", "",
+		operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"),
+		operacaoXML("1001", "1001", "200", "2024-01-15 10:00:00", "2024-01-15 11:00:00")))}}
+	csvPath := filepath.Join(t.TempDir(), "output.csv")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ProcessBatchContext(ctx, files, csvPath, BatchOptions{Degradado: degradadoMarcar})
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Contains(t, err.Error(), "garagem.xml")
+	assert.NoFileExists(t, csvPath)
+}
+
+// TestUploadHandler_Canceled testa que o upload do cliente que desconectou não gera saída
+func TestUploadHandler_Canceled(t *testing.T) {
+	semCadastro(t, "1001")
+	gin.SetMode(gin.TestMode)
+
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	router := gin.New()
+	router.POST("/upload", uploadHandler)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "garagem.xml")
+	require.NoError(t, err)
+	part.Write([]byte(btcXML("1", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"))))
+	writer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, statusClientClosedRequest, w.Code)
+	assert.Empty(t, saidasCSV(t))
+}
+
+// saidasCSV lista os arquivos de saída de uploads deixados em workspaceDir
+func saidasCSV(t *testing.T) []string {
+	t.Helper()
+	saidas, err := filepath.Glob(filepath.Join(workspaceDir, "output-*.csv"))
+	require.NoError(t, err)
+	return saidas
+}
+
+// TestHeaderJSON_ASCII testa que o resumo no cabeçalho não contém caracteres fora do ASCII
+func TestHeaderJSON_ASCII(t *testing.T) {
+	value := headerJSON(map[string]string{"arquivo": "garagem-são-josé.xml"})
+	for _, r := range value {
+		assert.Less(t, r, rune(128))
+	}
+
+	var decoded map[string]string
+	require.NoError(t, json.Unmarshal([]byte(value), &decoded))
+	assert.Equal(t, "garagem-são-josé.xml", decoded["arquivo"])
+}
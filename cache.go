@@ -0,0 +1,241 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// cacheNotifyChannel é o canal do LISTEN/NOTIFY usado pelos gatilhos de pessoa e parametro_viagem
+const cacheNotifyChannel = "btc_cache"
+
+// CacheStats são os contadores de uso de um cache
+type CacheStats struct {
+	Entradas  int    `json:"entradas"`
+	Negativas int    `json:"negativas"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Expiradas uint64 `json:"expiradas"`
+	Removidas uint64 `json:"removidas"`
+}
+
+// ttlCache é um cache LRU com validade por entrada; resultados negativos (não encontrado)
+// usam uma validade própria, normalmente menor, para que cadastros novos apareçam logo
+type ttlCache[V any] struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	entries     map[string]*list.Element
+	order       *list.List
+	stats       CacheStats
+	now         func() time.Time
+}
+
+type cacheEntry[V any] struct {
+	key      string
+	value    V
+	negative bool
+	expires  time.Time
+}
+
+func newTTLCache[V any](c CacheConfig) *ttlCache[V] {
+	return &ttlCache[V]{
+		ttl:         c.TTL.Duration,
+		negativeTTL: c.NegativeTTL.Duration,
+		maxEntries:  c.MaxEntries,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+		now:         time.Now,
+	}
+}
+
+// Get devolve o valor em cache e se ele existe e ainda é válido
+func (c *ttlCache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return zero, false
+	}
+
+	entry := el.Value.(*cacheEntry[V])
+	if !c.now().Before(entry.expires) {
+		c.remove(el)
+		c.stats.Expiradas++
+		c.stats.Misses++
+		return zero, false
+	}
+
+	c.order.MoveToFront(el)
+	c.stats.Hits++
+	return entry.value, true
+}
+
+// Contains informa se a chave tem valor válido, sem alterar os contadores nem a ordem LRU
+func (c *ttlCache[V]) Contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	return ok && c.now().Before(el.Value.(*cacheEntry[V]).expires)
+}
+
+// Set guarda um valor; negative indica que o registro não existe no banco
+func (c *ttlCache[V]) Set(key string, value V, negative bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ttl := c.ttl
+	if negative {
+		ttl = c.negativeTTL
+	}
+	entry := &cacheEntry[V]{key: key, value: value, negative: negative, expires: c.now().Add(ttl)}
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+
+	// Acima do limite, descartar as entradas usadas há mais tempo
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+		c.stats.Removidas++
+	}
+}
+
+// Delete remove uma chave do cache
+func (c *ttlCache[V]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+// DeleteFunc remove as chaves para as quais match devolve true
+func (c *ttlCache[V]) DeleteFunc(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, el := range c.entries {
+		if match(key) {
+			c.remove(el)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Clear esvazia o cache, mantendo os contadores
+func (c *ttlCache[V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Stats devolve os contadores e o tamanho atual do cache
+func (c *ttlCache[V]) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entradas = c.order.Len()
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		if el.Value.(*cacheEntry[V]).negative {
+			stats.Negativas++
+		}
+	}
+	return stats
+}
+
+func (c *ttlCache[V]) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry[V]).key)
+}
+
+// configureCaches recria os caches de CPF e de linha com a configuração carregada
+func configureCaches(c CacheConfig) {
+	cpfCache = newTTLCache[string](c)
+	linhaCache = newTTLCache[*ParametroViagem](c)
+}
+
+// cacheInvalidation é o payload JSON enviado pelos gatilhos em NOTIFY btc_cache
+type cacheInvalidation struct {
+	Tabela string `json:"tabela"`
+	Chave  int    `json:"chave"`
+}
+
+// sameCode compara uma chave do cache (como veio no XML) com o código numérico do banco
+func sameCode(key string, code int) bool {
+	n, err := strconv.Atoi(strings.TrimSpace(key))
+	return err == nil && n == code
+}
+
+// applyCacheInvalidation remove do cache local as entradas afetadas por uma notificação
+func applyCacheInvalidation(payload string) {
+	var inv cacheInvalidation
+	if err := json.Unmarshal([]byte(payload), &inv); err != nil {
+		slog.Warn("notificação de cache inválida", "payload", payload, "erro", err)
+		return
+	}
+
+	match := func(key string) bool { return sameCode(key, inv.Chave) }
+	switch inv.Tabela {
+	case "pessoa":
+		cpfCache.DeleteFunc(match)
+	case "parametro_viagem":
+		linhaCache.DeleteFunc(match)
+	default:
+		slog.Warn("notificação de cache para tabela desconhecida", "tabela", inv.Tabela)
+	}
+}
+
+// startCacheListener escuta o canal btc_cache para que todas as réplicas invalidem os caches
+// assim que pessoa ou parametro_viagem mudam. Após uma reconexão os caches são esvaziados,
+// pois notificações podem ter sido perdidas.
+func startCacheListener(databaseURL string) (*pq.Listener, error) {
+	listener := pq.NewListener(databaseURL, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("erro no listener de cache", "erro", err)
+		}
+	})
+	if err := listener.Listen(cacheNotifyChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case n, ok := <-listener.Notify:
+				if !ok {
+					return
+				}
+				if n == nil {
+					slog.Info("listener de cache reconectado; esvaziando caches")
+					cpfCache.Clear()
+					linhaCache.Clear()
+					continue
+				}
+				applyCacheInvalidation(n.Extra)
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return listener, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// novoCacheTeste cria um cache com relógio controlado pelo teste
+func novoCacheTeste(maxEntries int) (*ttlCache[string], *time.Time) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	c := newTTLCache[string](CacheConfig{TTL: Duration{time.Hour}, NegativeTTL: Duration{time.Minute}, MaxEntries: maxEntries})
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+// TestTTLCache_Expiry testa a validade própria dos resultados negativos
+func TestTTLCache_Expiry(t *testing.T) {
+	c, now := novoCacheTeste(10)
+	c.Set("951716", "123.456.789-01", false)
+	c.Set("951717", "", true)
+
+	*now = now.Add(2 * time.Minute)
+	_, ok := c.Get("951717")
+	assert.False(t, ok, "Negativo expira com negative_ttl")
+	cpf, ok := c.Get("951716")
+	assert.True(t, ok)
+	assert.Equal(t, "123.456.789-01", cpf)
+
+	*now = now.Add(time.Hour)
+	assert.False(t, c.Contains("951716"))
+	_, ok = c.Get("951716")
+	assert.False(t, ok)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, uint64(2), stats.Expiradas)
+	assert.Equal(t, 0, stats.Entradas)
+}
+
+// TestTTLCache_MaxEntries testa o descarte da entrada usada há mais tempo
+func TestTTLCache_MaxEntries(t *testing.T) {
+	c, _ := novoCacheTeste(2)
+	c.Set("a", "1", false)
+	c.Set("b", "2", false)
+	c.Get("a")
+	c.Set("c", "", true)
+
+	assert.True(t, c.Contains("a"))
+	assert.False(t, c.Contains("b"), "b era a menos usada")
+	assert.True(t, c.Contains("c"))
+
+	stats := c.Stats()
+	assert.Equal(t, 2, stats.Entradas)
+	assert.Equal(t, 1, stats.Negativas)
+	assert.Equal(t, uint64(1), stats.Removidas)
+}
+
+// TestApplyCacheInvalidation testa a remoção das chaves notificadas pelos gatilhos
+func TestApplyCacheInvalidation(t *testing.T) {
+	semBanco(t)
+	cpfCache.Set("951716", "", true)
+	cpfCache.Set("0951716", "", true)
+	cpfCache.Set("951717", "123.456.789-01", false)
+	linhaCache.Set("1001", nil, true)
+
+	applyCacheInvalidation(`{"tabela":"pessoa","chave":951716}`)
+	assert.False(t, cpfCache.Contains("951716"))
+	assert.False(t, cpfCache.Contains("0951716"), "Chave do XML com zeros à esquerda")
+	assert.True(t, cpfCache.Contains("951717"))
+	assert.True(t, linhaCache.Contains("1001"))
+
+	applyCacheInvalidation(`{"tabela":"parametro_viagem","chave":1001}`)
+	assert.False(t, linhaCache.Contains("1001"))
+
+	applyCacheInvalidation(`inválido`)
+	assert.True(t, cpfCache.Contains("951717"))
+}
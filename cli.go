@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// usage descreve os subcomandos do binário
+const usage = `uso: web-service-transdata <comando> [opções]
+
+comandos:
+  serve                     inicia a API HTTP (padrão sem comando)
+  convert --in btc.xml --out viagens.csv [--format csv|json|xlsx] [--layout nome]
+                            converte arquivos de BTC (XML, .zip ou .tar.gz) sem o servidor
+  validate btc.xml ...      confere um lote e imprime o relatório em JSON; sai com 1 se inválido
+  watch --inbox dir --outbox dir [--processed dir] [--failed dir] [--format csv|json|xlsx]
+        [--interval 10s] [--settle 30s]
+                            converte cada arquivo novo da caixa de entrada quando para de mudar,
+                            movendo-o para processed/ ou failed/ (com <arquivo>.erro.txt)
+  worker [--concurrency n]  executa os uploads enfileirados em POST /jobs e envia as notificações
+                            dos webhooks (pode rodar em várias réplicas)
+  migrate up | down [n] | status
+                            gerencia o esquema do banco
+
+dados mestres (convert, validate e watch): sem as opções abaixo, CPFs e linhas vêm do banco (DATABASE_URL)
+  --motoristas arquivo      CSV ou JSON com cod_identificador e cpf
+  --linhas arquivo          CSV ou JSON com as colunas de parametro_viagem (cod_linha, local1, ...)
+  --veiculos arquivo        CSV ou JSON com veiculo e placa (substitui as placas embutidas)
+`
+
+// errLoteInvalido indica que validate encontrou problemas no lote (o relatório já foi impresso)
+var errLoteInvalido = errors.New("lote inválido")
+
+// arquivosFlag acumula uma opção repetida (--in a.xml --in b.xml)
+type arquivosFlag []string
+
+func (a *arquivosFlag) String() string { return strings.Join(*a, ",") }
+
+func (a *arquivosFlag) Set(v string) error {
+	*a = append(*a, v)
+	return nil
+}
+
+// masterDataFlags são as opções de dados mestres em arquivos locais
+type masterDataFlags struct {
+	motoristas, linhas, veiculos string
+}
+
+func (m *masterDataFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&m.motoristas, "motoristas", "", "CSV ou JSON com cod_identificador e cpf")
+	fs.StringVar(&m.linhas, "linhas", "", "CSV ou JSON com as colunas de parametro_viagem")
+	fs.StringVar(&m.veiculos, "veiculos", "", "CSV ou JSON com veiculo e placa")
+}
+
+// local indica se CPFs e linhas vêm de arquivos, dispensando o banco
+func (m masterDataFlags) local() bool {
+	return m.motoristas != "" || m.linhas != ""
+}
+
+// load lê os arquivos informados; nil sem nenhum deles (tudo vem do banco e das placas embutidas)
+func (m masterDataFlags) load() (*localMasterData, error) {
+	if m.local() && (m.motoristas == "" || m.linhas == "") {
+		return nil, fmt.Errorf("--motoristas e --linhas devem ser informados juntos")
+	}
+	if !m.local() && m.veiculos == "" {
+		return nil, nil
+	}
+	return loadLocalMasterData(m.motoristas, m.linhas, m.veiculos)
+}
+
+// convertOptions são as opções de "convert"
+type convertOptions struct {
+	entradas  []string
+	saida     string
+	formato   string
+	layout    string
+	degradado string
+	master    masterDataFlags
+}
+
+// validateOptions são as opções de "validate"
+type validateOptions struct {
+	entradas []string
+	master   masterDataFlags
+}
+
+// parseInterspersed aceita as opções antes ou depois dos arquivos (validate btc.xml --linhas l.csv)
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var posicionais []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return posicionais, nil
+		}
+		posicionais = append(posicionais, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// parseConvertArgs lê as opções de "convert"; sem --format o formato vem da extensão de --out
+func parseConvertArgs(args []string, stderr io.Writer) (*convertOptions, error) {
+	opts := &convertOptions{}
+	var entradas arquivosFlag
+
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Var(&entradas, "in", "arquivo de BTC (XML, .zip ou .tar.gz); pode ser repetido")
+	fs.StringVar(&opts.saida, "out", "-", "arquivo de saída (- para a saída padrão)")
+	fs.StringVar(&opts.formato, "format", "", "csv, json ou xlsx")
+	fs.StringVar(&opts.layout, "layout", "", "layout das colunas do CSV (padrão: antt)")
+	fs.StringVar(&opts.degradado, "banco-indisponivel", degradadoFalhar, "falhar ou marcar quando o banco falha no enriquecimento")
+	opts.master.register(fs)
+
+	posicionais, err := parseInterspersed(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	opts.entradas = append(entradas, posicionais...)
+	if len(opts.entradas) == 0 {
+		return nil, fmt.Errorf("informe ao menos um arquivo com --in")
+	}
+
+	if opts.formato == "" {
+		opts.formato = strings.TrimPrefix(strings.ToLower(filepath.Ext(opts.saida)), ".")
+		if opts.formato != formatJSON && opts.formato != formatXLSX {
+			opts.formato = formatCSV
+		}
+	}
+	switch opts.formato {
+	case formatCSV, formatJSON, formatXLSX:
+	default:
+		return nil, fmt.Errorf("formato não suportado: %s (use csv, json ou xlsx)", opts.formato)
+	}
+	if opts.degradado != degradadoFalhar && opts.degradado != degradadoMarcar {
+		return nil, fmt.Errorf("--banco-indisponivel deve ser %s ou %s", degradadoFalhar, degradadoMarcar)
+	}
+	return opts, nil
+}
+
+// parseValidateArgs lê as opções de "validate"
+func parseValidateArgs(args []string, stderr io.Writer) (*validateOptions, error) {
+	opts := &validateOptions{}
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts.master.register(fs)
+
+	posicionais, err := parseInterspersed(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(posicionais) == 0 {
+		return nil, fmt.Errorf("informe ao menos um arquivo de BTC")
+	}
+	opts.entradas = posicionais
+	return opts, nil
+}
+
+// readBatchFiles lê os arquivos de entrada, expandindo .zip e .tar.gz como no upload
+func readBatchFiles(paths []string) ([]BatchFile, error) {
+	var files []BatchFile
+	for _, path := range paths {
+		conteudo, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		expandidos, err := expandUpload(filepath.Base(path), conteudo)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		files = append(files, expandidos...)
+	}
+	return files, nil
+}
+
+// runConvertCommand executa "convert": processa os arquivos e grava a saída no formato escolhido
+func runConvertCommand(args []string) error {
+	opts, err := parseConvertArgs(args, os.Stderr)
+	if err != nil {
+		return err
+	}
+	shutdown, err := startCommand(opts.master)
+	if err != nil {
+		return err
+	}
+	defer shutdown(context.Background())
+	return convertFiles(context.Background(), opts, os.Stdout, os.Stderr)
+}
+
+// runValidateCommand executa "validate": imprime o relatório e devolve errLoteInvalido se houver problemas
+func runValidateCommand(args []string) error {
+	opts, err := parseValidateArgs(args, os.Stderr)
+	if err != nil {
+		return err
+	}
+	shutdown, err := startCommand(opts.master)
+	if err != nil {
+		return err
+	}
+	defer shutdown(context.Background())
+	return validateFiles(context.Background(), opts, os.Stdout)
+}
+
+// startCommand prepara convert e validate: dados mestres dos arquivos ou conexão com o banco
+func startCommand(master masterDataFlags) (func(context.Context) error, error) {
+	data, err := master.load()
+	if err != nil {
+		return nil, err
+	}
+	shutdown, err := startRuntime(!master.local())
+	if err != nil {
+		return nil, err
+	}
+	localData = data
+	if !master.local() {
+		// A conversão não altera o esquema; sem conexão o lote falha ou é marcado (--banco-indisponivel)
+		cfg.MigrateOnStart = false
+		configureCaches(cfg.Cache)
+		dbBreaker = newCircuitBreaker(cfg.DB)
+		ctx, cancel := context.WithTimeout(context.Background(), migrateConnectWait)
+		defer cancel()
+		dbConn.Get(ctx)
+	}
+	return shutdown, nil
+}
+
+// convertFiles processa o lote e escreve a saída em opts.saida (ou em stdout com "-")
+func convertFiles(ctx context.Context, opts *convertOptions, stdout, stderr io.Writer) (err error) {
+	layout, err := findLayout(opts.layout)
+	if err != nil {
+		return err
+	}
+	files, err := readBatchFiles(opts.entradas)
+	if err != nil {
+		return err
+	}
+
+	result, err := ProcessBatchContext(ctx, files, "", BatchOptions{Layout: layout, Degradado: opts.degradado})
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if opts.saida != "-" && opts.saida != "" {
+		f, err := os.Create(opts.saida)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(opts.saida)
+			}
+		}()
+		w = f
+	}
+
+	if err := writeBatchOutput(ctx, w, opts.formato, layout, result); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stderr, "%d viagens de %d arquivo(s) (dados mestres: %s)\n", result.Linhas, len(result.Arquivos), masterDataSource())
+	if result.Incompleto {
+		fmt.Fprintf(stderr, "atenção: %d operações sem enriquecimento por falha do banco (coluna status_enriquecimento)\n", result.OperacoesIncompletas)
+	}
+	return nil
+}
+
+// writeBatchOutput escreve as linhas do lote em CSV (no layout), JSON ou XLSX; saída
+// incompleta ganha a coluna status_enriquecimento
+func writeBatchOutput(ctx context.Context, w io.Writer, formato string, layout *ExportLayout, result *BatchResult) error {
+	if result.Incompleto {
+		layout = withStatusColumn(layout)
+	}
+
+	_, fase := startPhase(ctx, faseEscrita, attribute.String("btc.formato", formato), attribute.String("btc.layout", layout.Nome))
+	var err error
+	switch formato {
+	case formatJSON:
+		err = writeJSON(w, mustColumns(layout), result, result.Rows)
+	case formatXLSX:
+		err = writeXLSX(w, mustColumns(layout), result.Rows)
+	default:
+		err = writeLayout(w, layout, result.Rows)
+	}
+	fase.End(err, attribute.Int("btc.linhas", len(result.Rows)))
+	if err == nil {
+		progressFrom(ctx).escritas(len(result.Rows))
+	}
+	return err
+}
+
+// validateFiles confere o lote e imprime o relatório em JSON
+func validateFiles(ctx context.Context, opts *validateOptions, stdout io.Writer) error {
+	files, err := readBatchFiles(opts.entradas)
+	if err != nil {
+		return err
+	}
+	report, err := ValidateBatchContext(ctx, files)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if !report.Valido {
+		return errLoteInvalido
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dadosLocaisDeTeste instala motoristas e linhas locais com o motorista 951716 e a linha 1001
+func dadosLocaisDeTeste(t *testing.T) {
+	t.Helper()
+	semBanco(t)
+	comDadosLocais(t, &localMasterData{
+		cpfs: map[string]string{"951716": "12345678901"},
+		linhas: map[string]*ParametroViagem{"1001": {
+			CodLinha: 1001, Local1: "Brasília", Local2: "Goiânia", Linha: "BSB-GYN", CodANTT: "12-3456-00",
+			Lat1: "-15.79", Long1: "-47.88", Lat2: "-16.68", Long2: "-49.25",
+		}},
+	})
+}
+
+// TestParseConvertArgs testa as opções de convert, o formato pela extensão e os arquivos depois das opções
+func TestParseConvertArgs(t *testing.T) {
+	opts, err := parseConvertArgs([]string{"--in", "a.xml", "b.zip", "--out", "viagens.XLSX", "--linhas", "l.csv"}, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.xml", "b.zip"}, opts.entradas)
+	assert.Equal(t, formatXLSX, opts.formato)
+	assert.Equal(t, degradadoFalhar, opts.degradado)
+	assert.Equal(t, "l.csv", opts.master.linhas)
+
+	opts, err = parseConvertArgs([]string{"--in", "a.xml", "--out", "viagens.txt"}, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, formatCSV, opts.formato, "Extensão desconhecida gera CSV")
+
+	opts, err = parseConvertArgs([]string{"--in", "a.xml", "--format", "json"}, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, "-", opts.saida)
+	assert.Equal(t, formatJSON, opts.formato)
+
+	_, err = parseConvertArgs([]string{"--out", "x.csv"}, io.Discard)
+	assert.ErrorContains(t, err, "informe ao menos um arquivo")
+	_, err = parseConvertArgs([]string{"--in", "a.xml", "--format", "pdf"}, io.Discard)
+	assert.ErrorContains(t, err, "formato não suportado: pdf")
+	_, err = parseConvertArgs([]string{"--in", "a.xml", "--banco-indisponivel", "ignorar"}, io.Discard)
+	assert.ErrorContains(t, err, "--banco-indisponivel deve ser")
+}
+
+// TestMasterDataFlags_Load testa que motoristas e linhas locais são exigidos juntos
+func TestMasterDataFlags_Load(t *testing.T) {
+	data, err := masterDataFlags{}.load()
+	require.NoError(t, err)
+	assert.Nil(t, data, "Sem arquivos tudo vem do banco")
+
+	_, err = masterDataFlags{linhas: "linhas.csv"}.load()
+	assert.ErrorContains(t, err, "devem ser informados juntos")
+
+	veiculos := escreveArquivo(t, "veiculos.json", `[{"veiculo": "2001", "placa": "ABC-1D23"}]`)
+	data, err = masterDataFlags{veiculos: veiculos}.load()
+	require.NoError(t, err)
+	assert.Nil(t, data.cpfs)
+	assert.Len(t, data.placas, 1)
+}
+
+// TestConvertFiles testa a conversão com dados locais para arquivo CSV e para JSON na saída padrão
+func TestConvertFiles(t *testing.T) {
+	dadosLocaisDeTeste(t)
+	entrada := escreveArquivo(t, "btc.xml", btcXML("1", "951716",
+		operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"),
+		operacaoXML("1002", "1001", "200", "2024-01-15 10:00:00", "2024-01-15 11:00:00")))
+
+	saida := filepath.Join(t.TempDir(), "viagens.csv")
+	var stderr bytes.Buffer
+	err := convertFiles(context.Background(), &convertOptions{entradas: []string{entrada}, saida: saida, formato: formatCSV}, io.Discard, &stderr)
+	require.NoError(t, err)
+
+	rows := readCSV(t, saida)
+	require.Len(t, rows, 3)
+	assert.Equal(t, "CPF_RODOVIARIO", rows[0][len(rows[0])-1])
+	assert.Equal(t, "12345678901", rows[1][len(rows[1])-1])
+	assert.Contains(t, stderr.String(), "2 viagens de 1 arquivo(s) (dados mestres: arquivos)")
+
+	var stdout bytes.Buffer
+	err = convertFiles(context.Background(), &convertOptions{entradas: []string{entrada}, saida: "-", formato: formatJSON}, &stdout, io.Discard)
+	require.NoError(t, err)
+	var resposta struct {
+		Viagens []map[string]interface{} `json:"viagens"`
+	}
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &resposta))
+	assert.Len(t, resposta.Viagens, 2)
+}
+
+// TestConvertFiles_EntradaInvalida testa que uma falha não deixa arquivo de saída parcial
+func TestConvertFiles_EntradaInvalida(t *testing.T) {
+	dadosLocaisDeTeste(t)
+	entrada := escreveArquivo(t, "btc.xml", "<btcs><btc>")
+	saida := filepath.Join(t.TempDir(), "viagens.csv")
+
+	err := convertFiles(context.Background(), &convertOptions{entradas: []string{entrada}, saida: saida, formato: formatCSV}, io.Discard, io.Discard)
+	require.Error(t, err)
+	_, statErr := os.Stat(saida)
+	assert.True(t, os.IsNotExist(statErr))
+
+	err = convertFiles(context.Background(), &convertOptions{entradas: []string{"inexistente.xml"}, saida: saida, formato: formatCSV}, io.Discard, io.Discard)
+	assert.Error(t, err)
+}
+
+// TestValidateFiles testa o relatório em JSON e o erro de lote inválido com dados locais
+func TestValidateFiles(t *testing.T) {
+	dadosLocaisDeTeste(t)
+	valido := escreveArquivo(t, "valido.xml", btcXML("1", "951716",
+		operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00")))
+	semCPF := escreveArquivo(t, "sem_cpf.xml", btcXML("2", "777",
+		operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00")))
+
+	var stdout bytes.Buffer
+	require.NoError(t, validateFiles(context.Background(), &validateOptions{entradas: []string{valido}}, &stdout))
+	var report ValidationReport
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &report))
+	assert.True(t, report.Valido)
+	assert.Equal(t, "arquivos", report.FonteDados)
+
+	stdout.Reset()
+	err := validateFiles(context.Background(), &validateOptions{entradas: []string{semCPF}}, &stdout)
+	assert.ErrorIs(t, err, errLoteInvalido)
+	assert.Contains(t, stdout.String(), `"valor": "777"`)
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration é um time.Duration lido como texto ("5m", "1h30m") nos arquivos de configuração
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d *Duration) set(v string) error {
+	return d.UnmarshalText([]byte(v))
+}
+
+// ByteSize é um tamanho em bytes lido como texto ("512KB", "64MB", "1GB"); os múltiplos são de 1024
+type ByteSize int64
+
+const (
+	kilobyte ByteSize = 1 << (10 * (iota + 1))
+	megabyte
+	gigabyte
+)
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	v := strings.ToUpper(strings.TrimSpace(string(text)))
+	mult := ByteSize(1)
+	for _, s := range []struct {
+		sufixo string
+		mult   ByteSize
+	}{{"GB", gigabyte}, {"MB", megabyte}, {"KB", kilobyte}, {"B", 1}} {
+		if strings.HasSuffix(v, s.sufixo) {
+			v, mult = strings.TrimSpace(strings.TrimSuffix(v, s.sufixo)), s.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("tamanho inválido: %q", text)
+	}
+	*b = ByteSize(n) * mult
+	return nil
+}
+
+func (b *ByteSize) set(v string) error {
+	return b.UnmarshalText([]byte(v))
+}
+
+// String formata o tamanho na maior unidade exata, ex.: "64MB"
+func (b ByteSize) String() string {
+	switch {
+	case b >= gigabyte && b%gigabyte == 0:
+		return fmt.Sprintf("%dGB", b/gigabyte)
+	case b >= megabyte && b%megabyte == 0:
+		return fmt.Sprintf("%dMB", b/megabyte)
+	case b >= kilobyte && b%kilobyte == 0:
+		return fmt.Sprintf("%dKB", b/kilobyte)
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
+
+// DBConfig configura o pool de conexões com o PostgreSQL
+type DBConfig struct {
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	// BreakerThreshold falhas seguidas abrem o circuito; as consultas voltam a ser tentadas após BreakerCooldown
+	BreakerThreshold int      `yaml:"breaker_threshold" toml:"breaker_threshold"`
+	BreakerCooldown  Duration `yaml:"breaker_cooldown" toml:"breaker_cooldown"`
+	// ConnectWait é quanto uma requisição espera a conexão enquanto ela ainda está sendo estabelecida
+	ConnectWait Duration `yaml:"connect_wait" toml:"connect_wait"`
+	// ConnectTimeout limita cada tentativa de conexão; um host que não responde não segura o backoff
+	ConnectTimeout Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+	// RetryMin e RetryMax limitam o intervalo (exponencial) entre as tentativas de conexão
+	RetryMin Duration `yaml:"retry_min" toml:"retry_min"`
+	RetryMax Duration `yaml:"retry_max" toml:"retry_max"`
+	// StatementTimeout limita cada consulta; a requisição cancelada pelo cliente também interrompe a consulta
+	StatementTimeout Duration `yaml:"statement_timeout" toml:"statement_timeout"`
+}
+
+// VelocidadeConfig são os limites usados no cálculo da velocidade média (km/h)
+type VelocidadeConfig struct {
+	// Padrao é usada quando a velocidade calculada está fora da faixa ou não pode ser calculada
+	Padrao float64 `yaml:"padrao" toml:"padrao"`
+	Minima float64 `yaml:"minima" toml:"minima"`
+	Maxima float64 `yaml:"maxima" toml:"maxima"`
+}
+
+// CacheConfig configura os caches de CPF e de parâmetros de linha
+type CacheConfig struct {
+	TTL Duration `yaml:"ttl" toml:"ttl"`
+	// NegativeTTL é a validade de "não encontrado", menor para que cadastros novos apareçam logo
+	NegativeTTL Duration `yaml:"negative_ttl" toml:"negative_ttl"`
+	MaxEntries  int      `yaml:"max_entries" toml:"max_entries"`
+	// Notify escuta NOTIFY btc_cache para invalidar entradas alteradas em pessoa e parametro_viagem
+	Notify bool `yaml:"notify" toml:"notify"`
+}
+
+// AuthConfig configura a autenticação da API e as rotas /admin
+type AuthConfig struct {
+	// Disabled desliga a autenticação (apenas desenvolvimento local)
+	Disabled     bool `yaml:"disabled" toml:"disabled"`
+	AdminEnabled bool `yaml:"admin_enabled" toml:"admin_enabled"`
+	// AdminToken é a credencial inicial de administrador; AdminUnmaskToken também pode ver CPFs
+	AdminToken       string `yaml:"admin_token" toml:"admin_token"`
+	AdminUnmaskToken string `yaml:"admin_unmask_token" toml:"admin_unmask_token"`
+	JWTSecret        string `yaml:"jwt_secret" toml:"jwt_secret"`
+}
+
+// LogConfig configura os logs estruturados
+type LogConfig struct {
+	// Level é debug, info, warn ou error
+	Level string `yaml:"level" toml:"level"`
+	// Format é json (padrão, para agregadores) ou text (leitura local)
+	Format string `yaml:"format" toml:"format"`
+}
+
+// TracingConfig configura a exportação de spans OpenTelemetry
+type TracingConfig struct {
+	// Exporter é none (padrão), otlp ou stdout (execução local)
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint é a URL do coletor OTLP/HTTP; vazio usa OTEL_EXPORTER_OTLP_ENDPOINT ou localhost:4318
+	Endpoint    string `yaml:"endpoint" toml:"endpoint"`
+	ServiceName string `yaml:"service_name" toml:"service_name"`
+	// SampleRatio é a fração dos traces iniciados aqui que é registrada; traces recebidos seguem a decisão de origem
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// JobsConfig configura a fila de processamento (POST /jobs) e os workers que a executam
+type JobsConfig struct {
+	// Concurrency é o número de jobs executados em paralelo pelo subcomando "worker"
+	Concurrency int `yaml:"concurrency" toml:"concurrency"`
+	// EmbeddedWorkers executa jobs também dentro do "serve" (0: apenas em processos "worker")
+	EmbeddedWorkers int `yaml:"embedded_workers" toml:"embedded_workers"`
+	// PollInterval é a espera entre as buscas por jobs quando a fila está vazia
+	PollInterval Duration `yaml:"poll_interval" toml:"poll_interval"`
+	// Lease é a reserva de um job em execução, renovada pelo worker; vencida, outro worker o retoma
+	Lease Duration `yaml:"lease" toml:"lease"`
+	// MaxAttempts limita as execuções de um job com falhas temporárias (banco indisponível)
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts"`
+	// RetryMin e RetryMax limitam o intervalo (exponencial) antes de uma nova tentativa
+	RetryMin Duration `yaml:"retry_min" toml:"retry_min"`
+	RetryMax Duration `yaml:"retry_max" toml:"retry_max"`
+	// Retention é por quanto tempo jobs terminados (e seus resultados) ficam disponíveis
+	Retention Duration `yaml:"retention" toml:"retention"`
+}
+
+// WebhooksConfig configura as notificações de jobs terminados (POST /webhooks e ?webhook= em POST /jobs)
+type WebhooksConfig struct {
+	// PublicURL é o endereço externo da API, usado no link de download das notificações (vazio: caminho relativo)
+	PublicURL string `yaml:"public_url" toml:"public_url"`
+	// Timeout limita cada entrega; sem resposta 2xx nesse prazo a entrega é repetida
+	Timeout Duration `yaml:"timeout" toml:"timeout"`
+	// MaxAttempts limita as tentativas de cada entrega
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts"`
+	// RetryMin e RetryMax limitam o intervalo (exponencial) entre as tentativas
+	RetryMin Duration `yaml:"retry_min" toml:"retry_min"`
+	RetryMax Duration `yaml:"retry_max" toml:"retry_max"`
+	// AllowPrivate aceita destinos em loopback, redes privadas e link-local (ex.: ERP na rede interna).
+	// Desligado, qualquer credencial de upload poderia usar os webhooks para alcançar serviços internos
+	AllowPrivate bool `yaml:"allow_private" toml:"allow_private"`
+}
+
+// UploadConfig limita o tamanho e a estrutura dos lotes recebidos (/upload, /validate e /jobs)
+type UploadConfig struct {
+	// MaxBytes limita o corpo da requisição; acima dele a resposta é 413
+	MaxBytes ByteSize `yaml:"max_bytes" toml:"max_bytes"`
+	// MultipartMemory é quanto do formulário fica em memória; o restante vai para arquivos temporários
+	MultipartMemory ByteSize `yaml:"multipart_memory" toml:"multipart_memory"`
+	// MaxExpandedBytes limita o total descompactado de cada .zip ou .tar.gz (bombas de descompressão)
+	MaxExpandedBytes ByteSize `yaml:"max_expanded_bytes" toml:"max_expanded_bytes"`
+	// MaxFiles limita os arquivos XML do lote, já descompactados
+	MaxFiles int `yaml:"max_files" toml:"max_files"`
+	// MaxDepth limita o aninhamento de elementos de cada XML
+	MaxDepth int `yaml:"max_depth" toml:"max_depth"`
+	// MaxOperacoesPorBtc e MaxOperacoes limitam as operações de um btc e do lote inteiro
+	MaxOperacoesPorBtc int `yaml:"max_operacoes_por_btc" toml:"max_operacoes_por_btc"`
+	MaxOperacoes       int `yaml:"max_operacoes" toml:"max_operacoes"`
+}
+
+// Config é a configuração da aplicação: valores padrão, sobrepostos pelo arquivo
+// de CONFIG_FILE (YAML ou TOML) e depois pelas variáveis de ambiente
+type Config struct {
+	Port             string           `yaml:"port" toml:"port"`
+	DatabaseURL      string           `yaml:"database_url" toml:"database_url"`
+	CORSOrigins      []string         `yaml:"cors_origins" toml:"cors_origins"`
+	DB               DBConfig         `yaml:"db" toml:"db"`
+	Velocidade       VelocidadeConfig `yaml:"velocidade" toml:"velocidade"`
+	ExportLayoutsDir string           `yaml:"export_layouts_dir" toml:"export_layouts_dir"`
+	// DuplicatePolicy define o que fazer com conteúdo já processado: "reject" ou "warn"
+	DuplicatePolicy string     `yaml:"duplicate_policy" toml:"duplicate_policy"`
+	Auth            AuthConfig `yaml:"auth" toml:"auth"`
+	// MigrateOnStart aplica as migrações pendentes ao conectar (desligue para usar apenas "migrate up")
+	MigrateOnStart bool           `yaml:"migrate_on_start" toml:"migrate_on_start"`
+	Cache          CacheConfig    `yaml:"cache" toml:"cache"`
+	Log            LogConfig      `yaml:"log" toml:"log"`
+	Tracing        TracingConfig  `yaml:"tracing" toml:"tracing"`
+	Jobs           JobsConfig     `yaml:"jobs" toml:"jobs"`
+	Webhooks       WebhooksConfig `yaml:"webhooks" toml:"webhooks"`
+	Upload         UploadConfig   `yaml:"upload" toml:"upload"`
+}
+
+// cfg é a configuração em uso; main a substitui pelo resultado de loadConfig
+var cfg = defaultConfig()
+
+// secretsDir é o diretório de segredos montado pelo Docker/Kubernetes
+var secretsDir = "/run/secrets"
+
+// defaultConfig devolve os valores usados quando nada é configurado
+func defaultConfig() *Config {
+	return &Config{
+		Port:        "3333",
+		CORSOrigins: []string{"https://dadosdedemanda.vercel.app", "http://localhost:3000"},
+		DB: DBConfig{
+			MaxOpenConns:     25,
+			MaxIdleConns:     5,
+			ConnMaxLifetime:  Duration{5 * time.Minute},
+			BreakerThreshold: 5,
+			BreakerCooldown:  Duration{30 * time.Second},
+			ConnectWait:      Duration{2 * time.Second},
+			ConnectTimeout:   Duration{10 * time.Second},
+			RetryMin:         Duration{time.Second},
+			RetryMax:         Duration{time.Minute},
+			StatementTimeout: Duration{5 * time.Second},
+		},
+		Velocidade: VelocidadeConfig{
+			Padrao: 45,
+			Minima: 15, // abaixo disso o tempo inclui pausas
+			Maxima: 70, // limite legal para ônibus
+		},
+		ExportLayoutsDir: "layouts",
+		DuplicatePolicy:  "reject",
+		Auth:             AuthConfig{AdminEnabled: true},
+		MigrateOnStart:   true,
+		Cache: CacheConfig{
+			TTL:         Duration{time.Hour},
+			NegativeTTL: Duration{5 * time.Minute},
+			MaxEntries:  50000,
+			Notify:      true,
+		},
+		Log:     LogConfig{Level: "info", Format: "json"},
+		Tracing: TracingConfig{Exporter: tracingNone, ServiceName: "btc-api", SampleRatio: 1},
+		Jobs: JobsConfig{
+			Concurrency:  2,
+			PollInterval: Duration{time.Second},
+			Lease:        Duration{2 * time.Minute},
+			MaxAttempts:  5,
+			RetryMin:     Duration{10 * time.Second},
+			RetryMax:     Duration{10 * time.Minute},
+			Retention:    Duration{7 * 24 * time.Hour},
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:     Duration{10 * time.Second},
+			MaxAttempts: 8,
+			RetryMin:    Duration{30 * time.Second},
+			RetryMax:    Duration{time.Hour},
+		},
+		Upload: UploadConfig{
+			MaxBytes:           64 * megabyte,
+			MultipartMemory:    8 * megabyte,
+			MaxExpandedBytes:   256 * megabyte,
+			MaxFiles:           1000,
+			MaxDepth:           32,
+			MaxOperacoesPorBtc: 5000,
+			MaxOperacoes:       200000,
+		},
+	}
+}
+
+// loadConfig monta a configuração a partir dos padrões, do arquivo CONFIG_FILE e do ambiente
+func loadConfig() (*Config, error) {
+	return loadConfigFor(true)
+}
+
+// loadConfigFor é loadConfig para subcomandos que podem dispensar o banco
+// (conversão com dados mestres em arquivos locais): sem exigeBanco, DATABASE_URL é opcional
+func loadConfigFor(exigeBanco bool) (*Config, error) {
+	c := defaultConfig()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, fmt.Errorf("erro ao ler %s: %w", path, err)
+		}
+	}
+
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateFor(exigeBanco); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile sobrepõe a configuração com um arquivo .yaml, .yml ou .toml
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, c)
+	case ".toml":
+		return toml.Unmarshal(data, c)
+	}
+	return fmt.Errorf("formato não suportado (use .yaml, .yml ou .toml)")
+}
+
+// loadEnv sobrepõe a configuração com as variáveis de ambiente definidas
+func (c *Config) loadEnv() error {
+	setString := func(target *string, names ...string) {
+		for _, name := range names {
+			if v := os.Getenv(name); v != "" {
+				*target = v
+				return
+			}
+		}
+	}
+	setString(&c.Port, "PORT")
+	setString(&c.ExportLayoutsDir, "EXPORT_LAYOUTS_DIR")
+	setString(&c.DuplicatePolicy, "DUPLICATE_POLICY")
+	setString(&c.Log.Level, "LOG_LEVEL")
+	setString(&c.Log.Format, "LOG_FORMAT")
+	// Nomes padrão do OpenTelemetry; o endpoint OTLP é lido pelo próprio exportador
+	setString(&c.Tracing.Exporter, "OTEL_TRACES_EXPORTER")
+	setString(&c.Tracing.ServiceName, "OTEL_SERVICE_NAME")
+	setString(&c.Webhooks.PublicURL, "WEBHOOKS_PUBLIC_URL")
+
+	if v := os.Getenv("CORS_ORIGINS"); v != "" {
+		c.CORSOrigins = nil
+		for _, origin := range strings.Split(v, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.CORSOrigins = append(c.CORSOrigins, origin)
+			}
+		}
+	}
+
+	var errs []string
+	parse := func(name string, set func(string) error) {
+		if v := os.Getenv(name); v != "" {
+			if err := set(v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s=%q inválido", name, v))
+			}
+		}
+	}
+	parseInt := func(target *int) func(string) error {
+		return func(v string) (err error) { *target, err = strconv.Atoi(v); return }
+	}
+	parseFloat := func(target *float64) func(string) error {
+		return func(v string) (err error) { *target, err = strconv.ParseFloat(v, 64); return }
+	}
+	parseBool := func(target *bool) func(string) error {
+		return func(v string) (err error) { *target, err = strconv.ParseBool(v); return }
+	}
+
+	parse("DB_MAX_OPEN_CONNS", parseInt(&c.DB.MaxOpenConns))
+	parse("DB_MAX_IDLE_CONNS", parseInt(&c.DB.MaxIdleConns))
+	parse("DB_CONN_MAX_LIFETIME", c.DB.ConnMaxLifetime.set)
+	parse("DB_BREAKER_THRESHOLD", parseInt(&c.DB.BreakerThreshold))
+	parse("DB_BREAKER_COOLDOWN", c.DB.BreakerCooldown.set)
+	parse("DB_CONNECT_WAIT", c.DB.ConnectWait.set)
+	parse("DB_CONNECT_TIMEOUT", c.DB.ConnectTimeout.set)
+	parse("DB_RETRY_MIN", c.DB.RetryMin.set)
+	parse("DB_RETRY_MAX", c.DB.RetryMax.set)
+	parse("DB_STATEMENT_TIMEOUT", c.DB.StatementTimeout.set)
+	parse("VELOCIDADE_PADRAO", parseFloat(&c.Velocidade.Padrao))
+	parse("VELOCIDADE_MINIMA", parseFloat(&c.Velocidade.Minima))
+	parse("VELOCIDADE_MAXIMA", parseFloat(&c.Velocidade.Maxima))
+	parse("AUTH_DISABLED", parseBool(&c.Auth.Disabled))
+	parse("ADMIN_ENABLED", parseBool(&c.Auth.AdminEnabled))
+	parse("MIGRATE_ON_START", parseBool(&c.MigrateOnStart))
+	parse("CACHE_TTL", c.Cache.TTL.set)
+	parse("CACHE_NEGATIVE_TTL", c.Cache.NegativeTTL.set)
+	parse("CACHE_MAX_ENTRIES", parseInt(&c.Cache.MaxEntries))
+	parse("CACHE_NOTIFY", parseBool(&c.Cache.Notify))
+	parse("OTEL_TRACES_SAMPLER_ARG", parseFloat(&c.Tracing.SampleRatio))
+	parse("JOBS_CONCURRENCY", parseInt(&c.Jobs.Concurrency))
+	parse("JOBS_EMBEDDED_WORKERS", parseInt(&c.Jobs.EmbeddedWorkers))
+	parse("JOBS_POLL_INTERVAL", c.Jobs.PollInterval.set)
+	parse("JOBS_LEASE", c.Jobs.Lease.set)
+	parse("JOBS_MAX_ATTEMPTS", parseInt(&c.Jobs.MaxAttempts))
+	parse("JOBS_RETRY_MIN", c.Jobs.RetryMin.set)
+	parse("JOBS_RETRY_MAX", c.Jobs.RetryMax.set)
+	parse("JOBS_RETENTION", c.Jobs.Retention.set)
+	parse("WEBHOOKS_TIMEOUT", c.Webhooks.Timeout.set)
+	parse("WEBHOOKS_MAX_ATTEMPTS", parseInt(&c.Webhooks.MaxAttempts))
+	parse("WEBHOOKS_RETRY_MIN", c.Webhooks.RetryMin.set)
+	parse("WEBHOOKS_RETRY_MAX", c.Webhooks.RetryMax.set)
+	parse("WEBHOOKS_ALLOW_PRIVATE", parseBool(&c.Webhooks.AllowPrivate))
+	parse("UPLOAD_MAX_BYTES", c.Upload.MaxBytes.set)
+	parse("UPLOAD_MULTIPART_MEMORY", c.Upload.MultipartMemory.set)
+	parse("UPLOAD_MAX_EXPANDED_BYTES", c.Upload.MaxExpandedBytes.set)
+	parse("UPLOAD_MAX_FILES", parseInt(&c.Upload.MaxFiles))
+	parse("UPLOAD_MAX_DEPTH", parseInt(&c.Upload.MaxDepth))
+	parse("UPLOAD_MAX_OPERACOES_POR_BTC", parseInt(&c.Upload.MaxOperacoesPorBtc))
+	parse("UPLOAD_MAX_OPERACOES", parseInt(&c.Upload.MaxOperacoes))
+
+	// Segredos: variável de ambiente, arquivo indicado em <NOME>_FILE ou /run/secrets/<nome>
+	secrets := []struct {
+		target *string
+		names  []string
+	}{
+		// DATABASE_URL: produção no Railway (rede privada); DATABASE_PUBLIC_URL: acesso externo
+		{&c.DatabaseURL, []string{"DATABASE_URL", "DATABASE_PUBLIC_URL", "POSTGRES_URL"}},
+		{&c.Auth.AdminToken, []string{"ADMIN_TOKEN"}},
+		{&c.Auth.AdminUnmaskToken, []string{"ADMIN_UNMASK_TOKEN"}},
+		{&c.Auth.JWTSecret, []string{"JWT_SECRET"}},
+	}
+	for _, s := range secrets {
+		for _, name := range s.names {
+			v, err := readSecret(name)
+			if err != nil {
+				errs = append(errs, err.Error())
+				break
+			}
+			if v != "" {
+				*s.target = v
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("configuração inválida: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// readSecret lê um segredo da variável name, do arquivo em name_FILE ou de /run/secrets/<name em minúsculas>
+func readSecret(name string) (string, error) {
+	if v := os.Getenv(name); v != "" {
+		return v, nil
+	}
+
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("%s_FILE: %v", name, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(secretsDir, strings.ToLower(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("segredo %s: %v", name, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// validate confere a configuração antes de iniciar o servidor
+func (c *Config) validate() error {
+	return c.validateFor(true)
+}
+
+// validateFor confere a configuração; sem exigeBanco, DATABASE_URL só é validada quando definida
+func (c *Config) validateFor(exigeBanco bool) error {
+	var errs []string
+
+	if c.DatabaseURL == "" {
+		if exigeBanco {
+			errs = append(errs, "DATABASE_URL não configurada (variável, DATABASE_URL_FILE ou /run/secrets/database_url)")
+		}
+	} else if u, err := url.Parse(c.DatabaseURL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		errs = append(errs, "DATABASE_URL deve ser uma URL postgres:// ou postgresql://")
+	}
+
+	if p, err := strconv.Atoi(c.Port); err != nil || p <= 0 || p > 65535 {
+		errs = append(errs, fmt.Sprintf("porta inválida: %q", c.Port))
+	}
+
+	if len(c.CORSOrigins) == 0 {
+		errs = append(errs, "cors_origins vazio")
+	}
+	for _, origin := range c.CORSOrigins {
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("origem CORS inválida: %q", origin))
+		}
+	}
+
+	if c.DB.MaxOpenConns <= 0 || c.DB.MaxIdleConns < 0 || c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		errs = append(errs, "pool inválido: max_idle_conns deve estar entre 0 e max_open_conns (> 0)")
+	}
+	if c.DB.ConnMaxLifetime.Duration <= 0 {
+		errs = append(errs, "conn_max_lifetime deve ser positivo")
+	}
+	if c.DB.BreakerThreshold <= 0 || c.DB.BreakerCooldown.Duration <= 0 {
+		errs = append(errs, "breaker_threshold e breaker_cooldown devem ser positivos")
+	}
+	if c.DB.ConnectWait.Duration < 0 || c.DB.RetryMin.Duration <= 0 || c.DB.RetryMax.Duration < c.DB.RetryMin.Duration {
+		errs = append(errs, "reconexão inválida: connect_wait >= 0 e 0 < retry_min <= retry_max")
+	}
+	if c.DB.ConnectTimeout.Duration <= 0 {
+		errs = append(errs, "connect_timeout deve ser positivo")
+	}
+	if c.DB.StatementTimeout.Duration <= 0 {
+		errs = append(errs, "statement_timeout deve ser positivo")
+	}
+
+	v := c.Velocidade
+	if v.Minima <= 0 || v.Minima > v.Padrao || v.Padrao > v.Maxima {
+		errs = append(errs, fmt.Sprintf("velocidades inválidas: deve valer 0 < mínima (%g) <= padrão (%g) <= máxima (%g)", v.Minima, v.Padrao, v.Maxima))
+	}
+
+	if c.Cache.TTL.Duration <= 0 || c.Cache.NegativeTTL.Duration <= 0 || c.Cache.MaxEntries <= 0 {
+		errs = append(errs, "cache inválido: ttl, negative_ttl e max_entries devem ser positivos")
+	}
+
+	if c.DuplicatePolicy != "reject" && c.DuplicatePolicy != "warn" {
+		errs = append(errs, fmt.Sprintf("duplicate_policy deve ser reject ou warn, não %q", c.DuplicatePolicy))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Sprintf("log.level deve ser debug, info, warn ou error, não %q", c.Log.Level))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Sprintf("log.format deve ser json ou text, não %q", c.Log.Format))
+	}
+
+	switch c.Tracing.Exporter {
+	case tracingNone, tracingOTLP, tracingStdout, tracingConsole:
+	default:
+		errs = append(errs, fmt.Sprintf("tracing.exporter deve ser none, otlp ou stdout, não %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("tracing.endpoint inválido: %q", c.Tracing.Endpoint))
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, "tracing.sample_ratio deve estar entre 0 e 1")
+	}
+
+	j := c.Jobs
+	if j.Concurrency <= 0 || j.EmbeddedWorkers < 0 || j.MaxAttempts <= 0 {
+		errs = append(errs, "jobs inválido: concurrency e max_attempts devem ser positivos e embedded_workers >= 0")
+	}
+	if j.PollInterval.Duration <= 0 || j.Lease.Duration <= 0 || j.Retention.Duration <= 0 {
+		errs = append(errs, "jobs inválido: poll_interval, lease e retention devem ser positivos")
+	}
+	if j.RetryMin.Duration <= 0 || j.RetryMax.Duration < j.RetryMin.Duration {
+		errs = append(errs, "jobs inválido: 0 < retry_min <= retry_max")
+	}
+
+	wh := c.Webhooks
+	if wh.PublicURL != "" {
+		if u, err := url.Parse(wh.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("webhooks.public_url inválido: %q", wh.PublicURL))
+		}
+	}
+	if wh.Timeout.Duration <= 0 || wh.MaxAttempts <= 0 {
+		errs = append(errs, "webhooks inválido: timeout e max_attempts devem ser positivos")
+	}
+	if wh.RetryMin.Duration <= 0 || wh.RetryMax.Duration < wh.RetryMin.Duration {
+		errs = append(errs, "webhooks inválido: 0 < retry_min <= retry_max")
+	}
+
+	up := c.Upload
+	if up.MaxBytes <= 0 || up.MultipartMemory <= 0 || up.MaxExpandedBytes <= 0 {
+		errs = append(errs, "upload inválido: max_bytes, multipart_memory e max_expanded_bytes devem ser positivos")
+	}
+	if up.MaxFiles <= 0 || up.MaxDepth <= 0 || up.MaxOperacoesPorBtc <= 0 || up.MaxOperacoes < up.MaxOperacoesPorBtc {
+		errs = append(errs, "upload inválido: max_files e max_depth devem ser positivos e 0 < max_operacoes_por_btc <= max_operacoes")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("configuração inválida: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// redactedDatabaseURL devolve a URL do banco sem a senha, para log
+func (c *Config) redactedDatabaseURL() string {
+	u, err := url.Parse(c.DatabaseURL)
+	if err != nil {
+		return "(inválida)"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// comConfig troca a configuração global durante o teste, partindo dos valores padrão
+func comConfig(t *testing.T, ajustar func(c *Config)) {
+	t.Helper()
+	original := cfg
+	c := defaultConfig()
+	ajustar(c)
+	cfg = c
+	t.Cleanup(func() { cfg = original })
+}
+
+// semAmbiente limpa as variáveis lidas por loadConfig e aponta /run/secrets para um diretório vazio
+func semAmbiente(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"CONFIG_FILE", "PORT", "EXPORT_LAYOUTS_DIR", "DUPLICATE_POLICY", "CORS_ORIGINS",
+		"DB_MAX_OPEN_CONNS", "DB_MAX_IDLE_CONNS", "DB_CONN_MAX_LIFETIME", "DB_BREAKER_THRESHOLD", "DB_BREAKER_COOLDOWN",
+		"DB_CONNECT_WAIT", "DB_CONNECT_TIMEOUT", "DB_RETRY_MIN", "DB_RETRY_MAX", "DB_STATEMENT_TIMEOUT",
+		"VELOCIDADE_PADRAO", "VELOCIDADE_MINIMA", "VELOCIDADE_MAXIMA", "AUTH_DISABLED", "ADMIN_ENABLED", "MIGRATE_ON_START",
+		"CACHE_TTL", "CACHE_NEGATIVE_TTL", "CACHE_MAX_ENTRIES", "CACHE_NOTIFY", "LOG_LEVEL", "LOG_FORMAT",
+		"OTEL_TRACES_EXPORTER", "OTEL_SERVICE_NAME", "OTEL_TRACES_SAMPLER_ARG",
+		"JOBS_CONCURRENCY", "JOBS_EMBEDDED_WORKERS", "JOBS_POLL_INTERVAL", "JOBS_LEASE", "JOBS_MAX_ATTEMPTS",
+		"JOBS_RETRY_MIN", "JOBS_RETRY_MAX", "JOBS_RETENTION",
+		"WEBHOOKS_PUBLIC_URL", "WEBHOOKS_TIMEOUT", "WEBHOOKS_MAX_ATTEMPTS", "WEBHOOKS_RETRY_MIN", "WEBHOOKS_RETRY_MAX",
+		"WEBHOOKS_ALLOW_PRIVATE",
+		"UPLOAD_MAX_BYTES", "UPLOAD_MULTIPART_MEMORY", "UPLOAD_MAX_EXPANDED_BYTES", "UPLOAD_MAX_FILES", "UPLOAD_MAX_DEPTH",
+		"UPLOAD_MAX_OPERACOES_POR_BTC", "UPLOAD_MAX_OPERACOES",
+		"DATABASE_URL", "DATABASE_PUBLIC_URL", "POSTGRES_URL", "ADMIN_TOKEN", "ADMIN_UNMASK_TOKEN", "JWT_SECRET",
+		"DATABASE_URL_FILE", "DATABASE_PUBLIC_URL_FILE", "POSTGRES_URL_FILE", "ADMIN_TOKEN_FILE",
+		"ADMIN_UNMASK_TOKEN_FILE", "JWT_SECRET_FILE",
+	} {
+		t.Setenv(name, "")
+	}
+
+	original := secretsDir
+	secretsDir = t.TempDir()
+	t.Cleanup(func() { secretsDir = original })
+}
+
+// TestLoadConfig_RequiresDatabaseURL testa que a aplicação não inicia sem URL do banco
+func TestLoadConfig_RequiresDatabaseURL(t *testing.T) {
+	semAmbiente(t)
+
+	_, err := loadConfig()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "DATABASE_URL não configurada")
+}
+
+// TestLoadConfigFor_SemBanco testa que a conversão com arquivos locais dispensa DATABASE_URL, mas não a valida menos
+func TestLoadConfigFor_SemBanco(t *testing.T) {
+	semAmbiente(t)
+
+	c, err := loadConfigFor(false)
+	require.NoError(t, err)
+	assert.Empty(t, c.DatabaseURL)
+
+	t.Setenv("DATABASE_URL", "mysql://db/btc")
+	_, err = loadConfigFor(false)
+	assert.ErrorContains(t, err, "DATABASE_URL deve ser uma URL postgres://")
+}
+
+// TestLoadConfig_FileAndEnv testa a precedência: padrões, arquivo e variáveis de ambiente
+func TestLoadConfig_FileAndEnv(t *testing.T) {
+	semAmbiente(t)
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`
+port: "8080"
+database_url: postgres://app:senha@db:5432/btc
+cors_origins: [https://painel.exemplo.com.br]
+db:
+  max_open_conns: 10
+  conn_max_lifetime: 90s
+velocidade:
+  padrao: 40
+auth:
+  admin_enabled: false
+upload:
+  max_bytes: 16MB
+  multipart_memory: 524288
+`), 0644))
+	t.Setenv("CONFIG_FILE", yamlPath)
+	t.Setenv("PORT", "9090")
+	t.Setenv("VELOCIDADE_MAXIMA", "80")
+
+	c, err := loadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "9090", c.Port, "Ambiente sobrepõe o arquivo")
+	assert.Equal(t, []string{"https://painel.exemplo.com.br"}, c.CORSOrigins)
+	assert.Equal(t, 10, c.DB.MaxOpenConns)
+	assert.Equal(t, 5, c.DB.MaxIdleConns, "Ausente no arquivo mantém o padrão")
+	assert.Equal(t, 90*time.Second, c.DB.ConnMaxLifetime.Duration)
+	assert.Equal(t, VelocidadeConfig{Padrao: 40, Minima: 15, Maxima: 80}, c.Velocidade)
+	assert.False(t, c.Auth.AdminEnabled)
+	assert.Equal(t, 16*megabyte, c.Upload.MaxBytes)
+	assert.Equal(t, 512*kilobyte, c.Upload.MultipartMemory, "Número sem unidade é lido em bytes")
+	assert.Equal(t, "postgres://app:xxxxx@db:5432/btc", c.redactedDatabaseURL())
+
+	tomlPath := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(tomlPath, []byte(`
+database_url = "postgres://app@db/btc"
+duplicate_policy = "warn"
+
+[db]
+conn_max_lifetime = "2m"
+`), 0644))
+	t.Setenv("CONFIG_FILE", tomlPath)
+	t.Setenv("PORT", "")
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0.25")
+	t.Setenv("JOBS_CONCURRENCY", "4")
+	t.Setenv("JOBS_LEASE", "5m")
+	t.Setenv("WEBHOOKS_PUBLIC_URL", "https://btc.example.com")
+	t.Setenv("UPLOAD_MAX_EXPANDED_BYTES", "1gb")
+
+	c, err = loadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "3333", c.Port)
+	assert.Equal(t, "warn", c.DuplicatePolicy)
+	assert.Equal(t, 2*time.Minute, c.DB.ConnMaxLifetime.Duration)
+	assert.Equal(t, LogConfig{Level: "debug", Format: "json"}, c.Log)
+	assert.Equal(t, TracingConfig{Exporter: "otlp", ServiceName: "btc-api", SampleRatio: 0.25}, c.Tracing)
+	assert.Equal(t, 4, c.Jobs.Concurrency)
+	assert.Equal(t, 5*time.Minute, c.Jobs.Lease.Duration)
+	assert.Equal(t, 5, c.Jobs.MaxAttempts)
+	assert.Equal(t, "https://btc.example.com", c.Webhooks.PublicURL)
+	assert.Equal(t, 8, c.Webhooks.MaxAttempts)
+	assert.Equal(t, "1GB", c.Upload.MaxExpandedBytes.String())
+	assert.Equal(t, 64*megabyte, c.Upload.MaxBytes)
+
+	t.Setenv("UPLOAD_MAX_BYTES", "64 megas")
+	_, err = loadConfig()
+	assert.ErrorContains(t, err, `UPLOAD_MAX_BYTES="64 megas" inválido`)
+}
+
+// TestLoadConfig_Secrets testa a leitura de segredos por variável, arquivo _FILE e /run/secrets
+func TestLoadConfig_Secrets(t *testing.T) {
+	semAmbiente(t)
+
+	dbFile := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, os.WriteFile(dbFile, []byte("postgres://app:arquivo@db/btc\n"), 0600))
+	t.Setenv("DATABASE_URL_FILE", dbFile)
+	require.NoError(t, os.WriteFile(filepath.Join(secretsDir, "jwt_secret"), []byte("do-docker\n"), 0600))
+	t.Setenv("ADMIN_TOKEN", "da-variavel")
+
+	c, err := loadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "postgres://app:arquivo@db/btc", c.DatabaseURL)
+	assert.Equal(t, "do-docker", c.Auth.JWTSecret)
+	assert.Equal(t, "da-variavel", c.Auth.AdminToken)
+
+	t.Setenv("DATABASE_URL_FILE", filepath.Join(t.TempDir(), "inexistente"))
+	_, err = loadConfig()
+	assert.ErrorContains(t, err, "DATABASE_URL_FILE")
+}
+
+// TestConfigValidate testa a rejeição de valores inválidos
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ajustar func(c *Config)
+		erro    string
+	}{
+		{"URL que não é postgres", func(c *Config) { c.DatabaseURL = "mysql://db" }, "postgres://"},
+		{"Porta inválida", func(c *Config) { c.Port = "http" }, "porta"},
+		{"Origem CORS sem esquema", func(c *Config) { c.CORSOrigins = []string{"painel.com"} }, "origem CORS"},
+		{"Pool ocioso maior que o total", func(c *Config) { c.DB.MaxIdleConns = 30 }, "pool"},
+		{"Velocidade padrão fora da faixa", func(c *Config) { c.Velocidade.Padrao = 90 }, "velocidades"},
+		{"Circuito sem limite", func(c *Config) { c.DB.BreakerThreshold = 0 }, "breaker_threshold"},
+		{"Consulta sem limite", func(c *Config) { c.DB.StatementTimeout = Duration{} }, "statement_timeout"},
+		{"Reconexão invertida", func(c *Config) { c.DB.RetryMax = Duration{time.Millisecond} }, "retry_min <= retry_max"},
+		{"Cache sem validade", func(c *Config) { c.Cache.NegativeTTL = Duration{} }, "cache"},
+		{"Política de duplicados desconhecida", func(c *Config) { c.DuplicatePolicy = "ignorar" }, "duplicate_policy"},
+		{"Nível de log desconhecido", func(c *Config) { c.Log.Level = "trace" }, "log.level"},
+		{"Formato de log desconhecido", func(c *Config) { c.Log.Format = "xml" }, "log.format"},
+		{"Exportador de spans desconhecido", func(c *Config) { c.Tracing.Exporter = "jaeger" }, "tracing.exporter"},
+		{"Coletor sem esquema", func(c *Config) { c.Tracing.Endpoint = "otel:4318" }, "tracing.endpoint"},
+		{"Amostragem acima de 1", func(c *Config) { c.Tracing.SampleRatio = 2 }, "sample_ratio"},
+		{"Workers sem concorrência", func(c *Config) { c.Jobs.Concurrency = 0 }, "jobs inválido"},
+		{"Jobs sem reserva", func(c *Config) { c.Jobs.Lease = Duration{} }, "lease"},
+		{"Novas tentativas invertidas", func(c *Config) { c.Jobs.RetryMax = Duration{time.Second} }, "jobs inválido: 0 < retry_min"},
+		{"Endereço público relativo", func(c *Config) { c.Webhooks.PublicURL = "/api" }, "webhooks.public_url inválido"},
+		{"Webhooks sem tentativas", func(c *Config) { c.Webhooks.MaxAttempts = 0 }, "webhooks inválido: timeout e max_attempts"},
+		{"Upload sem limite de tamanho", func(c *Config) { c.Upload.MaxBytes = 0 }, "upload inválido: max_bytes"},
+		{"Limite do btc acima do lote", func(c *Config) { c.Upload.MaxOperacoes = 10 }, "max_operacoes_por_btc <= max_operacoes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			c.DatabaseURL = "postgres://db/btc"
+			require.NoError(t, c.validate())
+
+			tt.ajustar(c)
+			err := c.validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.erro)
+		})
+	}
+}
+
+// TestGetDBConnection_NoURL testa que sem URL configurada não há conexão de fallback
+func TestGetDBConnection_NoURL(t *testing.T) {
+	semBanco(t)
+	comConfig(t, func(c *Config) {})
+	dbConn = newConnManager(cfg.DB, openDatabase)
+
+	_, err := getDBConnection(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "DATABASE_URL não configurada")
+}
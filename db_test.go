@@ -1,8 +1,8 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"sync"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -18,26 +18,13 @@ func TestGetCPFByCodIdentificador_Success(t *testing.T) {
 	}
 	defer db.Close()
 
-	// Substituir temporariamente o dbPool
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-
-	// Resetar variáveis globais completamente
-	dbPool = db
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
-	
-	// Executar o Once manualmente para marcar como executado
-	// Isso faz com que getDBConnection retorne dbPool diretamente sem tentar inicializar
-	dbPoolOnce.Do(func() {
-		// Não fazer nada, apenas marcar como executado
-	})
+	// Substituir temporariamente a conexão por uma já pronta com o mock,
+	// para que getDBConnection não tente conectar
+	originalDBConn := dbConn
+	dbConn = connManagerPronto(db)
 
 	// Limpar cache
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
+	cpfCache.Clear()
 
 	// Configurar expectativas do mock
 	codIdentificador := "951716"
@@ -49,7 +36,7 @@ func TestGetCPFByCodIdentificador_Success(t *testing.T) {
 		WillReturnRows(rows)
 
 	// Executar função
-	result, err := getCPFByCodIdentificador(codIdentificador)
+	result, err := getCPFByCodIdentificador(context.Background(), codIdentificador)
 
 	// Verificar resultados
 	assert.NoError(t, err)
@@ -60,9 +47,7 @@ func TestGetCPFByCodIdentificador_Success(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Restaurar variáveis globais
-	dbPool = originalDBPool
-	dbPoolOnce = originalDBPoolOnce
-	dbPoolInitErr = originalDBPoolInitErr
+	dbConn = originalDBConn
 }
 
 // TestGetCPFByCodIdentificador_NotFound testa quando CPF não é encontrado
@@ -73,18 +58,10 @@ func TestGetCPFByCodIdentificador_NotFound(t *testing.T) {
 	}
 	defer db.Close()
 
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
+	originalDBConn := dbConn
+	dbConn = connManagerPronto(db)
 
-	dbPool = db
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
-	dbPoolOnce.Do(func() {})
-
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
+	cpfCache.Clear()
 
 	codIdentificador := "999999"
 
@@ -92,7 +69,7 @@ func TestGetCPFByCodIdentificador_NotFound(t *testing.T) {
 		WithArgs(999999).
 		WillReturnError(sql.ErrNoRows)
 
-	result, err := getCPFByCodIdentificador(codIdentificador)
+	result, err := getCPFByCodIdentificador(context.Background(), codIdentificador)
 
 	assert.NoError(t, err)
 	assert.Equal(t, "", result)
@@ -100,9 +77,7 @@ func TestGetCPFByCodIdentificador_NotFound(t *testing.T) {
 	err = mock.ExpectationsWereMet()
 	assert.NoError(t, err)
 
-	dbPool = originalDBPool
-	dbPoolOnce = originalDBPoolOnce
-	dbPoolInitErr = originalDBPoolInitErr
+	dbConn = originalDBConn
 }
 
 // TestGetCPFByCodIdentificador_NullCPF testa quando CPF é NULL no banco
@@ -113,18 +88,10 @@ func TestGetCPFByCodIdentificador_NullCPF(t *testing.T) {
 	}
 	defer db.Close()
 
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-
-	dbPool = db
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
-	dbPoolOnce.Do(func() {})
+	originalDBConn := dbConn
+	dbConn = connManagerPronto(db)
 
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
+	cpfCache.Clear()
 
 	codIdentificador := "951716"
 
@@ -133,7 +100,7 @@ func TestGetCPFByCodIdentificador_NullCPF(t *testing.T) {
 		WithArgs(951716).
 		WillReturnRows(rows)
 
-	result, err := getCPFByCodIdentificador(codIdentificador)
+	result, err := getCPFByCodIdentificador(context.Background(), codIdentificador)
 
 	assert.NoError(t, err)
 	assert.Equal(t, "", result)
@@ -141,29 +108,23 @@ func TestGetCPFByCodIdentificador_NullCPF(t *testing.T) {
 	err = mock.ExpectationsWereMet()
 	assert.NoError(t, err)
 
-	dbPool = originalDBPool
-	dbPoolOnce = originalDBPoolOnce
-	dbPoolInitErr = originalDBPoolInitErr
+	dbConn = originalDBConn
 }
 
 // TestGetCPFByCodIdentificador_Cache testa se o cache está funcionando
 func TestGetCPFByCodIdentificador_Cache(t *testing.T) {
 	// Limpar cache
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCache["951716"] = "377.209.881-91"
-	cpfCacheLock.Unlock()
+	cpfCache.Clear()
+	cpfCache.Set("951716", "377.209.881-91", false)
 
 	// Não deve chamar o banco se estiver no cache
-	result, err := getCPFByCodIdentificador("951716")
+	result, err := getCPFByCodIdentificador(context.Background(), "951716")
 
 	assert.NoError(t, err)
 	assert.Equal(t, "377.209.881-91", result)
 
 	// Limpar cache após teste
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
+	cpfCache.Clear()
 }
 
 // TestGetCPFByCodIdentificador_StringConversion testa conversão de string para int
@@ -174,18 +135,10 @@ func TestGetCPFByCodIdentificador_StringConversion(t *testing.T) {
 	}
 	defer db.Close()
 
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-
-	dbPool = db
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
-	dbPoolOnce.Do(func() {})
+	originalDBConn := dbConn
+	dbConn = connManagerPronto(db)
 
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
+	cpfCache.Clear()
 
 	// Testar com código que não pode ser convertido para int
 	codIdentificador := "abc123"
@@ -196,7 +149,7 @@ func TestGetCPFByCodIdentificador_StringConversion(t *testing.T) {
 		WithArgs("abc123").
 		WillReturnRows(rows)
 
-	result, err := getCPFByCodIdentificador(codIdentificador)
+	result, err := getCPFByCodIdentificador(context.Background(), codIdentificador)
 
 	assert.NoError(t, err)
 	assert.Equal(t, cpfEsperado, result)
@@ -204,7 +157,5 @@ func TestGetCPFByCodIdentificador_StringConversion(t *testing.T) {
 	err = mock.ExpectationsWereMet()
 	assert.NoError(t, err)
 
-	dbPool = originalDBPool
-	dbPoolOnce = originalDBPoolOnce
-	dbPoolInitErr = originalDBPoolInitErr
+	dbConn = originalDBConn
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Estados da conexão com o banco, expostos em /health
+const (
+	connConectando = "conectando"
+	connPronto     = "pronto"
+	connFalhou     = "falhou"
+)
+
+// errSemDatabaseURL não é retentado: sem URL configurada não há com o que conectar
+var errSemDatabaseURL = errors.New("DATABASE_URL não configurada")
+
+// ConnStatus é o estado da conexão com o banco
+type ConnStatus struct {
+	Estado           string     `json:"estado"`
+	Erro             string     `json:"erro,omitempty"`
+	Tentativas       int        `json:"tentativas"`
+	ProximaTentativa *time.Time `json:"proxima_tentativa,omitempty"`
+	ConectadoEm      *time.Time `json:"conectado_em,omitempty"`
+}
+
+// connManager estabelece o pool de conexões em segundo plano, com novas tentativas em
+// intervalos exponenciais (retryMin, 2*retryMin, ... até retryMax) enquanto o banco estiver fora.
+// Uma falha na inicialização não é permanente: o processo conecta quando o banco voltar.
+// Só a primeira tentativa é esperada; depois de uma falha, Get devolve o último erro na hora.
+type connManager struct {
+	mu          sync.Mutex
+	open        func(ctx context.Context) (*sql.DB, error)
+	timeout     time.Duration // prazo de cada chamada a open
+	retryMin    time.Duration
+	retryMax    time.Duration
+	db          *sql.DB
+	estado      string
+	lastErr     error
+	tentativas  int
+	proxima     time.Time
+	conectadoEm time.Time
+	iniciado    bool
+	tentou      chan struct{} // fechado ao fim da primeira tentativa, com sucesso ou falha
+	sleep       func(time.Duration)
+}
+
+func newConnManager(c DBConfig, open func(ctx context.Context) (*sql.DB, error)) *connManager {
+	return &connManager{
+		open:     open,
+		timeout:  c.ConnectTimeout.Duration,
+		retryMin: c.RetryMin.Duration,
+		retryMax: c.RetryMax.Duration,
+		estado:   connConectando,
+		tentou:   make(chan struct{}),
+		sleep:    time.Sleep,
+	}
+}
+
+// dbConn é a conexão com o banco usada por getDBConnection
+var dbConn = newConnManager(defaultConfig().DB, openDatabase)
+
+// Start inicia as tentativas de conexão em segundo plano; chamadas seguintes não fazem nada
+func (m *connManager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.iniciado {
+		return
+	}
+	m.iniciado = true
+	go m.connect()
+}
+
+// backoff é o intervalo antes da próxima tentativa, dobrando a cada falha até retryMax
+func (m *connManager) backoff(tentativas int) time.Duration {
+	d := m.retryMin
+	for i := 1; i < tentativas && d < m.retryMax; i++ {
+		d *= 2
+	}
+	if d > m.retryMax {
+		d = m.retryMax
+	}
+	return d
+}
+
+func (m *connManager) connect() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		db, err := m.open(ctx)
+		cancel()
+
+		m.mu.Lock()
+		m.tentativas++
+		if m.tentativas == 1 {
+			close(m.tentou)
+		}
+		if err == nil {
+			m.db = db
+			m.estado = connPronto
+			m.lastErr = nil
+			m.proxima = time.Time{}
+			m.conectadoEm = time.Now()
+			m.mu.Unlock()
+			return
+		}
+
+		m.estado = connFalhou
+		m.lastErr = err
+		if errors.Is(err, errSemDatabaseURL) {
+			m.mu.Unlock()
+			slog.Error("sem conexão com o banco", "erro", err)
+			return
+		}
+		espera := m.backoff(m.tentativas)
+		m.proxima = time.Now().Add(espera)
+		tentativas := m.tentativas
+		m.mu.Unlock()
+
+		slog.Warn("tentativa de conexão com o banco falhou", "tentativa", tentativas, "erro", err, "nova_tentativa_em", espera.String())
+		m.sleep(espera)
+
+		m.mu.Lock()
+		m.estado = connConectando
+		m.mu.Unlock()
+	}
+}
+
+// Get devolve o pool, esperando até o prazo do contexto apenas pela primeira tentativa de conexão.
+// Com o banco fora do ar, as chamadas seguintes recebem o último erro sem esperar pelas novas tentativas.
+func (m *connManager) Get(ctx context.Context) (*sql.DB, error) {
+	m.Start()
+
+	m.mu.Lock()
+	falhou := m.db == nil && m.lastErr != nil
+	m.mu.Unlock()
+	if !falhou {
+		select {
+		case <-m.tentou:
+		case <-ctx.Done():
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.db != nil {
+		return m.db, nil
+	}
+	if m.lastErr != nil {
+		return nil, fmt.Errorf("banco de dados %s (tentativa %d): %w", m.estado, m.tentativas, m.lastErr)
+	}
+	return nil, fmt.Errorf("banco de dados %s: %w", m.estado, ctx.Err())
+}
+
+// Ready devolve o pool se a conexão já está pronta, sem esperar nem iniciar tentativas
+func (m *connManager) Ready() *sql.DB {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.db
+}
+
+// Status descreve o estado atual da conexão
+func (m *connManager) Status() ConnStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := ConnStatus{Estado: m.estado, Tentativas: m.tentativas}
+	if m.lastErr != nil {
+		status.Erro = m.lastErr.Error()
+	}
+	if !m.proxima.IsZero() {
+		proxima := m.proxima
+		status.ProximaTentativa = &proxima
+	}
+	if !m.conectadoEm.IsZero() {
+		conectadoEm := m.conectadoEm
+		status.ConectadoEm = &conectadoEm
+	}
+	return status
+}
+
+// openDatabase abre e testa o pool de conexões, aplicando as migrações pendentes (MIGRATE_ON_START).
+// O teste da conexão respeita o prazo de ctx, para que um host que não responde não prenda a tentativa.
+func openDatabase(ctx context.Context) (*sql.DB, error) {
+	// A URL vem da configuração (variável de ambiente, arquivo ou /run/secrets); não há URL padrão
+	if cfg.DatabaseURL == "" {
+		return nil, errSemDatabaseURL
+	}
+	slog.Info("conectando ao banco de dados", "banco", cfg.redactedDatabaseURL())
+
+	// O driver instrumentado cria um span por consulta, filho do span da requisição
+	db, err := otelsql.Open("postgres", connectionString(cfg.DatabaseURL),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir conexão com banco: %w", err)
+	}
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("erro ao conectar com banco (Ping falhou): %w", err)
+	}
+
+	slog.Info("conexão com o banco de dados estabelecida")
+
+	// Aplicar migrações pendentes (MIGRATE_ON_START); o advisory lock evita corrida entre réplicas
+	if cfg.MigrateOnStart {
+		if _, err = migrateUp(db); err != nil {
+			slog.Warn("erro ao aplicar migrações", "erro", err)
+		}
+	}
+
+	// Configurar pool de conexões
+	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime.Duration)
+	return db, nil
+}
+
+// getDBConnection retorna o pool de conexões com o banco de dados PostgreSQL, esperando no
+// máximo DB.ConnectWait (ou até ctx terminar) pela primeira tentativa de conexão
+func getDBConnection(ctx context.Context) (*sql.DB, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.DB.ConnectWait.Duration)
+	defer cancel()
+	return dbConn.Get(ctx)
+}
+
+// statementContext limita uma consulta a DB.StatementTimeout, preservando o cancelamento de ctx
+func statementContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, cfg.DB.StatementTimeout.Duration)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connManagerPronto devolve uma conexão já estabelecida com db, sem tentativas em segundo plano
+func connManagerPronto(db *sql.DB) *connManager {
+	m := newConnManager(defaultConfig().DB, func(context.Context) (*sql.DB, error) { return db, nil })
+	m.db, m.estado, m.iniciado = db, connPronto, true
+	close(m.tentou)
+	return m
+}
+
+// connManagerFalho devolve uma conexão com falha definitiva (sem novas tentativas)
+func connManagerFalho(err error) *connManager {
+	m := newConnManager(defaultConfig().DB, func(context.Context) (*sql.DB, error) { return nil, err })
+	m.estado, m.lastErr, m.iniciado = connFalhou, err, true
+	close(m.tentou)
+	return m
+}
+
+// TestConnManager_Backoff testa o intervalo exponencial entre as tentativas, limitado a retry_max
+func TestConnManager_Backoff(t *testing.T) {
+	m := newConnManager(DBConfig{RetryMin: Duration{time.Second}, RetryMax: Duration{10 * time.Second}}, nil)
+
+	var intervalos []time.Duration
+	for n := 1; n <= 6; n++ {
+		intervalos = append(intervalos, m.backoff(n))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}, intervalos)
+}
+
+// TestConnManager_Reconnect testa que falhas na conexão são retentadas até o banco responder
+func TestConnManager_Reconnect(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	var mu sync.Mutex
+	falhas := 2
+	m := newConnManager(defaultConfig().DB, func(context.Context) (*sql.DB, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if falhas > 0 {
+			falhas--
+			return nil, errors.New("conexão recusada")
+		}
+		return db, nil
+	})
+
+	esperas := make(chan time.Duration, 2)
+	continuar := make(chan struct{})
+	m.sleep = func(d time.Duration) {
+		esperas <- d
+		<-continuar
+	}
+
+	m.Start()
+	assert.Equal(t, time.Second, <-esperas)
+	status := m.Status()
+	assert.Equal(t, connFalhou, status.Estado)
+	assert.Equal(t, "conexão recusada", status.Erro)
+	assert.Equal(t, 1, status.Tentativas)
+	assert.NotNil(t, status.ProximaTentativa)
+
+	// Enquanto o banco não responde, a requisição recebe o último erro sem esperar pela nova tentativa
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	inicio := time.Now()
+	_, err = m.Get(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "conexão recusada")
+	assert.Less(t, time.Since(inicio), time.Second)
+
+	continuar <- struct{}{}
+	assert.Equal(t, 2*time.Second, <-esperas)
+	continuar <- struct{}{}
+	require.Eventually(t, func() bool { return m.Ready() != nil }, time.Second, time.Millisecond)
+
+	got, err := m.Get(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, db, got)
+
+	status = m.Status()
+	assert.Equal(t, connPronto, status.Estado)
+	assert.Empty(t, status.Erro)
+	assert.Equal(t, 3, status.Tentativas)
+	assert.Nil(t, status.ProximaTentativa)
+	assert.NotNil(t, status.ConectadoEm)
+}
+
+// TestConnManager_WaitsForConnection testa que a requisição espera a conexão em andamento
+func TestConnManager_WaitsForConnection(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	liberar := make(chan struct{})
+	m := newConnManager(defaultConfig().DB, func(context.Context) (*sql.DB, error) {
+		<-liberar
+		return db, nil
+	})
+	m.Start()
+	assert.Equal(t, connConectando, m.Status().Estado)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(liberar)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := m.Get(ctx)
+	require.NoError(t, err)
+	assert.Same(t, db, got)
+}
+
+// TestConnManager_FirstAttemptFails testa que quem espera a primeira tentativa é liberado quando ela falha
+func TestConnManager_FirstAttemptFails(t *testing.T) {
+	liberar := make(chan struct{})
+	m := newConnManager(defaultConfig().DB, func(context.Context) (*sql.DB, error) {
+		<-liberar
+		return nil, errors.New("conexão recusada")
+	})
+	m.sleep = func(time.Duration) { select {} }
+	m.Start()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(liberar)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	inicio := time.Now()
+	_, err := m.Get(ctx)
+	require.ErrorContains(t, err, "conexão recusada")
+	assert.Less(t, time.Since(inicio), time.Second, "Sem esperar o prazo do contexto")
+}
+
+// TestConnManager_ConnectTimeout testa que uma tentativa presa num host que não responde é abandonada e repetida
+func TestConnManager_ConnectTimeout(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	c := defaultConfig().DB
+	c.ConnectTimeout = Duration{20 * time.Millisecond}
+	var mu sync.Mutex
+	bloquear := true
+	m := newConnManager(c, func(ctx context.Context) (*sql.DB, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if bloquear {
+			bloquear = false
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return db, nil
+	})
+	esperas := make(chan time.Duration, 1)
+	continuar := make(chan struct{})
+	m.sleep = func(d time.Duration) {
+		esperas <- d
+		<-continuar
+	}
+
+	inicio := time.Now()
+	m.Start()
+	select {
+	case espera := <-esperas:
+		assert.Equal(t, time.Second, espera, "Backoff após a tentativa abandonada")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tentativa não foi abandonada no prazo")
+	}
+	assert.Less(t, time.Since(inicio), time.Second)
+	assert.Contains(t, m.Status().Erro, context.DeadlineExceeded.Error())
+
+	close(continuar)
+	require.Eventually(t, func() bool { return m.Ready() != nil }, time.Second, time.Millisecond)
+	assert.Equal(t, 2, m.Status().Tentativas)
+}
+
+// TestConnManager_NoURL testa que sem DATABASE_URL a falha é imediata e não é retentada
+func TestConnManager_NoURL(t *testing.T) {
+	comConfig(t, func(c *Config) {})
+
+	m := newConnManager(cfg.DB, openDatabase)
+	m.sleep = func(time.Duration) { t.Error("Sem URL não deve haver nova tentativa") }
+
+	_, err := m.Get(context.Background())
+	require.ErrorIs(t, err, errSemDatabaseURL)
+	assert.Equal(t, connFalhou, m.Status().Estado)
+	assert.Equal(t, 1, m.Status().Tentativas)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ProcessedUpload é um arquivo já registrado no histórico de uploads
+type ProcessedUpload struct {
+	Arquivo      string    `json:"arquivo"`
+	Hash         string    `json:"hash"`
+	DataIni      string    `json:"data_ini"`
+	DataFim      string    `json:"data_fim"`
+	ProcessadoEm time.Time `json:"processado_em"`
+	// RepetidoEm é o arquivo do lote atual com o mesmo conteúdo
+	RepetidoEm string `json:"repetido_em"`
+}
+
+// OverlappingOperation é uma operação do lote atual que já foi processada em um upload anterior
+type OverlappingOperation struct {
+	Arquivo         string    `json:"arquivo"`
+	Doc             string    `json:"doc"`
+	Indice          int       `json:"indice"`
+	Veiculo         string    `json:"veiculo"`
+	Datainicio      string    `json:"datainicio"`
+	Linha           string    `json:"linha"`
+	RoletaInicial   string    `json:"roleta_inicial"`
+	ArquivoOriginal string    `json:"arquivo_original"`
+	ProcessadoEm    time.Time `json:"processado_em"`
+}
+
+// DuplicateReport lista o conteúdo de um lote que já foi processado anteriormente
+type DuplicateReport struct {
+	ArquivosRepetidos  []ProcessedUpload      `json:"arquivos_repetidos"`
+	OperacoesRepetidas []OverlappingOperation `json:"operacoes_repetidas"`
+}
+
+// Empty indica se o relatório não encontrou nenhuma repetição
+func (r *DuplicateReport) Empty() bool {
+	return len(r.ArquivosRepetidos) == 0 && len(r.OperacoesRepetidas) == 0
+}
+
+// DuplicateUploadError é retornado quando o lote repete conteúdo já processado e a política é rejeitar
+type DuplicateUploadError struct {
+	Report *DuplicateReport
+}
+
+func (e *DuplicateUploadError) Error() string {
+	return fmt.Sprintf("lote contém conteúdo já processado: %d arquivo(s) e %d operação(ões) repetidos",
+		len(e.Report.ArquivosRepetidos), len(e.Report.OperacoesRepetidas))
+}
+
+// operacaoRegistro é uma operação processada que será gravada no histórico
+type operacaoRegistro struct {
+	Chave    string
+	Hash     string
+	Operacao Operacao
+}
+
+// checkDuplicates consulta o histórico de uploads pelos hashes dos arquivos e pelas chaves das operações
+func checkDuplicates(ctx context.Context, files []parsedFile) (*DuplicateReport, error) {
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DuplicateReport{}
+	if len(files) == 0 {
+		return report, nil
+	}
+
+	hashes := make([]string, 0, len(files))
+	arquivoPorHash := make(map[string]string)
+	for _, f := range files {
+		hashes = append(hashes, f.Hash)
+		arquivoPorHash[f.Hash] = f.Nome
+	}
+
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `
+		SELECT hash, nome, COALESCE(data_ini, ''), COALESCE(data_fim, ''), processado_em
+		FROM upload_arquivo
+		WHERE hash = ANY($1)
+	`, pq.Array(hashes))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar upload_arquivo: %w", err)
+	}
+	for rows.Next() {
+		var u ProcessedUpload
+		if err := rows.Scan(&u.Hash, &u.Arquivo, &u.DataIni, &u.DataFim, &u.ProcessadoEm); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("erro ao ler upload_arquivo: %w", err)
+		}
+		u.RepetidoEm = arquivoPorHash[u.Hash]
+		report.ArquivosRepetidos = append(report.ArquivosRepetidos, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao ler upload_arquivo: %w", err)
+	}
+
+	// Indexar as operações do lote pela chave natural
+	type origem struct {
+		arquivo string
+		doc     string
+		indice  int
+		op      Operacao
+	}
+	porChave := make(map[string]origem)
+	var chaves []string
+	for _, f := range files {
+		for _, btc := range f.Btcs.Btc {
+			for i, operacao := range btc.Operacoes.Operacao {
+				chave := operacaoKey(operacao)
+				if _, existe := porChave[chave]; existe {
+					continue
+				}
+				porChave[chave] = origem{arquivo: f.Nome, doc: btc.Doc, indice: i, op: operacao}
+				chaves = append(chaves, chave)
+			}
+		}
+	}
+	if len(chaves) == 0 {
+		return report, nil
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT o.chave, a.nome, a.processado_em
+		FROM upload_operacao o
+		JOIN upload_arquivo a ON a.hash = o.hash_arquivo
+		WHERE o.chave = ANY($1)
+	`, pq.Array(chaves))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar upload_operacao: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var chave, arquivoOriginal string
+		var processadoEm time.Time
+		if err := rows.Scan(&chave, &arquivoOriginal, &processadoEm); err != nil {
+			return nil, fmt.Errorf("erro ao ler upload_operacao: %w", err)
+		}
+		o := porChave[chave]
+		report.OperacoesRepetidas = append(report.OperacoesRepetidas, OverlappingOperation{
+			Arquivo:         o.arquivo,
+			Doc:             o.doc,
+			Indice:          o.indice,
+			Veiculo:         o.op.Veiculo,
+			Datainicio:      o.op.Datainicio,
+			Linha:           o.op.Linha,
+			RoletaInicial:   o.op.RoletaInicial,
+			ArquivoOriginal: arquivoOriginal,
+			ProcessadoEm:    processadoEm,
+		})
+	}
+
+	return report, rows.Err()
+}
+
+// uploadRegistro é um lote processado a gravar no histórico de uploads
+type uploadRegistro struct {
+	files     []parsedFile
+	registros []operacaoRegistro
+}
+
+// registerUpload grava os arquivos e as operações do lote no histórico de uploads
+func registerUpload(ctx context.Context, files []parsedFile, registros []operacaoRegistro) error {
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := (&uploadRegistro{files: files, registros: registros}).save(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// save grava o lote no histórico dentro de tx, sem confirmá-la
+func (u *uploadRegistro) save(ctx context.Context, tx *sql.Tx) error {
+	files, registros := u.files, u.registros
+	operacoesPorHash := make(map[string]int)
+	for _, r := range registros {
+		operacoesPorHash[r.Hash]++
+	}
+
+	for _, f := range files {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO upload_arquivo (hash, nome, cod_empresa, data_ini, data_fim, operacoes)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (hash) DO NOTHING
+		`, f.Hash, f.Nome, f.Btcs.CodEmpresa, f.Btcs.DataIni, f.Btcs.DataFim, operacoesPorHash[f.Hash])
+		if err != nil {
+			return fmt.Errorf("erro ao registrar arquivo %s: %w", f.Nome, err)
+		}
+	}
+
+	if len(registros) > 0 {
+		chaves := make([]string, len(registros))
+		hashes := make([]string, len(registros))
+		veiculos := make([]string, len(registros))
+		inicios := make([]string, len(registros))
+		linhas := make([]string, len(registros))
+		roletas := make([]string, len(registros))
+		for i, r := range registros {
+			chaves[i] = r.Chave
+			hashes[i] = r.Hash
+			veiculos[i] = strings.TrimSpace(r.Operacao.Veiculo)
+			inicios[i] = strings.TrimSpace(r.Operacao.Datainicio)
+			linhas[i] = strings.TrimSpace(r.Operacao.Linha)
+			roletas[i] = strings.TrimSpace(r.Operacao.RoletaInicial)
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO upload_operacao (chave, hash_arquivo, veiculo, datainicio, linha, roleta_inicial)
+			SELECT * FROM unnest($1::text[], $2::text[], $3::text[], $4::text[], $5::text[], $6::text[])
+			ON CONFLICT (chave) DO NOTHING
+		`, pq.Array(chaves), pq.Array(hashes), pq.Array(veiculos), pq.Array(inicios), pq.Array(linhas), pq.Array(roletas))
+		if err != nil {
+			return fmt.Errorf("erro ao registrar operações: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// comBancoMock substitui o pool de conexões por um sqlmock durante o teste
+func comBancoMock(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	semBanco(t)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	dbConn = connManagerPronto(db)
+	return mock
+}
+
+func dedupFixture() []BatchFile {
+	return []BatchFile{{
+		Nome:     "garagem.xml",
+		Conteudo: []byte(btcXML("77", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"))),
+	}}
+}
+
+// TestOperacaoKey testa a chave natural da operação
+func TestOperacaoKey(t *testing.T) {
+	op := Operacao{Veiculo: " 1001", Datainicio: "2024-01-15 08:00:00", Linha: "1001 ", RoletaInicial: "100"}
+	assert.Equal(t, "1001|2024-01-15 08:00:00|1001|100", operacaoKey(op))
+}
+
+// TestProcessBatch_RejectsProcessedContent testa a rejeição de conteúdo já processado
+func TestProcessBatch_RejectsProcessedContent(t *testing.T) {
+	mock := comBancoMock(t)
+	processadoEm := time.Date(2024, 1, 16, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("FROM upload_arquivo").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "nome", "data_ini", "data_fim", "processado_em"}))
+	mock.ExpectQuery("FROM upload_operacao").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"chave", "nome", "processado_em"}).
+			AddRow("1001|2024-01-15 08:00:00|1001|100", "janeiro.xml", processadoEm))
+
+	_, err := ProcessBatchWithOptions(dedupFixture(), filepath.Join(t.TempDir(), "output.csv"), BatchOptions{CheckDuplicates: true})
+
+	var dupErr *DuplicateUploadError
+	require.True(t, errors.As(err, &dupErr), "Deve retornar DuplicateUploadError")
+	require.Len(t, dupErr.Report.OperacoesRepetidas, 1)
+	op := dupErr.Report.OperacoesRepetidas[0]
+	assert.Equal(t, "garagem.xml", op.Arquivo)
+	assert.Equal(t, "77", op.Doc)
+	assert.Equal(t, 0, op.Indice)
+	assert.Equal(t, "janeiro.xml", op.ArquivoOriginal)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestProcessBatch_ForceProcessedContent testa o override que processa e registra mesmo com repetição
+func TestProcessBatch_ForceProcessedContent(t *testing.T) {
+	mock := comBancoMock(t)
+	files := dedupFixture()
+	hash := contentHash(files[0].Conteudo)
+
+	mock.ExpectQuery("FROM upload_arquivo").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "nome", "data_ini", "data_fim", "processado_em"}).
+			AddRow(hash, "janeiro.xml", "2024-01-15", "2024-01-15", time.Now()))
+	mock.ExpectQuery("FROM upload_operacao").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"chave", "nome", "processado_em"}))
+	mock.ExpectQuery("FROM parametro_viagem").WithArgs("{1001}").
+		WillReturnRows(sqlmock.NewRows([]string{"cod_linha", "local1", "local2", "linha", "cod_antt", "lat1", "long1", "lat2", "long2", "distancia_km", "distancia_minutos"}))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO upload_arquivo").
+		WithArgs(hash, "garagem.xml", "", "2024-01-15", "2024-01-15", 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO upload_operacao").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := ProcessBatchWithOptions(files, filepath.Join(t.TempDir(), "output.csv"), BatchOptions{CheckDuplicates: true, Force: true})
+	require.NoError(t, err)
+
+	require.NotNil(t, result.Duplicados, "Relatório de repetição deve acompanhar o resultado")
+	require.Len(t, result.Duplicados.ArquivosRepetidos, 1)
+	assert.Equal(t, "garagem.xml", result.Duplicados.ArquivosRepetidos[0].RepetidoEm)
+	assert.Equal(t, 1, result.Linhas)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestProcessBatch_DuplicateCheckWithoutDB testa que o lote é processado quando o histórico está indisponível
+func TestProcessBatch_DuplicateCheckWithoutDB(t *testing.T) {
+	semCadastro(t, "1001")
+
+	result, err := ProcessBatchWithOptions(dedupFixture(), filepath.Join(t.TempDir(), "output.csv"), BatchOptions{CheckDuplicates: true})
+	require.NoError(t, err)
+	assert.Nil(t, result.Duplicados)
+	assert.Equal(t, 1, result.Linhas)
+}
+
+// TestUploadHandler_DuplicateConflict testa a resposta 409 listando as operações repetidas
+func TestUploadHandler_DuplicateConflict(t *testing.T) {
+	mock := comBancoMock(t)
+	gin.SetMode(gin.TestMode)
+
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	mock.ExpectQuery("FROM upload_arquivo").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "nome", "data_ini", "data_fim", "processado_em"}))
+	mock.ExpectQuery("FROM upload_operacao").
+		WillReturnRows(sqlmock.NewRows([]string{"chave", "nome", "processado_em"}).
+			AddRow("1001|2024-01-15 08:00:00|1001|100", "janeiro.xml", time.Now()))
+
+	router := gin.New()
+	router.POST("/upload", uploadHandler)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "garagem.xml")
+	part.Write(dedupFixture()[0].Conteudo)
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var resp struct {
+		Duplicados DuplicateReport `json:"duplicados"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Duplicados.OperacoesRepetidas, 1)
+}
+
+// TestProcessBatchContext_CanceledRemovesOutput testa que o CSV já gravado é removido quando o contexto termina antes do registro
+func TestProcessBatchContext_CanceledRemovesOutput(t *testing.T) {
+	mock := comBancoMock(t)
+	mock.ExpectQuery("FROM upload_arquivo").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "nome", "data_ini", "data_fim", "processado_em"}))
+	mock.ExpectQuery("FROM upload_operacao").
+		WillReturnRows(sqlmock.NewRows([]string{"chave", "nome", "processado_em"}))
+	mock.ExpectQuery("FROM parametro_viagem").WithArgs("{1001}").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows(parametroViagemColumns))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	csvPath := filepath.Join(t.TempDir(), "output.csv")
+
+	_, err := ProcessBatchContext(ctx, dedupFixture(), csvPath, BatchOptions{CheckDuplicates: true})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NoFileExists(t, csvPath, "Saída parcial deve ser removida")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Lote cancelado não é registrado no histórico")
+}
+
+// TestProcessBatch_DeferRegister testa que o lote do job fica no resultado, sem gravar o histórico
+func TestProcessBatch_DeferRegister(t *testing.T) {
+	mock := comBancoMock(t)
+	mock.ExpectQuery("FROM upload_arquivo").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "nome", "data_ini", "data_fim", "processado_em"}))
+	mock.ExpectQuery("FROM upload_operacao").
+		WillReturnRows(sqlmock.NewRows([]string{"chave", "nome", "processado_em"}))
+	mock.ExpectQuery("FROM parametro_viagem").WithArgs("{1001}").
+		WillReturnRows(sqlmock.NewRows(parametroViagemColumns))
+
+	result, err := ProcessBatchContext(context.Background(), dedupFixture(), "", BatchOptions{CheckDuplicates: true, DeferRegister: true})
+	require.NoError(t, err)
+	require.NotNil(t, result.registro)
+	assert.Len(t, result.registro.files, 1)
+	assert.Len(t, result.registro.registros, 1)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Sem INSERT no histórico")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opções de ?banco_indisponivel= para uploads quando o enriquecimento depende de um banco fora do ar
+const (
+	// degradadoFalhar recusa o lote com 503 (padrão)
+	degradadoFalhar = "falhar"
+	// degradadoMarcar gera a saída com a coluna status_enriquecimento e o cabeçalho X-Export-Incompleto
+	degradadoMarcar = "marcar"
+)
+
+// Valores da coluna status_enriquecimento
+const (
+	statusCompleto   = "OK"
+	statusIncompleto = "INCOMPLETO"
+)
+
+// ErrBancoIndisponivel indica que CPF ou parâmetros da linha não puderam ser consultados
+var ErrBancoIndisponivel = errors.New("banco de dados indisponível")
+
+// ErrCircuitoAberto indica que a consulta nem foi tentada porque o banco falhou repetidamente
+var ErrCircuitoAberto = fmt.Errorf("%w (circuito aberto)", ErrBancoIndisponivel)
+
+// EnrichmentError é a falha ao buscar um dado de enriquecimento (cpf ou linha) de um código
+type EnrichmentError struct {
+	Campo  string
+	Codigo string
+	Err    error
+}
+
+func (e *EnrichmentError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Campo, e.Codigo, e.Err)
+}
+
+func (e *EnrichmentError) Unwrap() error {
+	return e.Err
+}
+
+// DegradedError é devolvido por ProcessBatchWithOptions quando operações ficaram sem
+// enriquecimento por falha do banco e a opção é recusar o lote
+type DegradedError struct {
+	Incompletas int
+	Total       int
+	Err         error
+}
+
+func (e *DegradedError) Error() string {
+	return fmt.Sprintf("%d de %d operações sem enriquecimento: %v", e.Incompletas, e.Total, e.Err)
+}
+
+func (e *DegradedError) Unwrap() error {
+	return e.Err
+}
+
+// enrichmentStatus monta o valor da coluna status_enriquecimento, ex.: "INCOMPLETO: cpf, linha"
+func enrichmentStatus(errs []error) string {
+	if len(errs) == 0 {
+		return statusCompleto
+	}
+	var campos []string
+	for _, err := range errs {
+		var enrichErr *EnrichmentError
+		if errors.As(err, &enrichErr) {
+			campos = append(campos, enrichErr.Campo)
+		}
+	}
+	return statusIncompleto + ": " + strings.Join(campos, ", ")
+}
+
+// withStatusColumn devolve uma cópia do layout com a coluna status_enriquecimento no final,
+// ou o próprio layout quando ele já a inclui
+func withStatusColumn(l *ExportLayout) *ExportLayout {
+	for _, c := range l.Colunas {
+		if strings.EqualFold(strings.TrimSpace(c.Campo), "status_enriquecimento") {
+			return l
+		}
+	}
+	copia := *l
+	copia.Colunas = append(append([]LayoutColumn(nil), l.Colunas...), LayoutColumn{Campo: "status_enriquecimento"})
+	return &copia
+}
+
+// circuitBreaker evita consultar o banco a cada operação quando ele está falhando:
+// após threshold falhas seguidas as consultas falham direto durante cooldown,
+// depois uma nova tentativa decide se o circuito fecha ou continua aberto
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	falhas    int
+	abertoAte time.Time
+	now       func() time.Time
+}
+
+func newCircuitBreaker(c DBConfig) *circuitBreaker {
+	return &circuitBreaker{threshold: c.BreakerThreshold, cooldown: c.BreakerCooldown.Duration, now: time.Now}
+}
+
+// dbBreaker protege as consultas de enriquecimento
+var dbBreaker = newCircuitBreaker(defaultConfig().DB)
+
+// Allow informa se a consulta pode ser feita
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.falhas < b.threshold || !b.now().Before(b.abertoAte)
+}
+
+// Success fecha o circuito
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.falhas = 0
+}
+
+// Failure conta uma falha e abre o circuito ao atingir o limite
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.falhas++
+	if b.falhas >= b.threshold {
+		b.abertoAte = b.now().Add(b.cooldown)
+	}
+}
+
+// State descreve o circuito: fechado, aberto ou meio-aberto (aguardando nova tentativa)
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.falhas < b.threshold:
+		return "fechado"
+	case b.now().Before(b.abertoAte):
+		return "aberto"
+	}
+	return "meio-aberto"
+}
+
+// enrichmentDB devolve o pool para uma consulta de enriquecimento ou o erro tipado da indisponibilidade.
+// O circuito é conferido antes de esperar pela conexão, e a falha ao conectar conta como falha do banco.
+func enrichmentDB(ctx context.Context, campo, codigo string) (*sql.DB, error) {
+	if !dbBreaker.Allow() {
+		return nil, &EnrichmentError{Campo: campo, Codigo: codigo, Err: ErrCircuitoAberto}
+	}
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		dbBreaker.Failure()
+		return nil, &EnrichmentError{Campo: campo, Codigo: codigo, Err: fmt.Errorf("%w: %v", ErrBancoIndisponivel, err)}
+	}
+	return db, nil
+}
+
+// queryFailed registra a falha no circuito e devolve o erro tipado da consulta.
+// Se a própria requisição foi cancelada, o banco não tem culpa: devolve o erro do contexto.
+func queryFailed(ctx context.Context, campo, codigo string, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	dbBreaker.Failure()
+	slog.WarnContext(ctx, "falha na consulta de enriquecimento", "campo", campo, "codigo", codigo, "erro", err)
+	return &EnrichmentError{Campo: campo, Codigo: codigo, Err: fmt.Errorf("%w: %v", ErrBancoIndisponivel, err)}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGetCPFByCodIdentificador_Unavailable testa o erro tipado quando o banco não está disponível
+func TestGetCPFByCodIdentificador_Unavailable(t *testing.T) {
+	semBanco(t)
+
+	cpf, err := getCPFByCodIdentificador(context.Background(), "951716")
+	assert.Empty(t, cpf)
+	require.ErrorIs(t, err, ErrBancoIndisponivel)
+
+	var enrichErr *EnrichmentError
+	require.True(t, errors.As(err, &enrichErr))
+	assert.Equal(t, "cpf", enrichErr.Campo)
+	assert.Equal(t, "951716", enrichErr.Codigo)
+	assert.False(t, cpfCache.Contains("951716"), "Falha do banco não vai para o cache")
+
+	_, err = getParametroViagemByCodLinha(context.Background(), "1001")
+	assert.ErrorIs(t, err, ErrBancoIndisponivel)
+}
+
+// TestCircuitBreaker testa a abertura após falhas seguidas e a nova tentativa após a espera
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(DBConfig{BreakerThreshold: 2, BreakerCooldown: Duration{30 * time.Second}})
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.False(t, b.Allow())
+	assert.Equal(t, "aberto", b.State())
+
+	now = now.Add(31 * time.Second)
+	assert.True(t, b.Allow(), "Nova tentativa após a espera")
+	assert.Equal(t, "meio-aberto", b.State())
+	b.Success()
+	assert.Equal(t, "fechado", b.State())
+}
+
+// TestGetCPFByCodIdentificador_CircuitOpen testa que com o circuito aberto o banco não é consultado
+func TestGetCPFByCodIdentificador_CircuitOpen(t *testing.T) {
+	mock := comBancoMock(t)
+	dbBreaker = newCircuitBreaker(DBConfig{BreakerThreshold: 1, BreakerCooldown: Duration{time.Minute}})
+
+	mock.ExpectQuery("SELECT cpf FROM pessoa").WithArgs(951716).WillReturnError(errors.New("conexão recusada"))
+	_, err := getCPFByCodIdentificador(context.Background(), "951716")
+	require.ErrorIs(t, err, ErrBancoIndisponivel)
+
+	_, err = getCPFByCodIdentificador(context.Background(), "951717")
+	assert.ErrorIs(t, err, ErrCircuitoAberto)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestEnrichmentDB_ConnectionFailures testa que falhas ao conectar abrem o circuito
+func TestEnrichmentDB_ConnectionFailures(t *testing.T) {
+	semBanco(t)
+	dbBreaker = newCircuitBreaker(DBConfig{BreakerThreshold: 2, BreakerCooldown: Duration{time.Minute}})
+
+	for _, codigo := range []string{"951716", "951717"} {
+		_, err := getCPFByCodIdentificador(context.Background(), codigo)
+		require.ErrorIs(t, err, ErrBancoIndisponivel)
+		assert.NotErrorIs(t, err, ErrCircuitoAberto)
+	}
+	assert.Equal(t, "aberto", dbBreaker.State())
+
+	// Com o circuito aberto nem a conexão é pedida
+	dbConn = connManagerFalho(errors.New("não deveria ser consultado"))
+	_, err := getParametroViagemByCodLinha(context.Background(), "1001")
+	require.ErrorIs(t, err, ErrCircuitoAberto)
+	assert.NotContains(t, err.Error(), "não deveria")
+}
+
+// TestProcessBatch_Degraded testa a recusa do lote e a saída marcada como incompleta
+func TestProcessBatch_Degraded(t *testing.T) {
+	semBanco(t)
+	files := []BatchFile{{Nome: "btc.xml", Conteudo: []byte(btcXML("1", "951716",
+		operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00")))}}
+	csvPath := filepath.Join(t.TempDir(), "output.csv")
+
+	_, err := ProcessBatchWithOptions(files, csvPath, BatchOptions{})
+	var degradedErr *DegradedError
+	require.True(t, errors.As(err, &degradedErr))
+	assert.Equal(t, 1, degradedErr.Incompletas)
+	assert.ErrorIs(t, err, ErrBancoIndisponivel)
+	assert.NoFileExists(t, csvPath)
+
+	// Os atalhos para bibliotecas também recusam o lote; marcar é sempre uma escolha explícita
+	_, err = ProcessBatch(files, csvPath)
+	require.ErrorAs(t, err, &degradedErr)
+	assert.NoFileExists(t, csvPath)
+	xmlPath := filepath.Join(t.TempDir(), "btc.xml")
+	require.NoError(t, os.WriteFile(xmlPath, files[0].Conteudo, 0o644))
+	_, err = ProcessXML(xmlPath)
+	require.ErrorAs(t, err, &degradedErr)
+
+	result, err := ProcessBatchWithOptions(files, csvPath, BatchOptions{Degradado: degradadoMarcar})
+	require.NoError(t, err)
+	assert.True(t, result.Incompleto)
+	assert.Equal(t, 1, result.OperacoesIncompletas)
+	assert.Equal(t, "INCOMPLETO: linha, cpf", result.Rows[0].StatusEnriquecimento)
+
+	rows := readCSV(t, csvPath)
+	assert.Equal(t, "STATUS_ENRIQUECIMENTO", rows[0][len(rows[0])-1])
+	assert.Equal(t, "INCOMPLETO: linha, cpf", rows[1][len(rows[1])-1])
+}
+
+// TestUploadHandler_Degraded testa a resposta 503 e a opção ?banco_indisponivel=marcar
+func TestUploadHandler_Degraded(t *testing.T) {
+	semBanco(t)
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	w := uploadRequest(t, "/upload", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "banco_indisponivel=marcar")
+
+	w = uploadRequest(t, "/upload?banco_indisponivel=marcar", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "true", w.Header().Get("X-Export-Incompleto"))
+	header := strings.SplitN(w.Body.String(), "\n", 2)[0]
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(header), "STATUS_ENRIQUECIMENTO"))
+
+	w = uploadRequest(t, "/upload?banco_indisponivel=marcar&format=json", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status_enriquecimento":"INCOMPLETO: linha"`)
+
+	w = uploadRequest(t, "/upload?banco_indisponivel=ignorar", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestProcessBatch_NotFoundIsComplete testa que "não encontrado" não marca a operação como incompleta
+func TestProcessBatch_NotFoundIsComplete(t *testing.T) {
+	mock := comBancoMock(t)
+	mock.ExpectQuery("FROM pessoa").WillReturnRows(sqlmock.NewRows([]string{"cod_identificador", "cpf"}))
+	mock.ExpectQuery("FROM parametro_viagem").WillReturnRows(sqlmock.NewRows(parametroViagemColumns))
+
+	files := []BatchFile{{Nome: "btc.xml", Conteudo: []byte(btcXML("1", "951716",
+		operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00")))}}
+	result, err := ProcessBatchWithOptions(files, "", BatchOptions{})
+	require.NoError(t, err)
+	assert.False(t, result.Incompleto)
+	assert.Equal(t, statusCompleto, result.Rows[0].StatusEnriquecimento)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestGetCPFByCodIdentificador_StatementTimeout testa que a consulta lenta é interrompida e conta como falha do banco
+func TestGetCPFByCodIdentificador_StatementTimeout(t *testing.T) {
+	mock := comBancoMock(t)
+	comConfig(t, func(c *Config) { c.DB.StatementTimeout = Duration{10 * time.Millisecond} })
+
+	mock.ExpectQuery("SELECT cpf FROM pessoa").WithArgs(951716).WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"cpf"}).AddRow("12345678901"))
+	inicio := time.Now()
+	_, err := getCPFByCodIdentificador(context.Background(), "951716")
+	assert.Less(t, time.Since(inicio), 500*time.Millisecond, "Consulta deve ser interrompida no statement_timeout")
+	require.ErrorIs(t, err, ErrBancoIndisponivel)
+	assert.Equal(t, 1, dbBreaker.falhas)
+}
+
+// TestGetCPFByCodIdentificador_Canceled testa que a requisição cancelada não conta como falha do banco
+func TestGetCPFByCodIdentificador_Canceled(t *testing.T) {
+	mock := comBancoMock(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mock.ExpectQuery("SELECT cpf FROM pessoa").WithArgs(951716).WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"cpf"}).AddRow("12345678901"))
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := getCPFByCodIdentificador(ctx, "951716")
+	require.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrBancoIndisponivel)
+	assert.Equal(t, "fechado", dbBreaker.State())
+	assert.Zero(t, dbBreaker.falhas)
+	assert.False(t, cpfCache.Contains("951716"))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Formatos de saída aceitos em /upload
+const (
+	formatCSV  = "csv"
+	formatJSON = "json"
+	formatXLSX = "xlsx"
+
+	mimeCSV  = "text/csv"
+	mimeJSON = "application/json"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// columnKind indica como o valor de uma coluna deve ser tipado e formatado
+type columnKind int
+
+const (
+	kindText     columnKind = iota
+	kindInt                 // inteiro
+	kindDecimal             // decimal com ponto, ex.: -15.43488062
+	kindDate                // texto no formato 02/01/2006
+	kindTime                // texto no formato 15:04:05
+	kindDuration            // texto no formato hh:mm:ss, podendo passar de 24h
+	kindDateTime            // texto no formato 02/01/2006 15:04:05
+)
+
+// exportField é um campo exportável: tipo e valor textual canônico (o usado no CSV da ANTT)
+type exportField struct {
+	Kind  columnKind
+	Value func(d GroupedData) string
+}
+
+// exportColumn é um campo posicionado na saída, com a chave JSON e o título do cabeçalho
+type exportColumn struct {
+	Key    string
+	Header string
+	Format string
+	exportField
+}
+
+// exportFields são os campos de GroupedData e os campos derivados disponíveis para os layouts
+var exportFields = map[string]exportField{
+	"empresa":               {kindText, func(d GroupedData) string { return d.Empresa }},
+	"prefixo":               {kindText, func(d GroupedData) string { return d.PrefixoANTT }},
+	"codigo_linha":          {kindText, func(d GroupedData) string { return d.Linha }},
+	"sentido":               {kindText, func(d GroupedData) string { return d.Sentido }},
+	"data_inicio_viagem":    {kindDate, func(d GroupedData) string { return d.DataInicioViagem.Format("02/01/2006") }},
+	"hora_inicio_viagem":    {kindTime, func(d GroupedData) string { return d.HoraInicioViagem }},
+	"hora_final_viagem":     {kindTime, func(d GroupedData) string { return d.HoraFinalViagem }},
+	"qte_pax_pagantes":      {kindInt, func(d GroupedData) string { return strconv.Itoa(d.QtePaxPagantes) }},
+	"qte_idoso":             {kindInt, func(d GroupedData) string { return strconv.Itoa(d.Idoso) }},
+	"qte_pl":                {kindInt, func(d GroupedData) string { return strconv.Itoa(d.PasseLivre) }},
+	"qte_outras_gratuidade": {kindInt, func(d GroupedData) string { return strconv.Itoa(d.QteOutrasGratuidade) }},
+	"qte_total_pax":         {kindInt, func(d GroupedData) string { return strconv.Itoa(d.QteTotalPax) }},
+	"qte_pago_dinheiro":     {kindInt, func(d GroupedData) string { return strconv.Itoa(d.QtePagoDinheiro) }},
+	"qte_pago_eletronico":   {kindInt, func(d GroupedData) string { return strconv.Itoa(d.QtePagoEletronico) }},
+	"distancia_viagem":      {kindInt, func(d GroupedData) string { return strconv.Itoa(int(d.DistanciaViagem)) }},
+	"tempo_viagem":          {kindDuration, func(d GroupedData) string { return d.TempoViagem }},
+	"velocidade_media":      {kindInt, func(d GroupedData) string { return strconv.Itoa(int(d.VelocidadeMedia)) }},
+	"lt_abertura_viagem":    {kindDecimal, func(d GroupedData) string { return d.LtAberturaViagem }},
+	"lg_abertura_viagem":    {kindDecimal, func(d GroupedData) string { return d.LgAberturaViagem }},
+	"lt_fechamento_viagem":  {kindDecimal, func(d GroupedData) string { return d.LtFechamentoViagem }},
+	"lg_fechamento_viagem":  {kindDecimal, func(d GroupedData) string { return d.LgFechamentoViagem }},
+	"veiculo_numero":        {kindText, func(d GroupedData) string { return d.VeiculoNumero }},
+	"cpf_rodoviario":        {kindText, func(d GroupedData) string { return d.CPFRodoviario }},
+
+	// Campos derivados
+	"data_hora_inicio": {kindDateTime, func(d GroupedData) string {
+		return d.DataInicioViagem.Format("02/01/2006") + " " + d.HoraInicioViagem
+	}},
+	"mes_referencia": {kindText, func(d GroupedData) string { return d.DataInicioViagem.Format("01/2006") }},
+	"duracao_minutos": {kindInt, func(d GroupedData) string {
+		if f, ok := parseClock(d.TempoViagem); ok {
+			return strconv.Itoa(int(math.Round(f * 24 * 60)))
+		}
+		return ""
+	}},
+	"qte_gratuidades": {kindInt, func(d GroupedData) string {
+		return strconv.Itoa(d.Idoso + d.PasseLivre + d.QteOutrasGratuidade)
+	}},
+	// Incluída automaticamente quando o lote é aceito com ?banco_indisponivel=marcar
+	"status_enriquecimento": {kindText, func(d GroupedData) string { return d.StatusEnriquecimento }},
+}
+
+// anttFields são os campos do CSV entregue à ANTT, na ordem exigida
+var anttFields = []string{
+	"empresa", "prefixo", "codigo_linha", "sentido", "data_inicio_viagem",
+	"hora_inicio_viagem", "hora_final_viagem", "qte_pax_pagantes", "qte_idoso",
+	"qte_pl", "qte_outras_gratuidade", "qte_total_pax", "qte_pago_dinheiro",
+	"qte_pago_eletronico", "distancia_viagem", "tempo_viagem", "velocidade_media",
+	"lt_abertura_viagem", "lg_abertura_viagem", "lt_fechamento_viagem",
+	"lg_fechamento_viagem", "veiculo_numero", "cpf_rodoviario",
+}
+
+// exportColumns são as colunas do layout padrão (ANTT)
+var exportColumns = mustColumns(defaultLayout())
+
+// typedValue converte o valor textual de uma coluna para o tipo usado em JSON.
+// Datas viram ISO 8601 (2006-01-02) e valores numéricos vazios viram null.
+func typedValue(kind columnKind, value string) interface{} {
+	switch kind {
+	case kindInt:
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+		return nil
+	case kindDecimal:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+		return nil
+	case kindDate:
+		if t, err := time.Parse("02/01/2006", value); err == nil {
+			return t.Format("2006-01-02")
+		}
+		return nil
+	case kindDateTime:
+		if t, err := time.Parse("02/01/2006 15:04:05", value); err == nil {
+			return t.Format("2006-01-02T15:04:05")
+		}
+		return nil
+	}
+	return value
+}
+
+// jsonRow é um objeto JSON que preserva a ordem das colunas
+type jsonRow struct {
+	keys   []string
+	values []interface{}
+}
+
+func (r jsonRow) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonRows converte as linhas para objetos JSON tipados, usando o nome do campo como chave
+func jsonRows(columns []exportColumn, rows []GroupedData) []jsonRow {
+	keys := make([]string, len(columns))
+	for i, col := range columns {
+		keys[i] = col.Key
+	}
+
+	out := make([]jsonRow, 0, len(rows))
+	for _, data := range rows {
+		values := make([]interface{}, len(columns))
+		for i, col := range columns {
+			values[i] = typedValue(col.Kind, col.Value(data))
+		}
+		out = append(out, jsonRow{keys: keys, values: values})
+	}
+	return out
+}
+
+// writeJSON grava o resumo do lote e as viagens tipadas em JSON
+func writeJSON(w io.Writer, columns []exportColumn, result *BatchResult, rows []GroupedData) error {
+	return json.NewEncoder(w).Encode(struct {
+		Resumo  *BatchResult `json:"resumo"`
+		Viagens []jsonRow    `json:"viagens"`
+	}{result, jsonRows(columns, rows)})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportFixture() GroupedData {
+	return GroupedData{
+		Empresa:            "Amazonia Inter Turismo LTDA",
+		PrefixoANTT:        "12073070",
+		Linha:              "1001",
+		Sentido:            "GO-DF",
+		DataInicioViagem:   time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		HoraInicioViagem:   "08:00:00",
+		HoraFinalViagem:    "09:30:00",
+		QtePaxPagantes:     45,
+		DistanciaViagem:    42,
+		TempoViagem:        "01:30:00",
+		VelocidadeMedia:    28,
+		LtAberturaViagem:   "-15.43488062",
+		LgAberturaViagem:   "-47.6108282",
+		LtFechamentoViagem: "",
+		VeiculoNumero:      "JHX-0E23",
+	}
+}
+
+// TestJSONRows_TypedValues testa números tipados, datas ISO e coordenadas vazias como null
+func TestJSONRows_TypedValues(t *testing.T) {
+	data, err := json.Marshal(jsonRows(exportColumns, []GroupedData{exportFixture()}))
+	require.NoError(t, err)
+
+	var rows []map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &rows))
+	require.Len(t, rows, 1)
+
+	row := rows[0]
+	assert.Equal(t, "2024-01-15", row["data_inicio_viagem"])
+	assert.Equal(t, float64(45), row["qte_pax_pagantes"])
+	assert.Equal(t, -15.43488062, row["lt_abertura_viagem"])
+	assert.Nil(t, row["lt_fechamento_viagem"])
+	assert.Equal(t, "1001", row["codigo_linha"], "Código da linha continua texto")
+	assert.True(t, strings.HasPrefix(string(data), `[{"empresa":`), "Chaves devem seguir a ordem das colunas")
+}
+
+// TestWriteXLSX_Structure testa o pacote XLSX gerado: partes obrigatórias, cabeçalho e células numéricas
+func TestWriteXLSX_Structure(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeXLSX(&buf, exportColumns, []GroupedData{exportFixture()}))
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	parts := make(map[string]string)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = string(content)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		assert.Contains(t, parts, name)
+	}
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `<c r="A1" t="inlineStr" s="1"><is><t>EMPRESA</t></is></c>`, "Cabeçalho deve usar o estilo formatado")
+	assert.Contains(t, sheet, `<c r="E2" s="2"><v>45306</v></c>`, "Data deve ser número serial do Excel")
+	assert.Contains(t, sheet, `<c r="H2" s="5"><v>45</v></c>`, "Quantidades devem ser numéricas")
+	assert.Contains(t, sheet, `<c r="R2" s="0"><v>-15.43488062</v></c>`, "Coordenadas devem ser numéricas")
+	assert.Contains(t, sheet, `<autoFilter ref="A1:W2"/>`)
+}
+
+// TestXLSXColumnName testa a conversão de índice em letra de coluna
+func TestXLSXColumnName(t *testing.T) {
+	assert.Equal(t, "A", xlsxColumnName(0))
+	assert.Equal(t, "W", xlsxColumnName(22))
+	assert.Equal(t, "Z", xlsxColumnName(25))
+	assert.Equal(t, "AA", xlsxColumnName(26))
+	assert.Equal(t, "AZ", xlsxColumnName(51))
+}
+
+func uploadRequest(t *testing.T, target, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.POST("/upload", uploadHandler)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "btc.xml")
+	part.Write([]byte(btcXML("1", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:30:00"))))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", target, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestUploadHandler_ConcurrentCSV testa que uploads simultâneos não compartilham o arquivo de saída
+func TestUploadHandler_ConcurrentCSV(t *testing.T) {
+	semCadastro(t, "1001")
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/upload", uploadHandler)
+
+	respostas := make([]*httptest.ResponseRecorder, 8)
+	var wg sync.WaitGroup
+	for i := range respostas {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "btc.xml")
+		part.Write([]byte(btcXML("1", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:30:00"))))
+		writer.Close()
+		req, _ := http.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			respostas[i] = httptest.NewRecorder()
+			router.ServeHTTP(respostas[i], req)
+		}()
+	}
+	wg.Wait()
+
+	for _, w := range respostas {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Len(t, strings.Split(strings.TrimSpace(w.Body.String()), "\n"), 2, "Cabeçalho e a viagem do próprio upload")
+	}
+	assert.Empty(t, saidasCSV(t))
+}
+
+// TestUploadHandler_ContentNegotiation testa a escolha do formato por ?format= e pelo cabeçalho Accept
+func TestUploadHandler_ContentNegotiation(t *testing.T) {
+	semCadastro(t, "1001")
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		name        string
+		target      string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"Padrão continua CSV", "/upload", "", http.StatusOK, "text/csv"},
+		{"Accept do axios continua CSV", "/upload", "application/json, text/plain, */*", http.StatusOK, "text/csv"},
+		{"Accept JSON", "/upload", "application/json", http.StatusOK, "application/json"},
+		{"Accept XLSX", "/upload", mimeXLSX, http.StatusOK, mimeXLSX},
+		{"Parâmetro tem prioridade", "/upload?format=xlsx", "application/json", http.StatusOK, mimeXLSX},
+		{"Formato desconhecido", "/upload?format=pdf", "", http.StatusBadRequest, "application/json"},
+		{"Accept sem formato suportado", "/upload", "application/pdf", http.StatusNotAcceptable, "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := uploadRequest(t, tt.target, tt.accept)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.Contains(t, w.Header().Get("Content-Type"), tt.contentType)
+		})
+	}
+
+	assert.Empty(t, saidasCSV(t), "Saída CSV é removida após a resposta")
+	w := uploadRequest(t, "/upload?format=json", "")
+	var resp struct {
+		Resumo  BatchResult              `json:"resumo"`
+		Viagens []map[string]interface{} `json:"viagens"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Resumo.Linhas)
+	require.Len(t, resp.Viagens, 1)
+	assert.Equal(t, "01:30:00", resp.Viagens[0]["tempo_viagem"])
+	assert.Empty(t, saidasCSV(t), "Saída JSON não deve gravar o CSV")
+}
@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"sync"
 	"testing"
 	"time"
 
@@ -28,65 +27,6 @@ func setupRouter() *gin.Engine {
 		MaxAge:           12 * time.Hour,
 	}))
 
-	router.GET("/health/db", func(c *gin.Context) {
-		db, err := getDBConnection()
-		if err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"status":  "error",
-				"message": "Não foi possível conectar ao banco de dados",
-				"error":   err.Error(),
-			})
-			return
-		}
-
-		var result int
-		err = db.QueryRow("SELECT 1").Scan(&result)
-		if err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"status":  "error",
-				"message": "Conexão estabelecida, mas query de teste falhou",
-				"error":   err.Error(),
-			})
-			return
-		}
-
-		var tableExists bool
-		err = db.QueryRow(`
-			SELECT EXISTS (
-				SELECT FROM information_schema.tables 
-				WHERE table_schema = 'public' 
-				AND table_name = 'pessoa'
-			)
-		`).Scan(&tableExists)
-
-		if err != nil {
-			c.JSON(http.StatusOK, gin.H{
-				"status":      "connected",
-				"message":     "Conexão OK, mas não foi possível verificar tabela pessoa",
-				"query_test":  "OK",
-				"table_check": "error",
-				"error":       err.Error(),
-			})
-			return
-		}
-
-		var count int
-		if tableExists {
-			err = db.QueryRow("SELECT COUNT(*) FROM pessoa").Scan(&count)
-			if err != nil {
-				count = -1
-			}
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"status":       "connected",
-			"message":      "Conexão com banco de dados OK",
-			"query_test":   "OK",
-			"table_exists": tableExists,
-			"pessoa_count": count,
-		})
-	})
-
 	router.POST("/upload", func(c *gin.Context) {
 		file, err := c.FormFile("file")
 		if err != nil {
@@ -119,20 +59,12 @@ func setupRouter() *gin.Engine {
 // TestUploadHandler_Success testa upload bem-sucedido
 func TestUploadHandler_Success(t *testing.T) {
 	// Limpar cache e mockar banco
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
-
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-	dbPool = nil
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
+	cpfCache.Clear()
+
+	originalDBConn := dbConn
+	dbConn = newConnManager(cfg.DB, openDatabase)
 	defer func() {
-		dbPool = originalDBPool
-		dbPoolOnce = originalDBPoolOnce
-		dbPoolInitErr = originalDBPoolInitErr
+		dbConn = originalDBConn
 	}()
 
 	router := setupRouter()
@@ -187,31 +119,3 @@ func TestUploadHandler_NoFile(t *testing.T) {
 
 	assert.Equal(t, http.StatusBadRequest, w.Code, "Status deve ser 400")
 }
-
-// TestHealthDBHandler_WithoutDB testa health check sem banco
-func TestHealthDBHandler_WithoutDB(t *testing.T) {
-	router := setupRouter()
-
-	// Salvar estado original
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-
-	// Limpar conexão
-	dbPool = nil
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
-
-	req, _ := http.NewRequest("GET", "/health/db", nil)
-	w := httptest.NewRecorder()
-
-	router.ServeHTTP(w, req)
-
-	// Pode retornar 503 ou 200 dependendo da implementação
-	assert.Contains(t, []int{http.StatusServiceUnavailable, http.StatusOK}, w.Code, "Status deve ser 503 ou 200")
-
-	// Restaurar estado original
-	dbPool = originalDBPool
-	dbPoolOnce = originalDBPoolOnce
-	dbPoolInitErr = originalDBPoolInitErr
-}
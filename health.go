@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Estados das verificações de saúde
+const (
+	healthOK        = "ok"
+	healthDegradado = "degradado"
+	healthFalha     = "falha"
+)
+
+// workspaceDir é onde os uploads gravam a saída (output-*.csv, removida após a resposta); precisa aceitar escrita
+var workspaceDir = "."
+
+// requiredTables são as tabelas de dados mestres sem as quais não há enriquecimento
+var requiredTables = []string{"pessoa", "parametro_viagem"}
+
+// HealthCheck é o resultado da verificação de uma dependência
+type HealthCheck struct {
+	Status     string      `json:"status"`
+	LatenciaMs float64     `json:"latencia_ms"`
+	Erro       string      `json:"erro,omitempty"`
+	Detalhes   interface{} `json:"detalhes,omitempty"`
+}
+
+// HealthReport é a resposta de /health e /health/ready
+type HealthReport struct {
+	Status       string                 `json:"status"`
+	Verificacoes map[string]HealthCheck `json:"verificacoes"`
+	Horario      time.Time              `json:"horario"`
+}
+
+// healthCheckFunc verifica uma dependência; detalhes vão para o relatório mesmo com erro
+type healthCheckFunc func(ctx context.Context) (detalhes interface{}, err error)
+
+// runHealthCheck executa a verificação medindo a latência. As sondas são públicas: a resposta
+// leva só a mensagem fixa falha, e o erro do driver, que pode trazer host e usuário, vai para o log.
+func runHealthCheck(ctx context.Context, nome, falha string, check healthCheckFunc) HealthCheck {
+	inicio := time.Now()
+	detalhes, err := check(ctx)
+	result := HealthCheck{
+		Status:     healthOK,
+		LatenciaMs: float64(time.Since(inicio).Microseconds()) / 1000,
+		Detalhes:   detalhes,
+	}
+	if err != nil {
+		result.Status = healthFalha
+		result.Erro = falha
+		slog.WarnContext(ctx, "verificação de saúde falhou", "verificacao", nome, "erro", err)
+	}
+	return result
+}
+
+// healthDB devolve o pool sem esperar pela conexão: a sonda não fica presa enquanto o banco está fora
+func healthDB(ctx context.Context) (*sql.DB, error) {
+	if status := dbConn.Status(); status.Estado != connPronto {
+		return nil, fmt.Errorf("conexão com o banco %s", status.Estado)
+	}
+	return getDBConnection(ctx)
+}
+
+// checkDatabase verifica se o pool está pronto e responde a um ping; o último erro de conexão fica de fora
+func checkDatabase(ctx context.Context) (interface{}, error) {
+	status := dbConn.Status()
+	status.Erro = ""
+	db, err := healthDB(ctx)
+	if err != nil {
+		return status, err
+	}
+	return status, db.PingContext(ctx)
+}
+
+// checkMigrations verifica se todas as migrações embutidas foram aplicadas.
+// Lê schema_migrations sem o advisory lock, para não esperar uma migração em andamento.
+func checkMigrations(ctx context.Context) (interface{}, error) {
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		return nil, err
+	}
+	db, err := healthDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	pendentes := []int{}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pendentes = append(pendentes, m.Version)
+		}
+	}
+	detalhes := gin.H{"aplicadas": len(applied), "pendentes": pendentes}
+	if len(pendentes) > 0 {
+		return detalhes, fmt.Errorf("%d migrações pendentes", len(pendentes))
+	}
+	return detalhes, nil
+}
+
+// checkTables verifica se as tabelas de dados mestres existem
+func checkTables(ctx context.Context) (interface{}, error) {
+	db, err := healthDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tabelas := make(map[string]bool, len(requiredTables))
+	var ausentes []string
+	for _, tabela := range requiredTables {
+		var existe bool
+		if err := db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", "public."+tabela).Scan(&existe); err != nil {
+			return tabelas, fmt.Errorf("erro ao verificar tabela %s: %w", tabela, err)
+		}
+		tabelas[tabela] = existe
+		if !existe {
+			ausentes = append(ausentes, tabela)
+		}
+	}
+	if len(ausentes) > 0 {
+		return tabelas, fmt.Errorf("tabelas ausentes: %v", ausentes)
+	}
+	return tabelas, nil
+}
+
+// checkWorkspace verifica se o diretório de trabalho aceita escrita
+func checkWorkspace(ctx context.Context) (interface{}, error) {
+	detalhes := gin.H{"diretorio": workspaceDir}
+	f, err := os.CreateTemp(workspaceDir, ".health-*")
+	if err != nil {
+		return detalhes, fmt.Errorf("diretório de trabalho sem permissão de escrita: %w", err)
+	}
+	f.Close()
+	return detalhes, os.Remove(f.Name())
+}
+
+// readinessChecks são as verificações exigidas para receber tráfego, na ordem em que são executadas
+var readinessChecks = []struct {
+	nome  string
+	falha string
+	check healthCheckFunc
+}{
+	{"banco", "banco indisponível", checkDatabase},
+	{"migracoes", "migrações pendentes", checkMigrations},
+	{"tabelas", "tabelas ausentes", checkTables},
+	{"workspace", "diretório de trabalho sem permissão de escrita", checkWorkspace},
+}
+
+// healthReport executa as verificações; o status geral é falha se alguma obrigatória falhar
+func healthReport(ctx context.Context, detalhado bool) HealthReport {
+	report := HealthReport{Status: healthOK, Verificacoes: map[string]HealthCheck{}, Horario: time.Now()}
+	for _, c := range readinessChecks {
+		checkCtx, cancel := statementContext(ctx)
+		result := runHealthCheck(checkCtx, c.nome, c.falha, c.check)
+		cancel()
+		report.Verificacoes[c.nome] = result
+		if result.Status != healthOK {
+			report.Status = healthFalha
+		}
+	}
+
+	if detalhado {
+		// O circuito aberto não tira a réplica do ar: as consultas voltam sozinhas após a espera
+		circuito := HealthCheck{Status: healthOK, Detalhes: gin.H{"estado": dbBreaker.State()}}
+		if dbBreaker.State() == "aberto" {
+			circuito.Status = healthDegradado
+			if report.Status == healthOK {
+				report.Status = healthDegradado
+			}
+		}
+		report.Verificacoes["circuito"] = circuito
+		report.Verificacoes["cache"] = HealthCheck{
+			Status:   healthOK,
+			Detalhes: gin.H{"cpf": cpfCache.Stats(), "linha": linhaCache.Stats()},
+		}
+	}
+	return report
+}
+
+// registerHealthRoutes registra as sondas públicas de saúde.
+// /health/live só indica que o processo responde (sem consultar dependências);
+// /health/ready indica se a réplica pode receber tráfego; /health é o relatório detalhado.
+func registerHealthRoutes(router *gin.Engine) {
+	router.GET("/health/live", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": healthOK})
+	})
+
+	router.GET("/health/ready", func(c *gin.Context) {
+		report := healthReport(c.Request.Context(), false)
+		c.JSON(healthStatusCode(report), report)
+	})
+
+	router.GET("/health", func(c *gin.Context) {
+		report := healthReport(c.Request.Context(), true)
+		c.JSON(healthStatusCode(report), report)
+	})
+}
+
+// healthStatusCode é 503 quando uma verificação obrigatória falhou
+func healthStatusCode(report HealthReport) int {
+	if report.Status == healthFalha {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// healthRequest executa GET em uma sonda de saúde com o diretório de trabalho em uma pasta temporária
+func healthRequest(t *testing.T, target string) (*httptest.ResponseRecorder, HealthReport) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	original := workspaceDir
+	workspaceDir = t.TempDir()
+	defer func() { workspaceDir = original }()
+
+	router := gin.New()
+	registerHealthRoutes(router)
+
+	req, _ := http.NewRequest("GET", target, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var report HealthReport
+	if target != "/health/live" {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report), w.Body.String())
+	}
+	return w, report
+}
+
+// expectReadyDatabase prepara as consultas de migrações e tabelas feitas pela sonda de readiness
+func expectReadyDatabase(mock sqlmock.Sqlmock, aplicadas int, tabelas ...bool) {
+	rows := sqlmock.NewRows([]string{"version", "aplicado_em"})
+	for v := 1; v <= aplicadas; v++ {
+		rows.AddRow(v, time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery("SELECT version, aplicado_em FROM schema_migrations").WillReturnRows(rows)
+	for i, tabela := range requiredTables {
+		mock.ExpectQuery("SELECT to_regclass").WithArgs("public." + tabela).
+			WillReturnRows(sqlmock.NewRows([]string{"existe"}).AddRow(tabelas[i]))
+	}
+}
+
+// TestHealthLive testa que a sonda de liveness não depende do banco
+func TestHealthLive(t *testing.T) {
+	semBanco(t)
+
+	w, _ := healthRequest(t, "/health/live")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+// TestHealthReady testa a readiness com o banco pronto, migrado e com as tabelas mestres
+func TestHealthReady(t *testing.T) {
+	mock := comBancoMock(t)
+	expectReadyDatabase(mock, 8, true, true)
+
+	w, report := healthRequest(t, "/health/ready")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, healthOK, report.Status)
+	assert.ElementsMatch(t, []string{"banco", "migracoes", "tabelas", "workspace"}, checkNames(report.Verificacoes))
+	for nome, check := range report.Verificacoes {
+		assert.Equal(t, healthOK, check.Status, nome)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestHealthReady_WithoutDB testa a resposta 503 sem esperar pela conexão com o banco
+func TestHealthReady_WithoutDB(t *testing.T) {
+	semBanco(t)
+
+	inicio := time.Now()
+	w, report := healthRequest(t, "/health/ready")
+	assert.Less(t, time.Since(inicio), time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, healthFalha, report.Status)
+	assert.Equal(t, healthFalha, report.Verificacoes["banco"].Status)
+	assert.Equal(t, "banco indisponível", report.Verificacoes["banco"].Erro)
+	assert.NotContains(t, w.Body.String(), "desabilitado no teste", "Erro da conexão fica no log")
+	assert.Equal(t, healthFalha, report.Verificacoes["tabelas"].Status)
+	assert.Equal(t, healthOK, report.Verificacoes["workspace"].Status)
+}
+
+// TestHealthReport testa o relatório detalhado com migração pendente e tabela ausente
+func TestHealthReport(t *testing.T) {
+	mock := comBancoMock(t)
+	expectReadyDatabase(mock, 7, true, false)
+
+	w, report := healthRequest(t, "/health")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, healthFalha, report.Status)
+
+	migracoes := report.Verificacoes["migracoes"]
+	assert.Equal(t, healthFalha, migracoes.Status)
+	assert.Equal(t, "migrações pendentes", migracoes.Erro)
+	assert.Equal(t, map[string]interface{}{"aplicadas": 7.0, "pendentes": []interface{}{8.0}}, migracoes.Detalhes)
+
+	tabelas := report.Verificacoes["tabelas"]
+	assert.Equal(t, "tabelas ausentes", tabelas.Erro)
+	assert.Equal(t, map[string]interface{}{"pessoa": true, "parametro_viagem": false}, tabelas.Detalhes)
+
+	assert.Equal(t, healthOK, report.Verificacoes["circuito"].Status)
+	assert.Contains(t, report.Verificacoes, "cache")
+	assert.GreaterOrEqual(t, report.Verificacoes["banco"].LatenciaMs, 0.0)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// checkNames lista os nomes das verificações do relatório
+func checkNames(m map[string]HealthCheck) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
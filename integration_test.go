@@ -1,10 +1,10 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"os"
 	"strconv"
-	"sync"
 	"testing"
 
 	"github.com/joho/godotenv"
@@ -25,21 +25,16 @@ func TestIntegration_GetCPFByCodIdentificador(t *testing.T) {
 	}
 
 	// Resetar o pool de conexões para forçar nova inicialização
-	originalDBPool := dbPool
-	originalDBPoolInitErr := dbPoolInitErr
+	originalDBConn := dbConn
 
-	// Resetar variáveis globais
-	dbPool = nil
-	dbPoolOnce = sync.Once{} // nolint:staticcheck // Reset necessário para teste
-	dbPoolInitErr = nil
+	// Resetar a conexão global
+	dbConn = newConnManager(cfg.DB, openDatabase)
 
 	// Limpar cache
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
+	cpfCache.Clear()
 
 	// Testar conexão
-	db, err := getDBConnection()
+	db, err := getDBConnection(context.Background())
 	require.NoError(t, err, "Deve conseguir conectar ao banco de dados")
 	require.NotNil(t, db, "Conexão não deve ser nil")
 
@@ -65,7 +60,7 @@ func TestIntegration_GetCPFByCodIdentificador(t *testing.T) {
 	t.Logf("Testando com cod_identificador: %s, CPF esperado: %s", codIdentificadorTest, cpfEsperado)
 
 	// Testar a função getCPFByCodIdentificador
-	result, err := getCPFByCodIdentificador(codIdentificadorTest)
+	result, err := getCPFByCodIdentificador(context.Background(), codIdentificadorTest)
 
 	// Verificar resultados
 	assert.NoError(t, err, "Não deve retornar erro")
@@ -73,14 +68,12 @@ func TestIntegration_GetCPFByCodIdentificador(t *testing.T) {
 	assert.Equal(t, cpfEsperado, result, "CPF deve corresponder ao esperado")
 
 	// Testar cache - segunda chamada deve usar cache
-	result2, err2 := getCPFByCodIdentificador(codIdentificadorTest)
+	result2, err2 := getCPFByCodIdentificador(context.Background(), codIdentificadorTest)
 	assert.NoError(t, err2)
 	assert.Equal(t, result, result2, "Segunda chamada deve retornar o mesmo valor (cache)")
 
 	// Restaurar variáveis globais
-	dbPool = originalDBPool
-	dbPoolOnce = sync.Once{} // nolint:staticcheck // Reset necessário para teste
-	dbPoolInitErr = originalDBPoolInitErr
+	dbConn = originalDBConn
 }
 
 // TestIntegration_DatabaseConnection testa a conexão com o banco
@@ -93,14 +86,11 @@ func TestIntegration_DatabaseConnection(t *testing.T) {
 	}
 
 	// Resetar pool
-	originalDBPool := dbPool
-	originalDBPoolInitErr := dbPoolInitErr
+	originalDBConn := dbConn
 
-	dbPool = nil
-	dbPoolOnce = sync.Once{} // nolint:staticcheck // Reset necessário para teste
-	dbPoolInitErr = nil
+	dbConn = newConnManager(cfg.DB, openDatabase)
 
-	db, err := getDBConnection()
+	db, err := getDBConnection(context.Background())
 	require.NoError(t, err)
 	require.NotNil(t, db)
 
@@ -139,9 +129,7 @@ func TestIntegration_DatabaseConnection(t *testing.T) {
 	t.Logf("Registros com CPF preenchido: %d", recordsWithCPF)
 
 	// Restaurar
-	dbPool = originalDBPool
-	dbPoolOnce = sync.Once{} // nolint:staticcheck // Reset necessário para teste
-	dbPoolInitErr = originalDBPoolInitErr
+	dbConn = originalDBConn
 }
 
 // TestIntegration_QueryCPFDirect testa query direta de CPF
@@ -154,14 +142,11 @@ func TestIntegration_QueryCPFDirect(t *testing.T) {
 	}
 
 	// Resetar pool
-	originalDBPool := dbPool
-	originalDBPoolInitErr := dbPoolInitErr
+	originalDBConn := dbConn
 
-	dbPool = nil
-	dbPoolOnce = sync.Once{} // nolint:staticcheck // Reset necessário para teste
-	dbPoolInitErr = nil
+	dbConn = newConnManager(cfg.DB, openDatabase)
 
-	db, err := getDBConnection()
+	db, err := getDBConnection(context.Background())
 	require.NoError(t, err)
 
 	// Buscar alguns registros com CPF
@@ -204,9 +189,7 @@ func TestIntegration_QueryCPFDirect(t *testing.T) {
 	}
 
 	// Limpar cache
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
+	cpfCache.Clear()
 
 	// Testar cada caso
 	for _, tc := range testCases {
@@ -215,7 +198,7 @@ func TestIntegration_QueryCPFDirect(t *testing.T) {
 		expectedCPF := tc.cpf
 		
 		t.Run("cod_"+codStr, func(t *testing.T) {
-			result, err := getCPFByCodIdentificador(codStr)
+			result, err := getCPFByCodIdentificador(context.Background(), codStr)
 			assert.NoError(t, err)
 			assert.Equal(t, expectedCPF, result, 
 				"CPF para cod_identificador %s deve ser %s", codStr, expectedCPF)
@@ -223,8 +206,6 @@ func TestIntegration_QueryCPFDirect(t *testing.T) {
 	}
 
 	// Restaurar
-	dbPool = originalDBPool
-	dbPoolOnce = sync.Once{} // nolint:staticcheck // Reset necessário para teste
-	dbPoolInitErr = originalDBPoolInitErr
+	dbConn = originalDBConn
 }
 
@@ -0,0 +1,785 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Estados de um job na tabela job
+const (
+	jobPendente   = "pendente"
+	jobExecutando = "executando"
+	jobConcluido  = "concluido"
+	jobFalhou     = "falhou"
+)
+
+// jobPurgeInterval é o intervalo entre as remoções de jobs terminados há mais de jobs.retention
+const jobPurgeInterval = time.Hour
+
+var (
+	errJobNaoEncontrado = errors.New("job não encontrado")
+	// errJobPerdido indica que a reserva venceu e o job passou para outro worker
+	errJobPerdido = errors.New("reserva do job perdida para outro worker")
+)
+
+// jobOpcoes são as opções do upload guardadas com o job (query string de POST /jobs)
+type jobOpcoes struct {
+	Layout    string `json:"layout"`
+	Forcar    bool   `json:"forcar,omitempty"`
+	Degradado string `json:"banco_indisponivel"`
+}
+
+// Job é a situação de um job, sem os arquivos e o resultado
+type Job struct {
+	ID            int64           `json:"id"`
+	Estado        string          `json:"estado"`
+	Formato       string          `json:"formato"`
+	Opcoes        jobOpcoes       `json:"opcoes"`
+	CriadoPor     string          `json:"criado_por"`
+	Tentativas    int             `json:"tentativas"`
+	MaxTentativas int             `json:"max_tentativas"`
+	ExecutarEm    time.Time       `json:"executar_em"`
+	Erro          string          `json:"erro,omitempty"`
+	Resumo        json.RawMessage `json:"resumo,omitempty"`
+	// Progresso é o andamento da execução atual (JobProgresso), gravado pelo worker
+	Progresso   json.RawMessage `json:"progresso,omitempty"`
+	CriadoEm    time.Time       `json:"criado_em"`
+	IniciadoEm  *time.Time      `json:"iniciado_em,omitempty"`
+	ConcluidoEm *time.Time      `json:"concluido_em,omitempty"`
+
+	// requestID é o X-Request-ID do upload, repetido nos logs do worker
+	requestID string
+}
+
+// enqueueJob grava o job, os arquivos e o webhook do upload (opcional) em uma transação e devolve o identificador
+func enqueueJob(ctx context.Context, db *sql.DB, files []BatchFile, formato string, opcoes jobOpcoes, criadoPor string, hook *jobWebhook) (int64, error) {
+	opcoesJSON, err := json.Marshal(opcoes)
+	if err != nil {
+		return 0, err
+	}
+
+	// Sem statement_timeout: gravar arquivos grandes pode levar mais que uma consulta comum
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO job (formato, opcoes, criado_por, request_id, max_tentativas)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, formato, opcoesJSON, criadoPor, requestIDFrom(ctx), cfg.Jobs.MaxAttempts).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao criar job: %w", err)
+	}
+
+	for i, f := range files {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO job_arquivo (job_id, ordem, nome, conteudo) VALUES ($1, $2, $3, $4)
+		`, id, i, f.Nome, f.Conteudo)
+		if err != nil {
+			return 0, fmt.Errorf("erro ao gravar arquivo %s do job: %w", f.Nome, err)
+		}
+	}
+	if hook != nil {
+		if _, err := insertWebhook(ctx, tx, hook.URL, hook.Segredo, criadoPor, &id); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("erro ao confirmar job: %w", err)
+	}
+	return id, nil
+}
+
+// getJob devolve a situação de um job; errJobNaoEncontrado se ele não existe (ou já foi removido)
+func getJob(ctx context.Context, db *sql.DB, id int64) (*Job, error) {
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+
+	var job Job
+	var opcoes []byte
+	var erro, requestID sql.NullString
+	var resumo, progresso []byte
+	var iniciadoEm, concluidoEm sql.NullTime
+	err := db.QueryRowContext(ctx, `
+		SELECT id, estado, formato, opcoes, criado_por, request_id, tentativas, max_tentativas,
+		       executar_em, erro, resumo, progresso, criado_em, iniciado_em, concluido_em
+		FROM job WHERE id = $1
+	`, id).Scan(&job.ID, &job.Estado, &job.Formato, &opcoes, &job.CriadoPor, &requestID, &job.Tentativas,
+		&job.MaxTentativas, &job.ExecutarEm, &erro, &resumo, &progresso, &job.CriadoEm, &iniciadoEm, &concluidoEm)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errJobNaoEncontrado
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar job: %w", err)
+	}
+
+	if err := json.Unmarshal(opcoes, &job.Opcoes); err != nil {
+		return nil, fmt.Errorf("opções inválidas no job %d: %w", id, err)
+	}
+	job.Erro, job.requestID = erro.String, requestID.String
+	if len(resumo) > 0 {
+		job.Resumo = resumo
+	}
+	if len(progresso) > 0 {
+		job.Progresso = progresso
+	}
+	if iniciadoEm.Valid {
+		job.IniciadoEm = &iniciadoEm.Time
+	}
+	if concluidoEm.Valid {
+		job.ConcluidoEm = &concluidoEm.Time
+	}
+	return &job, nil
+}
+
+// jobResult devolve a saída gerada por um job concluído
+func jobResult(ctx context.Context, db *sql.DB, id int64) ([]byte, error) {
+	// Sem statement_timeout: a saída de um lote grande pode levar mais que uma consulta comum
+	var resultado []byte
+	err := db.QueryRowContext(ctx, "SELECT resultado FROM job WHERE id = $1 AND estado = $2", id, jobConcluido).Scan(&resultado)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errJobNaoEncontrado
+	}
+	return resultado, err
+}
+
+// claimJob reserva o próximo job pendente (ou cuja reserva venceu) para o worker; nil se a fila está vazia.
+// SKIP LOCKED faz workers simultâneos pegarem jobs diferentes sem esperar uns pelos outros.
+func claimJob(ctx context.Context, db *sql.DB, worker string, lease time.Duration) (*Job, error) {
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+
+	var job Job
+	var opcoes []byte
+	var requestID sql.NullString
+	err := db.QueryRowContext(ctx, `
+		UPDATE job SET estado = 'executando', tentativas = tentativas + 1, worker = $1, progresso = NULL,
+		       iniciado_em = now(), bloqueado_ate = now() + $2 * interval '1 millisecond'
+		WHERE id = (
+			SELECT id FROM job
+			WHERE (estado = 'pendente' AND executar_em <= now())
+			   OR (estado = 'executando' AND bloqueado_ate < now())
+			ORDER BY executar_em, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, formato, opcoes, criado_por, request_id, tentativas, max_tentativas
+	`, worker, lease.Milliseconds()).Scan(&job.ID, &job.Formato, &opcoes, &job.CriadoPor, &requestID, &job.Tentativas, &job.MaxTentativas)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao reservar job: %w", err)
+	}
+	if err := json.Unmarshal(opcoes, &job.Opcoes); err != nil {
+		return nil, fmt.Errorf("opções inválidas no job %d: %w", job.ID, err)
+	}
+	job.Estado, job.requestID = jobExecutando, requestID.String
+	return &job, nil
+}
+
+// jobFiles lê os arquivos do job na ordem do envio
+func jobFiles(ctx context.Context, db *sql.DB, id int64) ([]BatchFile, error) {
+	rows, err := db.QueryContext(ctx, "SELECT nome, conteudo FROM job_arquivo WHERE job_id = $1 ORDER BY ordem", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []BatchFile
+	for rows.Next() {
+		var f BatchFile
+		if err := rows.Scan(&f.Nome, &f.Conteudo); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// updateOwnedJob altera um job em execução apenas se a reserva ainda é deste worker
+func updateOwnedJob(ctx context.Context, db *sql.DB, query string, id int64, worker string, args ...interface{}) error {
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, query, append([]interface{}{id, worker}, args...)...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errJobPerdido
+	}
+	return nil
+}
+
+func extendLease(ctx context.Context, db *sql.DB, id int64, worker string, lease time.Duration) error {
+	return updateOwnedJob(ctx, db, `
+		UPDATE job SET bloqueado_ate = now() + $3 * interval '1 millisecond'
+		WHERE id = $1 AND worker = $2 AND estado = 'executando'
+	`, id, worker, lease.Milliseconds())
+}
+
+// completeJob grava o resultado e, na mesma transação, registra o lote no histórico de uploads:
+// enquanto o resultado não foi gravado, uma nova tentativa não encontra o próprio lote como repetido.
+// Como no /upload, uma falha no registro (desfeita até o SAVEPOINT) não impede a conclusão.
+func completeJob(ctx context.Context, db *sql.DB, id int64, worker string, resumo, resultado []byte, registro *uploadRegistro) error {
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE job SET estado = 'concluido', resumo = $3, resultado = $4, erro = NULL,
+		       concluido_em = now(), bloqueado_ate = NULL
+		WHERE id = $1 AND worker = $2 AND estado = 'executando'
+	`, id, worker, resumo, resultado)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errJobPerdido
+	}
+
+	if registro != nil {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT registro_upload"); err != nil {
+			return err
+		}
+		if err := registro.save(ctx, tx); err != nil {
+			slog.WarnContext(ctx, "não foi possível registrar o lote no histórico de uploads", "job", id, "erro", err)
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT registro_upload"); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// retryJob devolve o job à fila para uma nova tentativa após espera
+func retryJob(ctx context.Context, db *sql.DB, id int64, worker, erro string, espera time.Duration) error {
+	return updateOwnedJob(ctx, db, `
+		UPDATE job SET estado = 'pendente', erro = $3, executar_em = now() + $4 * interval '1 millisecond',
+		       worker = NULL, bloqueado_ate = NULL
+		WHERE id = $1 AND worker = $2 AND estado = 'executando'
+	`, id, worker, erro, espera.Milliseconds())
+}
+
+func failJob(ctx context.Context, db *sql.DB, id int64, worker, erro string, resumo []byte) error {
+	return updateOwnedJob(ctx, db, `
+		UPDATE job SET estado = 'falhou', erro = $3, resumo = $4, concluido_em = now(), bloqueado_ate = NULL
+		WHERE id = $1 AND worker = $2 AND estado = 'executando'
+	`, id, worker, erro, resumo)
+}
+
+// releaseJob devolve o job interrompido pelo encerramento do worker, sem contar a tentativa
+func releaseJob(ctx context.Context, db *sql.DB, id int64, worker string) error {
+	return updateOwnedJob(ctx, db, `
+		UPDATE job SET estado = 'pendente', tentativas = tentativas - 1, executar_em = now(),
+		       worker = NULL, bloqueado_ate = NULL
+		WHERE id = $1 AND worker = $2 AND estado = 'executando'
+	`, id, worker)
+}
+
+// purgeJobs remove jobs terminados há mais de retention, com arquivos e resultado
+func purgeJobs(ctx context.Context, db *sql.DB, retention time.Duration) (int64, error) {
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `
+		DELETE FROM job
+		WHERE estado IN ('concluido', 'falhou') AND concluido_em < now() - $1 * interval '1 millisecond'
+	`, retention.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// jobBackoff é a espera antes da tentativa seguinte do job
+func jobBackoff(c JobsConfig, tentativas int) time.Duration {
+	return retryBackoff(c.RetryMin.Duration, c.RetryMax.Duration, tentativas)
+}
+
+// retryBackoff é a espera após a falha de número tentativas, dobrando a partir de minimo até maximo
+func retryBackoff(minimo, maximo time.Duration, tentativas int) time.Duration {
+	d := minimo
+	for i := 1; i < tentativas && d < maximo; i++ {
+		d *= 2
+	}
+	if d > maximo {
+		d = maximo
+	}
+	return d
+}
+
+// jobErroTemporario indica falhas que podem passar com uma nova tentativa (banco fora do ar, tempo esgotado);
+// erros no conteúdo, como XML inválido ou upload repetido, falham o job de vez
+func jobErroTemporario(err error) bool {
+	return errors.Is(err, ErrBancoIndisponivel) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// runJob processa os arquivos do job como o /upload e devolve a saída no formato pedido;
+// o registro no histórico de uploads fica para completeJob
+func runJob(ctx context.Context, db *sql.DB, job *Job) ([]byte, *BatchResult, error) {
+	files, err := jobFiles(ctx, db, job.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: erro ao ler arquivos do job: %v", ErrBancoIndisponivel, err)
+	}
+	layout, err := findLayout(job.Opcoes.Layout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params := uploadParams{Format: job.Formato, Layout: layout, Forcar: job.Opcoes.Forcar, Degradado: job.Opcoes.Degradado}
+	opts := params.batchOptions()
+	opts.DeferRegister = true
+	result, err := ProcessBatchContext(ctx, files, "", opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeBatchOutput(ctx, &buf, job.Formato, layout, result); err != nil {
+		return nil, result, err
+	}
+	return buf.Bytes(), result, nil
+}
+
+// jobWorker executa os jobs da fila; vários processos "worker" podem rodar ao mesmo tempo
+type jobWorker struct {
+	nome     string
+	cfg      JobsConfig
+	webhooks WebhooksConfig
+}
+
+// newJobWorker identifica o worker pelo host e pelo processo, registrado em job.worker
+func newJobWorker(c JobsConfig, webhooks WebhooksConfig) *jobWorker {
+	host, _ := os.Hostname()
+	return &jobWorker{nome: fmt.Sprintf("%s-%d", host, os.Getpid()), cfg: c, webhooks: webhooks}
+}
+
+// run executa até concurrency jobs em paralelo, e as notificações dos webhooks, até ctx terminar;
+// jobs interrompidos voltam à fila
+func (w *jobWorker) run(ctx context.Context, concurrency int) {
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		w.purgeLoop(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		w.deliveryLoop(ctx)
+	}()
+	wg.Wait()
+}
+
+func (w *jobWorker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		executou, err := w.runNext(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "erro ao buscar job na fila", "worker", w.nome, "erro", err)
+		}
+		if executou {
+			continue // pode haver mais jobs esperando
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.cfg.PollInterval.Duration):
+		}
+	}
+}
+
+// deliveryLoop envia as notificações pendentes, uma de cada vez
+func (w *jobWorker) deliveryLoop(ctx context.Context) {
+	client := newWebhookClient(w.webhooks)
+	for ctx.Err() == nil {
+		entregou := false
+		db, err := getDBConnection(ctx)
+		if err == nil {
+			entregou, err = deliverNextWebhook(ctx, db, client, w.webhooks)
+		}
+		if err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "erro ao enviar notificações de webhook", "worker", w.nome, "erro", err)
+		}
+		if entregou {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.cfg.PollInterval.Duration):
+		}
+	}
+}
+
+func (w *jobWorker) purgeLoop(ctx context.Context) {
+	for {
+		if db, err := getDBConnection(ctx); err == nil {
+			if n, err := purgeJobs(ctx, db, w.cfg.Retention.Duration); err != nil && ctx.Err() == nil {
+				slog.WarnContext(ctx, "erro ao remover jobs antigos", "erro", err)
+			} else if n > 0 {
+				slog.InfoContext(ctx, "jobs antigos removidos", "jobs", n)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jobPurgeInterval):
+		}
+	}
+}
+
+// runNext reserva e executa um job; executou é falso quando a fila está vazia
+func (w *jobWorker) runNext(ctx context.Context) (executou bool, err error) {
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		return false, err
+	}
+	job, err := claimJob(ctx, db, w.nome, w.cfg.Lease.Duration)
+	if err != nil || job == nil {
+		return false, err
+	}
+	w.execute(ctx, db, job)
+	return true, nil
+}
+
+// execute processa o job renovando a reserva e registra o resultado: concluído, nova tentativa,
+// falha definitiva ou, se o worker está encerrando, de volta à fila
+func (w *jobWorker) execute(ctx context.Context, db *sql.DB, job *Job) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if job.requestID != "" {
+		jobCtx = withRequestID(jobCtx, job.requestID)
+	}
+	jobCtx, span := tracer().Start(jobCtx, "job", trace.WithAttributes(
+		attribute.Int64("btc.job.id", job.ID),
+		attribute.Int("btc.job.tentativa", job.Tentativas),
+		attribute.String("btc.formato", job.Formato),
+	))
+	defer span.End()
+	log := slog.With("job", job.ID, "tentativa", job.Tentativas, "worker", w.nome)
+
+	// O contexto de encerramento não pode impedir o registro do resultado
+	finishCtx := context.WithoutCancel(jobCtx)
+
+	if job.Tentativas > job.MaxTentativas {
+		// Reserva vencida repetidamente (o worker caiu durante o job): não insistir
+		msg := fmt.Sprintf("job interrompido em %d tentativas", job.MaxTentativas)
+		if err := failJob(finishCtx, db, job.ID, w.nome, msg, nil); err != nil {
+			log.ErrorContext(jobCtx, "erro ao registrar falha do job", "erro", err)
+			return
+		}
+		w.notify(finishCtx, db, job, jobFalhou, nil, errors.New(msg))
+		return
+	}
+
+	perdido := make(chan struct{})
+	pararRenovacao := w.renewLease(jobCtx, db, job.ID, cancel, perdido)
+	jobCtx, progresso := withBatchProgress(jobCtx)
+	pararProgresso := w.reportProgress(jobCtx, db, job.ID, progresso)
+	resultado, result, err := runJob(jobCtx, db, job)
+	pararProgresso()
+	pararRenovacao()
+
+	select {
+	case <-perdido:
+		log.WarnContext(jobCtx, "job abandonado: reserva vencida e retomada por outro worker")
+		return
+	default:
+	}
+
+	var resumo []byte
+	if result != nil {
+		resumo, _ = json.Marshal(result)
+	}
+	var dupErr *DuplicateUploadError
+	if errors.As(err, &dupErr) {
+		resumo, _ = json.Marshal(gin.H{"duplicados": dupErr.Report})
+	}
+
+	var finishErr error
+	estado := ""
+	switch {
+	case err == nil:
+		finishErr, estado = completeJob(finishCtx, db, job.ID, w.nome, resumo, resultado, result.registro), jobConcluido
+		log.InfoContext(jobCtx, "job concluído", "linhas", result.Linhas)
+	case ctx.Err() != nil:
+		finishErr = releaseJob(finishCtx, db, job.ID, w.nome)
+		log.InfoContext(jobCtx, "job devolvido à fila no encerramento do worker")
+	case jobErroTemporario(err) && job.Tentativas < job.MaxTentativas:
+		espera := jobBackoff(w.cfg, job.Tentativas)
+		finishErr = retryJob(finishCtx, db, job.ID, w.nome, err.Error(), espera)
+		log.WarnContext(jobCtx, "falha temporária no job; nova tentativa agendada", "espera", espera.String(), "erro", err)
+	default:
+		finishErr, estado = failJob(finishCtx, db, job.ID, w.nome, err.Error(), resumo), jobFalhou
+		log.WarnContext(jobCtx, "job falhou", "erro", err)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if finishErr != nil {
+		log.ErrorContext(jobCtx, "erro ao registrar o resultado do job", "erro", finishErr)
+		return
+	}
+	if estado != "" {
+		w.notify(finishCtx, db, job, estado, result, err)
+	}
+}
+
+// notify registra as notificações do job terminado, enviadas depois por deliveryLoop
+func (w *jobWorker) notify(ctx context.Context, db *sql.DB, job *Job, estado string, result *BatchResult, erro error) {
+	n, err := enqueueWebhookDeliveries(ctx, db, job, newWebhookNotificacao(job, estado, result, erro))
+	if err != nil {
+		slog.ErrorContext(ctx, "erro ao registrar notificações do job", "job", job.ID, "erro", err)
+		return
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "notificações do job registradas", "job", job.ID, "webhooks", n)
+	}
+}
+
+// renewLease renova a reserva a cada terço de jobs.lease; se ela foi perdida, fecha perdido e
+// cancela o job. A função devolvida interrompe a renovação.
+func (w *jobWorker) renewLease(ctx context.Context, db *sql.DB, id int64, cancel context.CancelFunc, perdido chan struct{}) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(w.cfg.Lease.Duration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := extendLease(ctx, db, id, w.nome, w.cfg.Lease.Duration)
+				if errors.Is(err, errJobPerdido) {
+					close(perdido)
+					cancel()
+					return
+				}
+				if err != nil && ctx.Err() == nil {
+					slog.WarnContext(ctx, "erro ao renovar a reserva do job", "job", id, "erro", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// runWorkerCommand executa o subcomando "worker" até SIGINT/SIGTERM
+func runWorkerCommand(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	concurrency := fs.Int("concurrency", 0, "jobs executados em paralelo (padrão: jobs.concurrency)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *concurrency < 0 || fs.NArg() > 0 {
+		return fmt.Errorf("uso: worker [--concurrency n]")
+	}
+
+	shutdown, err := startRuntime(true)
+	if err != nil {
+		return err
+	}
+	defer shutdown(context.Background())
+	if *concurrency == 0 {
+		*concurrency = cfg.Jobs.Concurrency
+	}
+
+	configureCaches(cfg.Cache)
+	dbBreaker = newCircuitBreaker(cfg.DB)
+	dbConn.Start()
+	if cfg.Cache.Notify {
+		if _, err := startCacheListener(connectionString(cfg.DatabaseURL)); err != nil {
+			slog.Warn("invalidação de cache entre réplicas indisponível", "canal", cacheNotifyChannel, "erro", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	w := newJobWorker(cfg.Jobs, cfg.Webhooks)
+	slog.Info("worker iniciado", "worker", w.nome, "paralelo", *concurrency)
+	w.run(ctx, *concurrency)
+	slog.Info("worker encerrado", "worker", w.nome)
+	return nil
+}
+
+// principalFrom devolve a credencial autenticada da requisição
+func principalFrom(c *gin.Context) *Principal {
+	principal, _ := c.Get(ctxPrincipal)
+	p, _ := principal.(*Principal)
+	return p
+}
+
+// createJobHandler enfileira o upload (mesmos arquivos e parâmetros de /upload) e responde 202;
+// ?webhook= cadastra uma URL notificada apenas quando este job terminar
+func createJobHandler(c *gin.Context) {
+	params, ok := parseUploadParams(c)
+	if !ok {
+		return
+	}
+	var hook *jobWebhook
+	if endereco := c.Query("webhook"); endereco != "" {
+		if err := validateWebhookURL(c.Request.Context(), endereco); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		segredo, err := generateWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao gerar segredo"})
+			return
+		}
+		hook = &jobWebhook{URL: endereco, Segredo: segredo}
+	}
+	files, ok := collectUploadFiles(c)
+	if !ok {
+		return
+	}
+	// Limites de estrutura conferidos já no envio: o job falharia de qualquer forma
+	if err := checkBatchLimits(files); err != nil {
+		respondUploadLimit(c, err)
+		return
+	}
+
+	db, err := getDBConnection(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Fila indisponível: banco de dados fora do ar"})
+		return
+	}
+
+	opcoes := jobOpcoes{Layout: params.Layout.Nome, Forcar: params.Forcar, Degradado: params.Degradado}
+	id, err := enqueueJob(c.Request.Context(), db, files, params.Format, opcoes, principalFrom(c).Nome, hook)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "erro ao enfileirar upload", "erro", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao enfileirar upload"})
+		return
+	}
+
+	url := fmt.Sprintf("/jobs/%d", id)
+	resposta := gin.H{
+		"status":    jobPendente,
+		"message":   "Upload enfileirado",
+		"id":        id,
+		"url":       url,
+		"resultado": url + "/resultado",
+	}
+	if hook != nil {
+		// O segredo só aparece nesta resposta
+		resposta["webhook"] = gin.H{"url": hook.URL, "segredo": hook.Segredo}
+	}
+	c.Header("Location", url)
+	c.JSON(http.StatusAccepted, resposta)
+}
+
+// loadJobFor lê o job de :id se ele pertence à credencial (ou ela é admin); em caso de erro a resposta já foi escrita
+func loadJobFor(c *gin.Context) (*Job, *sql.DB, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Identificador de job inválido"})
+		return nil, nil, false
+	}
+	db, err := getDBConnection(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Fila indisponível: banco de dados fora do ar"})
+		return nil, nil, false
+	}
+
+	job, err := getJob(c.Request.Context(), db, id)
+	// Jobs de outras credenciais aparecem como inexistentes: os resultados trazem CPFs
+	if errors.Is(err, errJobNaoEncontrado) || (err == nil && job.CriadoPor != principalFrom(c).Nome && !principalFrom(c).Has(roleAdmin)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": errJobNaoEncontrado.Error()})
+		return nil, nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "erro ao consultar job", "job", id, "erro", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao consultar job"})
+		return nil, nil, false
+	}
+	return job, db, true
+}
+
+// getJobHandler devolve a situação do job
+func getJobHandler(c *gin.Context) {
+	job, _, ok := loadJobFor(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// jobResultHandler devolve a saída do job concluído com os mesmos cabeçalhos de /upload
+func jobResultHandler(c *gin.Context) {
+	job, db, ok := loadJobFor(c)
+	if !ok {
+		return
+	}
+	if job.Estado != jobConcluido {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job não concluído", "estado": job.Estado, "erro": job.Erro})
+		return
+	}
+
+	resultado, err := jobResult(c.Request.Context(), db, job.ID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "erro ao ler resultado do job", "job", job.ID, "erro", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao ler resultado do job"})
+		return
+	}
+
+	var resumo BatchResult
+	if json.Unmarshal(job.Resumo, &resumo) == nil {
+		c.Header("X-Upload-Summary", headerJSON(resumo))
+		if resumo.Incompleto {
+			c.Header("X-Export-Incompleto", "true")
+		}
+	}
+
+	contentType := map[string]string{
+		formatCSV:  "text/csv; charset=utf-8",
+		formatJSON: mimeJSON + "; charset=utf-8",
+		formatXLSX: mimeXLSX,
+	}[job.Formato]
+	if job.Formato != formatJSON {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=job-%d.%s", job.ID, job.Formato))
+	}
+	c.Data(http.StatusOK, contentType, resultado)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var colunasJob = []string{"id", "estado", "formato", "opcoes", "criado_por", "request_id", "tentativas", "max_tentativas",
+	"executar_em", "erro", "resumo", "progresso", "criado_em", "iniciado_em", "concluido_em"}
+
+func workerDeTeste() *jobWorker {
+	return &jobWorker{nome: "teste-1", cfg: JobsConfig{
+		Lease:       Duration{time.Minute},
+		MaxAttempts: 3,
+		RetryMin:    Duration{10 * time.Second},
+		RetryMax:    Duration{time.Minute},
+	}}
+}
+
+// TestJobBackoff testa a espera dobrando a cada tentativa até o limite
+func TestJobBackoff(t *testing.T) {
+	c := JobsConfig{RetryMin: Duration{10 * time.Second}, RetryMax: Duration{time.Minute}}
+	assert.Equal(t, 10*time.Second, jobBackoff(c, 1))
+	assert.Equal(t, 20*time.Second, jobBackoff(c, 2))
+	assert.Equal(t, 40*time.Second, jobBackoff(c, 3))
+	assert.Equal(t, time.Minute, jobBackoff(c, 4))
+	assert.Equal(t, time.Minute, jobBackoff(c, 40))
+}
+
+// TestEnqueueJob testa a gravação do job e dos arquivos na mesma transação
+func TestEnqueueJob(t *testing.T) {
+	mock := comBancoMock(t)
+	comConfig(t, func(c *Config) {})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO job ").
+		WithArgs(formatCSV, []byte(`{"layout":"antt","banco_indisponivel":"falhar"}`), "integracao", "req-1", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectExec("INSERT INTO job_arquivo").WithArgs(42, 0, "a.xml", []byte("<a/>")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO job_arquivo").WithArgs(42, 1, "b.xml", []byte("<b/>")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	db, err := getDBConnection(context.Background())
+	require.NoError(t, err)
+	files := []BatchFile{{Nome: "a.xml", Conteudo: []byte("<a/>")}, {Nome: "b.xml", Conteudo: []byte("<b/>")}}
+	id, err := enqueueJob(withRequestID(context.Background(), "req-1"), db, files, formatCSV,
+		jobOpcoes{Layout: "antt", Degradado: degradadoFalhar}, "integracao", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestClaimJob testa a reserva com SKIP LOCKED e a fila vazia
+func TestClaimJob(t *testing.T) {
+	mock := comBancoMock(t)
+	db, err := getDBConnection(context.Background())
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`FOR UPDATE SKIP LOCKED`).WithArgs("teste-1", int64(60000)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "formato", "opcoes", "criado_por", "request_id", "tentativas", "max_tentativas"}).
+			AddRow(7, formatJSON, `{"layout":"antt","banco_indisponivel":"marcar"}`, "integracao", "req-7", 1, 5))
+	job, err := claimJob(context.Background(), db, "teste-1", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, int64(7), job.ID)
+	assert.Equal(t, jobExecutando, job.Estado)
+	assert.Equal(t, degradadoMarcar, job.Opcoes.Degradado)
+	assert.Equal(t, "req-7", job.requestID)
+
+	mock.ExpectQuery(`FOR UPDATE SKIP LOCKED`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	job, err = claimJob(context.Background(), db, "teste-1", time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, job, "Fila vazia")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestJobWorker_Execute testa nova tentativa em falha temporária e falha definitiva nas demais
+func TestJobWorker_Execute(t *testing.T) {
+	mock := comBancoMock(t)
+	db, err := getDBConnection(context.Background())
+	require.NoError(t, err)
+	w := workerDeTeste()
+	opcoes := jobOpcoes{Layout: "antt", Degradado: degradadoFalhar}
+
+	t.Run("banco indisponível agenda nova tentativa", func(t *testing.T) {
+		mock.ExpectQuery("FROM job_arquivo").WithArgs(int64(1)).WillReturnError(errors.New("conexão recusada"))
+		mock.ExpectExec("UPDATE job SET estado = 'pendente', erro").
+			WithArgs(int64(1), "teste-1", sqlmock.AnyArg(), int64(20000)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w.execute(context.Background(), db, &Job{ID: 1, CriadoPor: "integracao", Formato: formatCSV, Opcoes: opcoes, Tentativas: 2, MaxTentativas: 3})
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("última tentativa falha o job", func(t *testing.T) {
+		mock.ExpectQuery("FROM job_arquivo").WithArgs(int64(2)).WillReturnError(errors.New("conexão recusada"))
+		mock.ExpectExec("UPDATE job SET estado = 'falhou'").
+			WithArgs(int64(2), "teste-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO webhook_entrega").
+			WithArgs(int64(2), eventoJobFalhou, sqlmock.AnyArg(), "integracao").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		w.execute(context.Background(), db, &Job{ID: 2, CriadoPor: "integracao", Formato: formatCSV, Opcoes: opcoes, Tentativas: 3, MaxTentativas: 3})
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("layout removido falha sem nova tentativa", func(t *testing.T) {
+		mock.ExpectQuery("FROM job_arquivo").WithArgs(int64(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"nome", "conteudo"}).AddRow("a.xml", []byte("<a/>")))
+		mock.ExpectExec("UPDATE job SET estado = 'falhou'").
+			WithArgs(int64(3), "teste-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO webhook_entrega").
+			WithArgs(int64(3), eventoJobFalhou, sqlmock.AnyArg(), "integracao").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		w.execute(context.Background(), db, &Job{ID: 3, CriadoPor: "integracao", Formato: formatCSV, Opcoes: jobOpcoes{Layout: "inexistente"}, Tentativas: 1, MaxTentativas: 3})
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("reservas vencidas esgotam as tentativas", func(t *testing.T) {
+		mock.ExpectExec("UPDATE job SET estado = 'falhou'").
+			WithArgs(int64(4), "teste-1", "job interrompido em 3 tentativas", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO webhook_entrega").
+			WithArgs(int64(4), eventoJobFalhou, sqlmock.AnyArg(), "integracao").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		w.execute(context.Background(), db, &Job{ID: 4, CriadoPor: "integracao", Formato: formatCSV, Opcoes: opcoes, Tentativas: 4, MaxTentativas: 3})
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("encerramento devolve o job à fila", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		mock.ExpectExec("UPDATE job SET estado = 'pendente', tentativas = tentativas - 1").
+			WithArgs(int64(5), "teste-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w.execute(ctx, db, &Job{ID: 5, CriadoPor: "integracao", Formato: formatCSV, Opcoes: opcoes, Tentativas: 1, MaxTentativas: 3})
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// TestUpdateOwnedJob_ReservaPerdida testa que outro worker com a reserva impede o registro do resultado
+func TestUpdateOwnedJob_ReservaPerdida(t *testing.T) {
+	mock := comBancoMock(t)
+	db, err := getDBConnection(context.Background())
+	require.NoError(t, err)
+
+	mock.ExpectExec("UPDATE job SET bloqueado_ate").WithArgs(int64(9), "teste-1", int64(60000)).WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, extendLease(context.Background(), db, 9, "teste-1", time.Minute), errJobPerdido)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestCompleteJob_RegistraLote testa o registro do lote na transação que conclui o job
+func TestCompleteJob_RegistraLote(t *testing.T) {
+	mock := comBancoMock(t)
+	db, err := getDBConnection(context.Background())
+	require.NoError(t, err)
+	registro := &uploadRegistro{
+		files:     []parsedFile{{BatchFile: BatchFile{Nome: "a.xml"}, Hash: "h1"}},
+		registros: []operacaoRegistro{{Chave: "1001|2024-01-15 08:00:00|1001|100", Hash: "h1"}},
+	}
+	concluir := func() *sqlmock.ExpectedExec {
+		mock.ExpectBegin()
+		return mock.ExpectExec("UPDATE job SET estado = 'concluido'").WithArgs(int64(7), "teste-1", []byte(`{}`), []byte("csv"))
+	}
+
+	concluir().WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SAVEPOINT registro_upload").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO upload_arquivo").WithArgs("h1", "a.xml", "", "", "", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO upload_operacao").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, completeJob(context.Background(), db, 7, "teste-1", []byte(`{}`), []byte("csv"), registro))
+
+	// Falha no histórico não impede a conclusão
+	concluir().WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SAVEPOINT registro_upload").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO upload_arquivo").WillReturnError(errors.New("deadlock"))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT registro_upload").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	require.NoError(t, completeJob(context.Background(), db, 7, "teste-1", []byte(`{}`), []byte("csv"), registro))
+
+	// Reserva perdida: nada é registrado
+	concluir().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	assert.ErrorIs(t, completeJob(context.Background(), db, 7, "teste-1", []byte(`{}`), []byte("csv"), registro), errJobPerdido)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestJobHandlers testa o enfileiramento, a consulta restrita ao criador e o resultado apenas após a conclusão
+func TestJobHandlers(t *testing.T) {
+	mock := comBancoMock(t)
+	comConfig(t, func(c *Config) { c.Auth.JWTSecret = "segredo" })
+	router := newRouter()
+	token := func(sub string, papeis ...string) string {
+		return "Bearer " + signJWT(t, `{"alg":"HS256"}`, map[string]interface{}{"sub": sub, "papeis": papeis, "exp": time.Now().Add(time.Hour).Unix()}, "segredo")
+	}
+	request := func(method, target, credencial string, body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+		if body == nil {
+			body = &bytes.Buffer{}
+		}
+		req, _ := http.NewRequest(method, target, body)
+		req.Header.Set("Authorization", credencial)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "btc.xml")
+	part.Write([]byte(btcXML("1", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:30:00"))))
+	writer.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO job ").
+		WithArgs(formatJSON, sqlmock.AnyArg(), "integracao", sqlmock.AnyArg(), 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectExec("INSERT INTO job_arquivo").WithArgs(42, 0, "btc.xml", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := request("POST", "/jobs?format=json", token("integracao", roleUploader), body, writer.FormDataContentType())
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, "/jobs/42", w.Header().Get("Location"))
+	assert.Contains(t, w.Body.String(), `"id":42`)
+
+	criadoEm := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	linhaJob := func(estado string, resumo interface{}) *sqlmock.Rows {
+		return sqlmock.NewRows(colunasJob).AddRow(42, estado, formatJSON, `{"layout":"antt","banco_indisponivel":"falhar"}`,
+			"integracao", "req-1", 1, 5, criadoEm, nil, resumo, nil, criadoEm, nil, nil)
+	}
+
+	mock.ExpectQuery("FROM job WHERE id").WithArgs(int64(42)).WillReturnRows(linhaJob(jobExecutando, nil))
+	w = request("GET", "/jobs/42", token("integracao", roleUploader), nil, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"estado":"executando"`)
+
+	mock.ExpectQuery("FROM job WHERE id").WithArgs(int64(42)).WillReturnRows(linhaJob(jobExecutando, nil))
+	w = request("GET", "/jobs/42", token("painel", roleViewer), nil, "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "Jobs de outra credencial não aparecem")
+
+	mock.ExpectQuery("FROM job WHERE id").WithArgs(int64(42)).WillReturnRows(linhaJob(jobExecutando, nil))
+	w = request("GET", "/jobs/42/resultado", token("integracao", roleUploader), nil, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	mock.ExpectQuery("FROM job WHERE id").WithArgs(int64(42)).WillReturnRows(linhaJob(jobConcluido, `{"linhas":1,"incompleto":true}`))
+	mock.ExpectQuery("SELECT resultado FROM job").WithArgs(int64(42), jobConcluido).
+		WillReturnRows(sqlmock.NewRows([]string{"resultado"}).AddRow([]byte(`{"dados":[]}`)))
+	w = request("GET", "/jobs/42/resultado", token("admin", roleAdmin), nil, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, `{"dados":[]}`, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), mimeJSON)
+	assert.Equal(t, "true", w.Header().Get("X-Export-Incompleto"))
+
+	mock.ExpectQuery("FROM job WHERE id").WithArgs(int64(43)).WillReturnRows(sqlmock.NewRows(colunasJob))
+	assert.Equal(t, http.StatusNotFound, request("GET", "/jobs/43", token("integracao", roleUploader), nil, "").Code)
+	assert.Equal(t, http.StatusBadRequest, request("GET", "/jobs/abc", token("integracao", roleUploader), nil, "").Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
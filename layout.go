@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"gopkg.in/yaml.v3"
+)
+
+// layoutPadrao é o layout usado quando a requisição não escolhe nenhum
+const layoutPadrao = "antt"
+
+// LayoutColumn é uma coluna de um layout de exportação
+type LayoutColumn struct {
+	// Campo é o nome do campo em exportFields, ex.: "data_inicio_viagem"
+	Campo string `json:"campo" yaml:"campo"`
+	// Titulo é o texto do cabeçalho; vazio usa o nome do campo em maiúsculas
+	Titulo string `json:"titulo,omitempty" yaml:"titulo"`
+	// Formato substitui formato_data/formato_hora do layout para esta coluna
+	Formato string `json:"formato,omitempty" yaml:"formato"`
+}
+
+// ExportLayout define as colunas e a formatação do CSV entregue a um destinatário.
+// Datas e horas usam os marcadores AAAA/YYYY, AA/YY, MM, DD, hh, mm e ss.
+type ExportLayout struct {
+	Nome      string         `json:"nome" yaml:"nome"`
+	Descricao string         `json:"descricao,omitempty" yaml:"descricao"`
+	Colunas   []LayoutColumn `json:"colunas" yaml:"colunas"`
+	// Delimitador é um único caractere; padrão ";"
+	Delimitador string `json:"delimitador,omitempty" yaml:"delimitador"`
+	// Aspas: "minimas" (só quando necessário, padrão) ou "todas"
+	Aspas string `json:"aspas,omitempty" yaml:"aspas"`
+	// FimDeLinha: "lf" (padrão) ou "crlf"
+	FimDeLinha string `json:"fim_de_linha,omitempty" yaml:"fim_de_linha"`
+	// Codificacao: "utf-8" (padrão), "utf-8-bom", "latin1" ou "windows-1252"
+	Codificacao string `json:"codificacao,omitempty" yaml:"codificacao"`
+	// FormatoData e FormatoHora; padrão DD/MM/AAAA e hh:mm:ss
+	FormatoData string `json:"formato_data,omitempty" yaml:"formato_data"`
+	FormatoHora string `json:"formato_hora,omitempty" yaml:"formato_hora"`
+	// SeparadorDecimal das coordenadas; padrão "."
+	SeparadorDecimal string `json:"separador_decimal,omitempty" yaml:"separador_decimal"`
+	// CasasDecimais fixa a precisão das coordenadas; ausente mantém o valor original
+	CasasDecimais *int `json:"casas_decimais,omitempty" yaml:"casas_decimais"`
+	// Cabecalho indica se a primeira linha traz os títulos; padrão true
+	Cabecalho *bool `json:"cabecalho,omitempty" yaml:"cabecalho"`
+}
+
+// defaultLayout é o layout da ANTT, idêntico ao CSV gerado desde a primeira versão
+func defaultLayout() *ExportLayout {
+	layout := &ExportLayout{
+		Nome:      layoutPadrao,
+		Descricao: "CSV entregue à ANTT",
+	}
+	for _, campo := range anttFields {
+		layout.Colunas = append(layout.Colunas, LayoutColumn{Campo: campo})
+	}
+	return layout
+}
+
+// excelLayout tem as colunas da ANTT no formato que o Excel em português abre sem ajustes:
+// BOM para reconhecer UTF-8, CRLF e vírgula decimal nas coordenadas. Datas dd/mm/aaaa e
+// horas hh:mm:ss são reconhecidas pelo Excel como valores de data e hora.
+func excelLayout() *ExportLayout {
+	layout := defaultLayout()
+	layout.Nome = "excel"
+	layout.Descricao = "Colunas da ANTT para abrir no Excel (UTF-8 com BOM, CRLF e vírgula decimal)"
+	layout.Codificacao = "utf-8-bom"
+	layout.FimDeLinha = "crlf"
+	layout.SeparadorDecimal = ","
+	layout.FormatoData = "DD/MM/AAAA"
+	layout.FormatoHora = "hh:mm:ss"
+	return layout
+}
+
+// builtinLayouts são os layouts disponíveis sem arquivo de configuração
+func builtinLayouts() map[string]*ExportLayout {
+	return map[string]*ExportLayout{
+		layoutPadrao: defaultLayout(),
+		"excel":      excelLayout(),
+	}
+}
+
+// layoutsDir é o diretório com os layouts adicionais (.json, .yaml ou .yml)
+func layoutsDir() string {
+	return cfg.ExportLayoutsDir
+}
+
+// validate confere campos e opções do layout
+func (l *ExportLayout) validate() error {
+	if l.Nome == "" {
+		return fmt.Errorf("layout sem nome")
+	}
+	if len(l.Colunas) == 0 {
+		return fmt.Errorf("layout %s sem colunas", l.Nome)
+	}
+	if _, err := l.columns(); err != nil {
+		return err
+	}
+	if l.Delimitador != "" && (utf8.RuneCountInString(l.Delimitador) != 1 || strings.ContainsAny(l.Delimitador, "\"\r\n")) {
+		return fmt.Errorf("layout %s: delimitador inválido %q", l.Nome, l.Delimitador)
+	}
+	switch l.Aspas {
+	case "", "minimas", "todas":
+	default:
+		return fmt.Errorf("layout %s: aspas deve ser minimas ou todas", l.Nome)
+	}
+	switch l.FimDeLinha {
+	case "", "lf", "crlf":
+	default:
+		return fmt.Errorf("layout %s: fim_de_linha deve ser lf ou crlf", l.Nome)
+	}
+	if _, err := l.encoder(); err != nil {
+		return err
+	}
+	if l.CasasDecimais != nil && (*l.CasasDecimais < 0 || *l.CasasDecimais > 12) {
+		return fmt.Errorf("layout %s: casas_decimais deve estar entre 0 e 12", l.Nome)
+	}
+	return nil
+}
+
+// columns resolve as colunas do layout no registro de campos exportáveis
+func (l *ExportLayout) columns() ([]exportColumn, error) {
+	columns := make([]exportColumn, 0, len(l.Colunas))
+	for _, c := range l.Colunas {
+		campo := strings.ToLower(strings.TrimSpace(c.Campo))
+		field, ok := exportFields[campo]
+		if !ok {
+			return nil, fmt.Errorf("layout %s: campo desconhecido %q", l.Nome, c.Campo)
+		}
+		header := c.Titulo
+		if header == "" {
+			header = strings.ToUpper(campo)
+		}
+		columns = append(columns, exportColumn{Key: campo, Header: header, Format: c.Formato, exportField: field})
+	}
+	return columns, nil
+}
+
+// mustColumns resolve as colunas de um layout embutido
+func mustColumns(l *ExportLayout) []exportColumn {
+	columns, err := l.columns()
+	if err != nil {
+		panic(err)
+	}
+	return columns
+}
+
+// encoder devolve o codificador de caracteres do layout; nil é UTF-8
+func (l *ExportLayout) encoder() (*encoding.Encoder, error) {
+	switch strings.ToLower(l.Codificacao) {
+	case "", "utf-8", "utf8", "utf-8-bom":
+		return nil, nil
+	case "latin1", "iso-8859-1":
+		return encoding.ReplaceUnsupported(charmap.ISO8859_1.NewEncoder()), nil
+	case "windows-1252", "cp1252":
+		return encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder()), nil
+	}
+	return nil, fmt.Errorf("layout %s: codificação não suportada %q", l.Nome, l.Codificacao)
+}
+
+// layoutTokens converte os marcadores de data e hora dos layouts no formato do pacote time
+var layoutTokens = strings.NewReplacer(
+	"AAAA", "2006", "YYYY", "2006", "AA", "06", "YY", "06",
+	"MM", "01", "DD", "02", "hh", "15", "mm", "04", "ss", "05",
+)
+
+// formatValue aplica os formatos de data, hora e decimal do layout ao valor canônico de uma coluna
+func (l *ExportLayout) formatValue(col exportColumn, value string) string {
+	if value == "" {
+		return value
+	}
+
+	dateFmt, timeFmt := l.FormatoData, l.FormatoHora
+	if dateFmt == "" {
+		dateFmt = "DD/MM/AAAA"
+	}
+	if timeFmt == "" {
+		timeFmt = "hh:mm:ss"
+	}
+
+	reformat := func(canonical, pattern string) string {
+		if col.Format != "" {
+			pattern = col.Format
+		}
+		if t, err := time.Parse(canonical, value); err == nil {
+			return t.Format(layoutTokens.Replace(pattern))
+		}
+		return value
+	}
+
+	switch col.Kind {
+	case kindDate:
+		return reformat("02/01/2006", dateFmt)
+	case kindTime:
+		return reformat("15:04:05", timeFmt)
+	case kindDateTime:
+		return reformat("02/01/2006 15:04:05", dateFmt+" "+timeFmt)
+	case kindDecimal:
+		if l.CasasDecimais != nil {
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				value = strconv.FormatFloat(f, 'f', *l.CasasDecimais, 64)
+			}
+		}
+		if l.SeparadorDecimal != "" && l.SeparadorDecimal != "." {
+			value = strings.Replace(value, ".", l.SeparadorDecimal, 1)
+		}
+	}
+	return value
+}
+
+// quoteField envolve o campo em aspas conforme o layout, seguindo as regras de encoding/csv
+func (l *ExportLayout) quoteField(field, delim string) string {
+	needs := l.Aspas == "todas"
+	if !needs && field != "" {
+		r, _ := utf8.DecodeRuneInString(field)
+		needs = field == `\.` || strings.Contains(field, delim) ||
+			strings.ContainsAny(field, "\"\r\n") || unicode.IsSpace(r)
+	}
+	if !needs {
+		return field
+	}
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
+
+// writeLayout grava as linhas em CSV conforme o layout
+func writeLayout(w io.Writer, layout *ExportLayout, rows []GroupedData) error {
+	columns, err := layout.columns()
+	if err != nil {
+		return err
+	}
+	enc, err := layout.encoder()
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(layout.Codificacao, "utf-8-bom") {
+		if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+			return err
+		}
+	}
+	if enc != nil {
+		w = enc.Writer(w)
+	}
+	out := bufio.NewWriter(w)
+
+	delim := layout.Delimitador
+	if delim == "" {
+		delim = ";"
+	}
+	eol := "\n"
+	if layout.FimDeLinha == "crlf" {
+		eol = "\r\n"
+	}
+
+	fields := make([]string, len(columns))
+	writeRecord := func() {
+		for i, f := range fields {
+			if i > 0 {
+				out.WriteString(delim)
+			}
+			out.WriteString(layout.quoteField(f, delim))
+		}
+		out.WriteString(eol)
+	}
+
+	if layout.Cabecalho == nil || *layout.Cabecalho {
+		for i, col := range columns {
+			fields[i] = col.Header
+		}
+		writeRecord()
+	}
+
+	for _, data := range rows {
+		for i, col := range columns {
+			fields[i] = layout.formatValue(col, col.Value(data))
+		}
+		writeRecord()
+	}
+
+	return out.Flush()
+}
+
+// writeLayoutFile grava as linhas no arquivo csvPath conforme o layout
+func writeLayoutFile(csvPath string, layout *ExportLayout, rows []GroupedData) error {
+	csvFile, err := os.Create(csvPath)
+	if err != nil {
+		return err
+	}
+	if err := writeLayout(csvFile, layout, rows); err != nil {
+		csvFile.Close()
+		return err
+	}
+	return csvFile.Close()
+}
+
+// loadLayouts lê os layouts embutidos e os definidos em arquivos no diretório dir.
+// Arquivos inválidos são ignorados com aviso no log; o diretório é opcional.
+func loadLayouts(dir string) map[string]*ExportLayout {
+	layouts := builtinLayouts()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("não foi possível ler o diretório de layouts", "diretorio", dir, "erro", err)
+		}
+		return layouts
+	}
+
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".json" && ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		layout, err := readLayoutFile(path)
+		if err != nil {
+			slog.Warn("layout ignorado", "arquivo", path, "erro", err)
+			continue
+		}
+		if _, exists := layouts[layout.Nome]; exists {
+			slog.Warn("layout ignorado: nome já está em uso", "arquivo", path, "nome", layout.Nome)
+			continue
+		}
+		layouts[layout.Nome] = layout
+	}
+
+	return layouts
+}
+
+// readLayoutFile lê e valida um layout em JSON ou YAML; sem nome, usa o nome do arquivo
+func readLayoutFile(path string) (*ExportLayout, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := &ExportLayout{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, layout)
+	} else {
+		err = yaml.Unmarshal(data, layout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if layout.Nome == "" {
+		layout.Nome = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	layout.Nome = strings.ToLower(layout.Nome)
+	if err := layout.validate(); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// findLayout procura um layout pelo nome; vazio devolve o layout padrão
+func findLayout(nome string) (*ExportLayout, error) {
+	nome = strings.ToLower(strings.TrimSpace(nome))
+	if nome == "" {
+		nome = layoutPadrao
+	}
+
+	layouts := loadLayouts(layoutsDir())
+	if layout, ok := layouts[nome]; ok {
+		return layout, nil
+	}
+	return nil, fmt.Errorf("layout desconhecido: %s (disponíveis: %s)", nome, strings.Join(layoutNames(layouts), ", "))
+}
+
+// layoutNames lista os nomes dos layouts em ordem alfabética
+func layoutNames(layouts map[string]*ExportLayout) []string {
+	names := make([]string, 0, len(layouts))
+	for nome := range layouts {
+		names = append(names, nome)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// layoutsHandler lista os layouts disponíveis e os campos que podem ser usados nas colunas
+func layoutsHandler(c *gin.Context) {
+	layouts := loadLayouts(layoutsDir())
+
+	lista := make([]*ExportLayout, 0, len(layouts))
+	for _, nome := range layoutNames(layouts) {
+		lista = append(lista, layouts[nome])
+	}
+
+	campos := make([]string, 0, len(exportFields))
+	for campo := range exportFields {
+		campos = append(campos, campo)
+	}
+	sort.Strings(campos)
+
+	c.JSON(http.StatusOK, gin.H{
+		"padrao":  layoutPadrao,
+		"layouts": lista,
+		"campos":  campos,
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWriteLayout_DefaultMatchesANTT testa se o layout padrão mantém o CSV da ANTT
+func TestWriteLayout_DefaultMatchesANTT(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeLayout(&buf, defaultLayout(), []GroupedData{exportFixture()}))
+
+	lines := strings.Split(buf.String(), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "EMPRESA;PREFIXO;CODIGO_LINHA;SENTIDO;DATA_INICIO_VIAGEM;HORA_INICIO_VIAGEM;HORA_FINAL_VIAGEM;"+
+		"QTE_PAX_PAGANTES;QTE_IDOSO;QTE_PL;QTE_OUTRAS_GRATUIDADE;QTE_TOTAL_PAX;QTE_PAGO_DINHEIRO;QTE_PAGO_ELETRONICO;"+
+		"DISTANCIA_VIAGEM;TEMPO_VIAGEM;VELOCIDADE_MEDIA;LT_ABERTURA_VIAGEM;LG_ABERTURA_VIAGEM;LT_FECHAMENTO_VIAGEM;"+
+		"LG_FECHAMENTO_VIAGEM;VEICULO_NUMERO;CPF_RODOVIARIO", lines[0])
+	assert.Equal(t, "Amazonia Inter Turismo LTDA;12073070;1001;GO-DF;15/01/2024;08:00:00;09:30:00;45;0;0;0;0;0;0;42;01:30:00;28;-15.43488062;-47.6108282;;;JHX-0E23;", lines[1])
+	assert.Equal(t, "", lines[2])
+}
+
+// TestWriteLayout_Options testa formatos de data, decimal, aspas, fim de linha e codificação
+func TestWriteLayout_Options(t *testing.T) {
+	casas := 2
+	semCabecalho := false
+	layout := &ExportLayout{
+		Nome: "teste",
+		Colunas: []LayoutColumn{
+			{Campo: "empresa", Titulo: "Operadora"},
+			{Campo: "data_inicio_viagem"},
+			{Campo: "data_hora_inicio", Formato: "YYYYMMDDhhmm"},
+			{Campo: "lt_abertura_viagem"},
+			{Campo: "qte_gratuidades"},
+			{Campo: "duracao_minutos"},
+		},
+		Delimitador:      ",",
+		Aspas:            "todas",
+		FimDeLinha:       "crlf",
+		Codificacao:      "latin1",
+		FormatoData:      "AAAA-MM-DD",
+		SeparadorDecimal: ",",
+		CasasDecimais:    &casas,
+		Cabecalho:        &semCabecalho,
+	}
+	require.NoError(t, layout.validate())
+
+	data := exportFixture()
+	data.Empresa = "Viação São José"
+	data.Idoso, data.PasseLivre = 2, 1
+
+	var buf bytes.Buffer
+	require.NoError(t, writeLayout(&buf, layout, []GroupedData{data}))
+
+	assert.Equal(t, "\"Via\xe7\xe3o S\xe3o Jos\xe9\",\"2024-01-15\",\"202401150800\",\"-15,43\",\"3\",\"90\"\r\n", buf.String())
+}
+
+// TestWriteLayout_Excel testa o layout excel: BOM, CRLF, vírgula decimal e datas e horas reconhecíveis
+func TestWriteLayout_Excel(t *testing.T) {
+	data := exportFixture()
+	data.Empresa = "Viação Goiânia"
+
+	var buf bytes.Buffer
+	require.NoError(t, writeLayout(&buf, excelLayout(), []GroupedData{data}))
+	out := buf.String()
+
+	require.True(t, strings.HasPrefix(out, "\uFEFFEMPRESA;PREFIXO;"), "Deve começar com o BOM e o cabeçalho da ANTT")
+	lines := strings.Split(strings.TrimPrefix(out, "\uFEFF"), "\r\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "", lines[2], "Última linha deve terminar em CRLF")
+	assert.NotContains(t, lines[0], "\n")
+
+	fields := strings.Split(lines[1], ";")
+	require.Len(t, fields, 23)
+	assert.Equal(t, "Viação Goiânia", fields[0])
+	assert.Equal(t, "15/01/2024", fields[4])
+	assert.Equal(t, "08:00:00", fields[5])
+	assert.Equal(t, "01:30:00", fields[15])
+	assert.Equal(t, "-15,43488062", fields[17])
+	assert.Equal(t, "-47,6108282", fields[18])
+	assert.Equal(t, "", fields[19])
+}
+
+// TestExportLayout_Validate testa a rejeição de layouts inválidos
+func TestExportLayout_Validate(t *testing.T) {
+	colunas := []LayoutColumn{{Campo: "empresa"}}
+
+	tests := []struct {
+		name   string
+		layout ExportLayout
+		erro   string
+	}{
+		{"Campo desconhecido", ExportLayout{Nome: "x", Colunas: []LayoutColumn{{Campo: "placa"}}}, "campo desconhecido"},
+		{"Sem colunas", ExportLayout{Nome: "x"}, "sem colunas"},
+		{"Delimitador longo", ExportLayout{Nome: "x", Colunas: colunas, Delimitador: ";;"}, "delimitador"},
+		{"Aspas inválidas", ExportLayout{Nome: "x", Colunas: colunas, Aspas: "nunca"}, "aspas"},
+		{"Codificação desconhecida", ExportLayout{Nome: "x", Colunas: colunas, Codificacao: "ebcdic"}, "codificação"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.layout.validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.erro)
+		})
+	}
+}
+
+// TestLoadLayouts testa a leitura de layouts em JSON e YAML, ignorando arquivos inválidos
+func TestLoadLayouts(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "estado.json"),
+		[]byte(`{"colunas":[{"campo":"codigo_linha","titulo":"LINHA"}],"delimitador":"|"}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fin.yaml"),
+		[]byte("nome: Financeiro\ncolunas:\n  - campo: qte_total_pax\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "quebrado.yml"),
+		[]byte("colunas:\n  - campo: inexistente\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "leiame.txt"), []byte("ignorado"), 0644))
+
+	layouts := loadLayouts(dir)
+	assert.Equal(t, []string{"antt", "estado", "excel", "financeiro"}, layoutNames(layouts))
+	assert.Equal(t, "|", layouts["estado"].Delimitador)
+
+	comConfig(t, func(c *Config) { c.ExportLayoutsDir = dir })
+	layout, err := findLayout("")
+	require.NoError(t, err)
+	assert.Equal(t, layoutPadrao, layout.Nome)
+
+	_, err = findLayout("quebrado")
+	assert.ErrorContains(t, err, "layout desconhecido")
+}
+
+// TestLoadLayouts_RepoSamples testa se os layouts de exemplo do repositório são válidos
+func TestLoadLayouts_RepoSamples(t *testing.T) {
+	layouts := loadLayouts("layouts")
+	assert.Contains(t, layouts, "financeiro")
+	assert.Contains(t, layouts, "estadual")
+}
+
+// TestUploadHandler_Layout testa a escolha do layout por ?layout= nas saídas CSV e JSON
+func TestUploadHandler_Layout(t *testing.T) {
+	semCadastro(t, "1001")
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "curto.json"),
+		[]byte(`{"colunas":[{"campo":"sentido","titulo":"SENTIDO"},{"campo":"qte_gratuidades"}],"delimitador":","}`), 0644))
+	comConfig(t, func(c *Config) { c.ExportLayoutsDir = dir })
+
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	w := uploadRequest(t, "/upload?layout=curto", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "SENTIDO,QTE_GRATUIDADES\nGO-DF,0\n", w.Body.String())
+
+	w = uploadRequest(t, "/upload?layout=curto&format=json", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Viagens []map[string]interface{} `json:"viagens"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Viagens, 1)
+	assert.Equal(t, map[string]interface{}{"sentido": "GO-DF", "qte_gratuidades": float64(0)}, resp.Viagens[0])
+
+	w = uploadRequest(t, "/upload?layout=nenhum", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "curto")
+}
+
+// TestLayoutsHandler testa a listagem de layouts e campos disponíveis
+func TestLayoutsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	comConfig(t, func(c *Config) { c.ExportLayoutsDir = t.TempDir() })
+
+	router := gin.New()
+	router.GET("/layouts", layoutsHandler)
+
+	req, _ := http.NewRequest("GET", "/layouts", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Padrao  string         `json:"padrao"`
+		Layouts []ExportLayout `json:"layouts"`
+		Campos  []string       `json:"campos"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "antt", resp.Padrao)
+	require.Len(t, resp.Layouts, 2)
+	assert.Equal(t, "antt", resp.Layouts[0].Nome)
+	assert.Equal(t, "excel", resp.Layouts[1].Nome)
+	assert.Len(t, resp.Layouts[0].Colunas, 23)
+	assert.Contains(t, resp.Campos, "duracao_minutos")
+}
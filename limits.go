@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrUploadGrande indica um envio acima de upload.max_bytes, max_expanded_bytes ou max_files
+var ErrUploadGrande = errors.New("upload acima do limite")
+
+// ErrConteudoNaoSuportado indica um arquivo que não é XML, .zip nem .tar.gz, qualquer que seja o nome
+var ErrConteudoNaoSuportado = errors.New("conteúdo não suportado")
+
+// XMLLimitError é um XML que excede os limites de estrutura (profundidade, operações) ou declara DOCTYPE
+type XMLLimitError struct {
+	// Arquivo é vazio quando o limite é do lote inteiro
+	Arquivo string
+	Motivo  string
+}
+
+func (e *XMLLimitError) Error() string {
+	if e.Arquivo == "" {
+		return e.Motivo
+	}
+	return fmt.Sprintf("%s: %s", e.Arquivo, e.Motivo)
+}
+
+// Tipos de conteúdo reconhecidos por sniffContent
+const (
+	conteudoXML   = "xml"
+	conteudoZip   = "zip"
+	conteudoGzip  = "gzip"
+	conteudoOutro = ""
+)
+
+// sniffContent identifica o conteúdo pelos primeiros bytes: assinaturas de zip e gzip
+// ou, para XML, um "<" depois do BOM e de espaços
+func sniffContent(conteudo []byte) string {
+	switch {
+	case bytes.HasPrefix(conteudo, []byte("PK\x03\x04")), bytes.HasPrefix(conteudo, []byte("PK\x05\x06")):
+		return conteudoZip
+	case bytes.HasPrefix(conteudo, []byte("\x1f\x8b")):
+		return conteudoGzip
+	}
+	resto := bytes.TrimLeft(bytes.TrimPrefix(conteudo, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if bytes.HasPrefix(resto, []byte("<")) {
+		return conteudoXML
+	}
+	return conteudoOutro
+}
+
+// readLimited lê r até restante bytes; acima disso devolve ErrUploadGrande
+func readLimited(r io.Reader, nome string, restante *int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, *restante+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > *restante {
+		return nil, fmt.Errorf("%w: %s descompactado passa de %s", ErrUploadGrande, nome, cfg.Upload.MaxExpandedBytes)
+	}
+	*restante -= int64(len(data))
+	return data, nil
+}
+
+// checkXMLLimits percorre o XML sem decodificá-lo, conferindo a profundidade dos elementos e
+// as operações de cada btc, e devolve o total de operações. DOCTYPE é recusado: os BTCs não o
+// usam e ele é a porta de entrada de entidades. Erros de sintaxe ficam para xml.Unmarshal.
+func checkXMLLimits(f BatchFile) (int, error) {
+	limites := cfg.Upload
+	dec := xml.NewDecoder(bytes.NewReader(f.Conteudo))
+	var caminho []string
+	total, porBtc := 0, 0
+
+	for {
+		tok, err := dec.RawToken()
+		if err != nil {
+			return total, nil
+		}
+		switch t := tok.(type) {
+		case xml.Directive:
+			if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(string(t))), "DOCTYPE") {
+				return total, &XMLLimitError{Arquivo: f.Nome, Motivo: "declaração DOCTYPE não permitida"}
+			}
+		case xml.StartElement:
+			caminho = append(caminho, t.Name.Local)
+			if len(caminho) > limites.MaxDepth {
+				return total, &XMLLimitError{Arquivo: f.Nome, Motivo: fmt.Sprintf("elementos aninhados além de %d níveis", limites.MaxDepth)}
+			}
+			switch strings.Join(caminho, "/") {
+			case "btcs/btc":
+				porBtc = 0
+			case "btcs/btc/operacoes/operacao":
+				porBtc++
+				total++
+				if porBtc > limites.MaxOperacoesPorBtc {
+					return total, &XMLLimitError{Arquivo: f.Nome, Motivo: fmt.Sprintf("btc com mais de %d operações", limites.MaxOperacoesPorBtc)}
+				}
+			}
+		case xml.EndElement:
+			if len(caminho) > 0 {
+				caminho = caminho[:len(caminho)-1]
+			}
+		}
+	}
+}
+
+// checkBatchLimits confere os limites de estrutura de cada arquivo e o total de operações do lote
+func checkBatchLimits(files []BatchFile) error {
+	total := 0
+	for _, f := range files {
+		n, err := checkXMLLimits(f)
+		if err != nil {
+			return err
+		}
+		total += n
+		if total > cfg.Upload.MaxOperacoes {
+			return &XMLLimitError{Motivo: fmt.Sprintf("lote com mais de %d operações", cfg.Upload.MaxOperacoes)}
+		}
+	}
+	return nil
+}
+
+// respondUploadLimit escreve a resposta dos erros de limite: 413 para tamanho, 415 para
+// conteúdo não suportado e 422 para a estrutura do XML. Devolve falso para os demais erros.
+func respondUploadLimit(c *gin.Context, err error) bool {
+	var maxErr *http.MaxBytesError
+	var limitErr *XMLLimitError
+	switch {
+	case errors.As(err, &maxErr):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":   ErrUploadGrande.Error(),
+			"message": fmt.Sprintf("O envio deve ter no máximo %s", cfg.Upload.MaxBytes),
+		})
+	case errors.Is(err, ErrUploadGrande):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":   err.Error(),
+			"message": fmt.Sprintf("Limites: %s por envio, %s descompactados por arquivo e %d arquivos XML", cfg.Upload.MaxBytes, cfg.Upload.MaxExpandedBytes, cfg.Upload.MaxFiles),
+		})
+	case errors.Is(err, ErrConteudoNaoSuportado):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":   err.Error(),
+			"message": "Envie arquivos XML, .zip ou .tar.gz",
+		})
+	case errors.As(err, &limitErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   err.Error(),
+			"message": fmt.Sprintf("Limites: %d níveis de elementos, %d operações por btc e %d por lote", cfg.Upload.MaxDepth, cfg.Upload.MaxOperacoesPorBtc, cfg.Upload.MaxOperacoes),
+		})
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zipDeTeste monta um .zip com as entradas nome -> conteúdo
+func zipDeTeste(t *testing.T, entradas map[string]string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for nome, conteudo := range entradas {
+		w, err := zw.Create(nome)
+		require.NoError(t, err)
+		w.Write([]byte(conteudo))
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// TestExpandUpload_Sniffing testa que o tipo vem do conteúdo e não da extensão
+func TestExpandUpload_Sniffing(t *testing.T) {
+	xmlBOM := "\xef\xbb\xbf\n  " + btcXML("1", "")
+
+	files, err := expandUpload("sem-extensao", []byte(xmlBOM))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	files, err = expandUpload("lote.xml", zipDeTeste(t, map[string]string{"a.xml": btcXML("1", ""), "LEIAME.txt": "texto"}))
+	require.NoError(t, err, "zip com nome .xml é descompactado")
+	require.Len(t, files, 1)
+	assert.Equal(t, "lote.xml/a.xml", files[0].Nome)
+
+	gzBuf := &bytes.Buffer{}
+	gz := gzip.NewWriter(gzBuf)
+	tw := tar.NewWriter(gz)
+	conteudo := btcXML("2", "")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "b.xml", Mode: 0644, Size: int64(len(conteudo)), Typeflag: tar.TypeReg}))
+	tw.Write([]byte(conteudo))
+	tw.Close()
+	gz.Close()
+	files, err = expandUpload("lote.bin", gzBuf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "lote.bin/b.xml", files[0].Nome)
+
+	_, err = expandUpload("planilha.xml", []byte("%PDF-1.7 ..."))
+	assert.ErrorIs(t, err, ErrConteudoNaoSuportado)
+	_, err = expandUpload("vazio.xml", nil)
+	assert.ErrorIs(t, err, ErrConteudoNaoSuportado)
+	_, err = expandUpload("lote.zip", zipDeTeste(t, map[string]string{"a.xml": "não é xml"}))
+	assert.ErrorIs(t, err, ErrConteudoNaoSuportado)
+	assert.Contains(t, err.Error(), "lote.zip/a.xml")
+}
+
+// TestExpandUpload_Limites testa os limites de bytes descompactados e de arquivos
+func TestExpandUpload_Limites(t *testing.T) {
+	comConfig(t, func(c *Config) {
+		c.Upload.MaxExpandedBytes = kilobyte
+		c.Upload.MaxFiles = 2
+	})
+
+	bomba := zipDeTeste(t, map[string]string{"a.xml": "<btcs>" + strings.Repeat(" ", 2048) + "</btcs>"})
+	assert.Less(t, len(bomba), 1024, "Comprimido fica abaixo do limite")
+	_, err := expandUpload("bomba.zip", bomba)
+	assert.ErrorIs(t, err, ErrUploadGrande)
+	assert.Contains(t, err.Error(), "1KB")
+
+	_, err = expandUpload("muitos.zip", zipDeTeste(t, map[string]string{"a.xml": "<a/>", "b.xml": "<b/>", "c.xml": "<c/>"}))
+	assert.ErrorIs(t, err, ErrUploadGrande)
+}
+
+// TestCheckBatchLimits testa profundidade, operações por btc e por lote, DOCTYPE e XML malformado
+func TestCheckBatchLimits(t *testing.T) {
+	comConfig(t, func(c *Config) {
+		c.Upload.MaxDepth = 8
+		c.Upload.MaxOperacoesPorBtc = 2
+		c.Upload.MaxOperacoes = 3
+	})
+	op := operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00")
+	arquivo := func(nome, conteudo string) BatchFile { return BatchFile{Nome: nome, Conteudo: []byte(conteudo)} }
+
+	require.NoError(t, checkBatchLimits([]BatchFile{arquivo("a.xml", btcXML("1", "", op, op))}))
+	require.NoError(t, checkBatchLimits([]BatchFile{arquivo("quebrado.xml", "<btcs><btc>")}), "Erro de sintaxe fica para a decodificação")
+
+	tests := []struct {
+		name   string
+		files  []BatchFile
+		motivo string
+	}{
+		{"Operações demais no btc", []BatchFile{arquivo("a.xml", btcXML("1", "", op, op, op))}, "a.xml: btc com mais de 2 operações"},
+		{"Operações demais no lote", []BatchFile{arquivo("a.xml", btcXML("1", "", op, op)), arquivo("b.xml", btcXML("2", "", op, op))}, "lote com mais de 3 operações"},
+		{"Aninhamento profundo", []BatchFile{arquivo("a.xml", strings.Repeat("<a>", 9)+strings.Repeat("</a>", 9))}, "além de 8 níveis"},
+		{"DOCTYPE", []BatchFile{arquivo("a.xml", `<!DOCTYPE btcs [<!ENTITY x "y">]><btcs/>`)}, "DOCTYPE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBatchLimits(tt.files)
+			var limitErr *XMLLimitError
+			require.ErrorAs(t, err, &limitErr)
+			assert.Contains(t, err.Error(), tt.motivo)
+		})
+	}
+}
+
+// TestUploadLimits_Status testa as respostas 413, 415 e 422 de /upload, /validate e /jobs
+func TestUploadLimits_Status(t *testing.T) {
+	semBanco(t)
+	comConfig(t, func(c *Config) {
+		c.Upload.MaxBytes = 4 * kilobyte
+		c.Upload.MaxOperacoesPorBtc = 1
+	})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/upload", uploadHandler)
+	router.POST("/validate", validateHandler)
+	router.POST("/jobs", createJobHandler)
+
+	op := operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00")
+	enviar := func(target, nome, conteudo string, tamanhoConhecido bool) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", nome)
+		part.Write([]byte(conteudo))
+		writer.Close()
+
+		var corpo io.Reader = body
+		if !tamanhoConhecido {
+			corpo = io.MultiReader(body)
+		}
+		req, _ := http.NewRequest("POST", target, corpo)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	grande := "<btcs>" + strings.Repeat(" ", 8192) + "</btcs>"
+	w := enviar("/upload", "grande.xml", grande, true)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, "Content-Length acima do limite")
+	assert.Contains(t, w.Body.String(), "4KB")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, enviar("/upload", "grande.xml", grande, false).Code, "Corpo sem Content-Length")
+
+	w = enviar("/upload", "foto.xml", "\x89PNG\r\n\x1a\n", true)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Contains(t, w.Body.String(), "foto.xml")
+
+	for _, target := range []string{"/upload", "/validate", "/jobs"} {
+		w = enviar(target, "a.xml", btcXML("1", "", op, op), true)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, target)
+		assert.Contains(t, w.Body.String(), "btc com mais de 1 operações", target)
+	}
+}
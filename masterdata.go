@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// localMasterData são os dados mestres lidos de arquivos locais (CSV ou JSON), usados pela
+// linha de comando no lugar do banco: motoristas (cod_identificador, cpf), linhas (colunas
+// de parametro_viagem) e veículos (veiculo, placa). Tabelas nil continuam vindo do banco
+// (CPFs e linhas) ou das placas embutidas (veículos).
+type localMasterData struct {
+	cpfs   map[string]string
+	linhas map[string]*ParametroViagem
+	placas map[string]Cars
+}
+
+// localData são os dados mestres carregados pela linha de comando; nil usa o banco
+var localData *localMasterData
+
+// usingLocalMasterData indica se CPFs e linhas vêm de arquivos, sem consultar o banco
+func usingLocalMasterData() bool {
+	return localData != nil && localData.cpfs != nil
+}
+
+// masterDataSource descreve de onde vêm CPFs e parâmetros das linhas
+func masterDataSource() string {
+	if usingLocalMasterData() {
+		return "arquivos"
+	}
+	return "banco"
+}
+
+// vehiclePlates devolve as placas por número de veículo: do arquivo de veículos, se informado
+func vehiclePlates() map[string]Cars {
+	if localData != nil && localData.placas != nil {
+		return localData.placas
+	}
+	return PlacaV()
+}
+
+// cpf devolve o CPF do código identificador ("" se não cadastrado)
+func (m *localMasterData) cpf(codigo string) string {
+	return m.cpfs[masterKey(codigo)]
+}
+
+// linha devolve os parâmetros da linha (nil se não cadastrada)
+func (m *localMasterData) linha(codigo string) *ParametroViagem {
+	return m.linhas[masterKey(codigo)]
+}
+
+// masterKey normaliza códigos numéricos como o banco (inteiros): "0951716" e "951716" são o mesmo código
+func masterKey(codigo string) string {
+	codigo = strings.TrimSpace(codigo)
+	if n, err := strconv.Atoi(codigo); err == nil {
+		return strconv.Itoa(n)
+	}
+	return codigo
+}
+
+// loadLocalMasterData lê os arquivos informados; caminhos vazios deixam a tabela nil
+func loadLocalMasterData(motoristas, linhas, veiculos string) (*localMasterData, error) {
+	m := &localMasterData{}
+
+	if motoristas != "" {
+		m.cpfs = make(map[string]string)
+		registros, err := readMasterFile(motoristas, "cod_identificador", "cpf")
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range registros {
+			m.cpfs[masterKey(r["cod_identificador"])] = r["cpf"]
+		}
+	}
+
+	if linhas != "" {
+		m.linhas = make(map[string]*ParametroViagem)
+		registros, err := readMasterFile(linhas, "cod_linha")
+		if err != nil {
+			return nil, err
+		}
+		for i, r := range registros {
+			param, err := parametroFromRecord(r)
+			if err != nil {
+				return nil, fmt.Errorf("%s: registro %d: %w", linhas, i+1, err)
+			}
+			m.linhas[masterKey(r["cod_linha"])] = param
+		}
+	}
+
+	if veiculos != "" {
+		registros, err := readMasterFile(veiculos, "veiculo", "placa")
+		if err != nil {
+			return nil, err
+		}
+		m.placas = make(map[string]Cars, len(registros))
+		for _, r := range registros {
+			m.placas[strings.TrimSpace(r["veiculo"])] = Cars{Placa: r["placa"]}
+		}
+	}
+	return m, nil
+}
+
+// parametroFromRecord monta os parâmetros de uma linha com as colunas de parametro_viagem
+func parametroFromRecord(r map[string]string) (*ParametroViagem, error) {
+	codLinha, err := strconv.Atoi(strings.TrimSpace(r["cod_linha"]))
+	if err != nil {
+		return nil, fmt.Errorf("cod_linha inválido: %q", r["cod_linha"])
+	}
+	param := &ParametroViagem{
+		CodLinha: codLinha,
+		Local1:   r["local1"],
+		Local2:   r["local2"],
+		Linha:    r["linha"],
+		CodANTT:  r["cod_antt"],
+		Lat1:     r["lat1"],
+		Long1:    r["long1"],
+		Lat2:     r["lat2"],
+		Long2:    r["long2"],
+	}
+	for _, campo := range []struct {
+		nome   string
+		target *sql.NullInt64
+	}{
+		{"distancia_km", &param.DistanciaKm},
+		{"distancia_minutos", &param.DistanciaMinutos},
+	} {
+		v := strings.TrimSpace(r[campo.nome])
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s inválido: %q", campo.nome, v)
+		}
+		*campo.target = sql.NullInt64{Int64: n, Valid: true}
+	}
+	return param, nil
+}
+
+// readMasterFile lê um arquivo .csv (cabeçalho na primeira linha, separado por vírgula ou
+// ponto e vírgula) ou .json (lista de objetos) e confere as colunas obrigatórias
+func readMasterFile(path string, obrigatorias ...string) ([]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var registros []map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		registros, err = readMasterCSV(data)
+	case ".json":
+		registros, err = readMasterJSON(data)
+	default:
+		err = fmt.Errorf("formato não suportado (use .csv ou .json)")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler %s: %w", path, err)
+	}
+
+	for i, r := range registros {
+		for _, coluna := range obrigatorias {
+			if strings.TrimSpace(r[coluna]) == "" {
+				return nil, fmt.Errorf("%s: registro %d sem %s", path, i+1, coluna)
+			}
+		}
+	}
+	return registros, nil
+}
+
+func readMasterCSV(data []byte) ([]map[string]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // BOM das planilhas exportadas pelo Excel
+	primeira, _, _ := bytes.Cut(data, []byte("\n"))
+
+	r := csv.NewReader(bytes.NewReader(data))
+	if bytes.Count(primeira, []byte(";")) > bytes.Count(primeira, []byte(",")) {
+		r.Comma = ';'
+	}
+	linhas, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(linhas) == 0 {
+		return nil, fmt.Errorf("arquivo vazio")
+	}
+
+	cabecalho := make([]string, len(linhas[0]))
+	for i, c := range linhas[0] {
+		cabecalho[i] = strings.ToLower(strings.TrimSpace(c))
+	}
+	registros := make([]map[string]string, 0, len(linhas)-1)
+	for _, linha := range linhas[1:] {
+		r := make(map[string]string, len(cabecalho))
+		for i, v := range linha {
+			r[cabecalho[i]] = strings.TrimSpace(v)
+		}
+		registros = append(registros, r)
+	}
+	return registros, nil
+}
+
+func readMasterJSON(data []byte) ([]map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var objetos []map[string]interface{}
+	if err := dec.Decode(&objetos); err != nil {
+		return nil, err
+	}
+
+	registros := make([]map[string]string, 0, len(objetos))
+	for _, o := range objetos {
+		r := make(map[string]string, len(o))
+		for k, v := range o {
+			if v != nil {
+				r[strings.ToLower(k)] = strings.TrimSpace(fmt.Sprint(v))
+			}
+		}
+		registros = append(registros, r)
+	}
+	return registros, nil
+}
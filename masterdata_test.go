@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// escreveArquivo grava um arquivo de dados mestres no diretório temporário do teste
+func escreveArquivo(t *testing.T, nome, conteudo string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), nome)
+	require.NoError(t, os.WriteFile(path, []byte(conteudo), 0o644))
+	return path
+}
+
+// comDadosLocais instala dados mestres locais durante o teste
+func comDadosLocais(t *testing.T, data *localMasterData) {
+	t.Helper()
+	original := localData
+	localData = data
+	t.Cleanup(func() { localData = original })
+}
+
+// TestLoadLocalMasterData_CSVeJSON testa a leitura de motoristas em CSV com ponto e vírgula e de linhas e veículos em JSON
+func TestLoadLocalMasterData_CSVeJSON(t *testing.T) {
+	motoristas := escreveArquivo(t, "motoristas.csv", "\xef\xbb\xbfCod_Identificador;CPF\n0951716;12345678901\n42;98765432100\n")
+	linhas := escreveArquivo(t, "linhas.json", `[
+		{"cod_linha": 1001, "local1": "Brasília", "local2": "Goiânia", "linha": "BSB-GYN", "cod_antt": "12-3456-00",
+		 "lat1": "-15.79", "long1": "-47.88", "lat2": "-16.68", "long2": "-49.25", "distancia_km": 209, "distancia_minutos": null}
+	]`)
+	veiculos := escreveArquivo(t, "veiculos.csv", "veiculo,placa\n2001,ABC-1D23\n")
+
+	m, err := loadLocalMasterData(motoristas, linhas, veiculos)
+	require.NoError(t, err)
+
+	assert.Equal(t, "12345678901", m.cpf("951716"), "Códigos numéricos são comparados como inteiros, como no banco")
+	assert.Equal(t, "98765432100", m.cpf("0042"))
+	assert.Empty(t, m.cpf("7"))
+
+	param := m.linha("1001")
+	require.NotNil(t, param)
+	assert.Equal(t, ParametroViagem{
+		CodLinha: 1001, Local1: "Brasília", Local2: "Goiânia", Linha: "BSB-GYN", CodANTT: "12-3456-00",
+		Lat1: "-15.79", Long1: "-47.88", Lat2: "-16.68", Long2: "-49.25",
+		DistanciaKm: sql.NullInt64{Int64: 209, Valid: true},
+	}, *param)
+	assert.Nil(t, m.linha("2002"))
+
+	assert.Equal(t, map[string]Cars{"2001": {Placa: "ABC-1D23"}}, m.placas)
+}
+
+// TestLoadLocalMasterData_Erros testa arquivos com formato, colunas ou valores inválidos
+func TestLoadLocalMasterData_Erros(t *testing.T) {
+	tests := []struct {
+		nome       string
+		motoristas string
+		linhas     string
+		esperado   string
+	}{
+		{"extensão", escreveArquivo(t, "motoristas.txt", "cod_identificador,cpf\n"), "", "formato não suportado"},
+		{"coluna ausente", escreveArquivo(t, "motoristas.csv", "codigo,cpf\n1,123\n"), "", "registro 1 sem cod_identificador"},
+		{"distância", "", escreveArquivo(t, "linhas.csv", "cod_linha,distancia_km\n1001,longe\n"), "distancia_km inválido"},
+		{"json", "", escreveArquivo(t, "linhas.json", `{"cod_linha": 1}`), "erro ao ler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nome, func(t *testing.T) {
+			_, err := loadLocalMasterData(tt.motoristas, tt.linhas, "")
+			assert.ErrorContains(t, err, tt.esperado)
+		})
+	}
+}
+
+// TestLocalMasterData_Consultas testa que com dados locais as consultas não usam cache nem banco
+func TestLocalMasterData_Consultas(t *testing.T) {
+	semBanco(t)
+	comDadosLocais(t, &localMasterData{
+		cpfs:   map[string]string{"951716": "12345678901"},
+		linhas: map[string]*ParametroViagem{"1001": {CodLinha: 1001, Linha: "BSB-GYN"}},
+		placas: map[string]Cars{"2001": {Placa: "ABC-1D23"}},
+	})
+
+	cpf, err := getCPFByCodIdentificador(context.Background(), "951716")
+	require.NoError(t, err)
+	assert.Equal(t, "12345678901", cpf)
+
+	param, err := getParametroViagemByCodLinha(context.Background(), "1001")
+	require.NoError(t, err)
+	assert.Equal(t, "BSB-GYN", param.Linha)
+
+	param, err = getParametroViagemByCodLinha(context.Background(), "9999")
+	require.NoError(t, err, "Linha ausente do arquivo não é falha do banco")
+	assert.Nil(t, param)
+
+	assert.Equal(t, "arquivos", masterDataSource())
+	assert.Equal(t, map[string]Cars{"2001": {Placa: "ABC-1D23"}}, vehiclePlates())
+	assert.False(t, cpfCache.Contains("951716"), "Dados locais não passam pelo cache")
+}
+
+// TestLocalMasterData_SoVeiculos testa que só o arquivo de veículos mantém CPFs e linhas no banco
+func TestLocalMasterData_SoVeiculos(t *testing.T) {
+	comDadosLocais(t, &localMasterData{placas: map[string]Cars{"2001": {Placa: "ABC-1D23"}}})
+
+	assert.False(t, usingLocalMasterData())
+	assert.Equal(t, "banco", masterDataSource())
+	assert.Contains(t, vehiclePlates(), "2001")
+
+	localData = nil
+	assert.Equal(t, PlacaV(), vehiclePlates())
+}
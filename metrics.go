@@ -0,0 +1,196 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixa as métricas da aplicação
+const metricsNamespace = "btc"
+
+// Fases do processamento de um lote em btc_processing_phase_duration_seconds
+const (
+	faseParse          = "parse"
+	faseEnriquecimento = "enrich"
+	faseEscrita        = "write"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Requisições HTTP por rota, método e status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duração das requisições HTTP por rota e método.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"method", "route"})
+
+	uploadBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_bytes",
+		Help:      "Tamanho dos arquivos enviados (antes de descompactar).",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10), // 1 KiB a 256 MiB
+	})
+
+	arquivosProcessados = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "files_processed_total",
+		Help:      "Arquivos XML dos lotes processados, por resultado (ok, erro, duplicado).",
+	}, []string{"resultado"})
+
+	operacoesProcessadas = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_processed_total",
+		Help:      "Operações enriquecidas nos lotes processados.",
+	})
+
+	linhasEscritas = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rows_written_total",
+		Help:      "Linhas geradas na saída dos lotes processados.",
+	})
+
+	linhasIncompletas = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rows_missing_total",
+		Help:      "Linhas geradas sem um dado de enriquecimento (cpf, linha ou placa).",
+	}, []string{"campo"})
+
+	faseDuracao = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "processing_phase_duration_seconds",
+		Help:      "Duração de cada fase do processamento de um lote (parse, enrich, write).",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"fase"})
+)
+
+// Métricas dos caches, lidas por cacheCollector; declaradas antes de metricsRegistry,
+// que as descreve ao registrar o coletor na inicialização do pacote
+var (
+	cacheHitsDesc     = prometheus.NewDesc(metricsNamespace+"_cache_hits_total", "Consultas respondidas pelo cache.", []string{"cache"}, nil)
+	cacheMissesDesc   = prometheus.NewDesc(metricsNamespace+"_cache_misses_total", "Consultas não encontradas no cache.", []string{"cache"}, nil)
+	cacheRatioDesc    = prometheus.NewDesc(metricsNamespace+"_cache_hit_ratio", "Proporção de acertos do cache desde o início.", []string{"cache"}, nil)
+	cacheEntriesDesc  = prometheus.NewDesc(metricsNamespace+"_cache_entries", "Entradas no cache, incluindo as negativas.", []string{"cache"}, nil)
+	cacheNegativeDesc = prometheus.NewDesc(metricsNamespace+"_cache_negative_entries", "Entradas negativas (não encontrado) no cache.", []string{"cache"}, nil)
+)
+
+// metricsRegistry reúne as métricas expostas em /metrics
+var metricsRegistry = newMetricsRegistry()
+
+func newMetricsRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, uploadBytes,
+		arquivosProcessados, operacoesProcessadas, linhasEscritas, linhasIncompletas, faseDuracao,
+		cacheCollector{},
+		dbStatsCollector{template: collectors.NewDBStatsCollector(nil, metricsNamespace)},
+	)
+	return r
+}
+
+// metricsHandler expõe as métricas no formato do Prometheus
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+}
+
+// metricsMiddleware conta as requisições e mede a duração por rota registrada
+// (ex.: /admin/cpf/:codigo), sem usar o caminho real para não multiplicar as séries
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		inicio := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "desconhecida"
+		}
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(inicio).Seconds())
+	}
+}
+
+// observePhase registra a duração de uma fase do processamento iniciada em inicio
+func observePhase(fase string, inicio time.Time) {
+	faseDuracao.WithLabelValues(fase).Observe(time.Since(inicio).Seconds())
+}
+
+// recordBatchMetrics contabiliza os arquivos, operações e linhas de um lote processado
+func recordBatchMetrics(result *BatchResult, enriched []enrichedOperacao) {
+	for _, arquivo := range result.Arquivos {
+		switch {
+		case arquivo.ArquivoDuplicado:
+			arquivosProcessados.WithLabelValues("duplicado").Inc()
+		case arquivo.Erro != "":
+			arquivosProcessados.WithLabelValues("erro").Inc()
+		default:
+			arquivosProcessados.WithLabelValues("ok").Inc()
+		}
+	}
+
+	operacoesProcessadas.Add(float64(len(enriched)))
+	linhasEscritas.Add(float64(result.Linhas))
+	for _, e := range enriched {
+		if !e.Info.CPFEncontrado {
+			linhasIncompletas.WithLabelValues("cpf").Inc()
+		}
+		if !e.Info.LinhaEncontrada {
+			linhasIncompletas.WithLabelValues("linha").Inc()
+		}
+		if !e.Info.VeiculoEncontrado {
+			linhasIncompletas.WithLabelValues("placa").Inc()
+		}
+	}
+}
+
+// cacheCollector lê as estatísticas de cpfCache e linhaCache a cada coleta
+// (os caches são recriados por configureCaches, então não são capturados no registro)
+type cacheCollector struct{}
+
+func (cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+	ch <- cacheRatioDesc
+	ch <- cacheEntriesDesc
+	ch <- cacheNegativeDesc
+}
+
+func (cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for nome, stats := range map[string]CacheStats{"cpf": cpfCache.Stats(), "linha": linhaCache.Stats()} {
+		ratio := 0.0
+		if total := stats.Hits + stats.Misses; total > 0 {
+			ratio = float64(stats.Hits) / float64(total)
+		}
+		ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(stats.Hits), nome)
+		ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(stats.Misses), nome)
+		ch <- prometheus.MustNewConstMetric(cacheRatioDesc, prometheus.GaugeValue, ratio, nome)
+		ch <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(stats.Entradas), nome)
+		ch <- prometheus.MustNewConstMetric(cacheNegativeDesc, prometheus.GaugeValue, float64(stats.Negativas), nome)
+	}
+}
+
+// dbStatsCollector expõe sql.DBStats do pool quando a conexão está pronta;
+// o pool só existe depois que dbConn conecta, por isso não é registrado diretamente
+type dbStatsCollector struct {
+	template prometheus.Collector
+}
+
+func (c dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.template.Describe(ch)
+}
+
+func (c dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	if db := dbConn.Ready(); db != nil {
+		collectors.NewDBStatsCollector(db, metricsNamespace).Collect(ch)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrapeMetrics devolve o texto de /metrics após uma requisição a uma rota com parâmetro
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(metricsMiddleware())
+	router.GET("/metrics", metricsHandler())
+	router.GET("/layouts/:nome", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	req, _ := http.NewRequest("GET", "/layouts/antt", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
+
+// TestMetricsEndpoint testa as métricas HTTP por rota registrada, dos caches e do pool
+func TestMetricsEndpoint(t *testing.T) {
+	comBancoMock(t)
+	originalCPF, originalLinha := cpfCache, linhaCache
+	configureCaches(defaultConfig().Cache)
+	t.Cleanup(func() { cpfCache, linhaCache = originalCPF, originalLinha })
+
+	cpfCache.Set("951716", "12345678901", false)
+	cpfCache.Get("951716")
+	cpfCache.Get("951717")
+
+	body := scrapeMetrics(t)
+	assert.Contains(t, body, `btc_http_requests_total{method="GET",route="/layouts/:nome",status="204"}`)
+	assert.Contains(t, body, `btc_http_request_duration_seconds_count{method="GET",route="/layouts/:nome"}`)
+	assert.NotContains(t, body, `route="/layouts/antt"`, "O caminho real não vira série")
+	assert.Contains(t, body, `btc_cache_hit_ratio{cache="cpf"} 0.5`)
+	assert.Contains(t, body, `btc_cache_entries{cache="linha"} 0`)
+	assert.Contains(t, body, `go_sql_max_open_connections{db_name="btc"}`)
+}
+
+// TestMetricsEndpoint_WithoutDB testa que sem pool as estatísticas do banco são omitidas
+func TestMetricsEndpoint_WithoutDB(t *testing.T) {
+	semBanco(t)
+
+	body := scrapeMetrics(t)
+	assert.NotContains(t, body, "go_sql_max_open_connections")
+	assert.Contains(t, body, "btc_cache_hits_total")
+}
+
+// TestProcessBatch_Metrics testa a contagem de operações, linhas e dados ausentes e as fases do lote
+func TestProcessBatch_Metrics(t *testing.T) {
+	semCadastro(t, "1001")
+	operacoes := testutil.ToFloat64(operacoesProcessadas)
+	linhas := testutil.ToFloat64(linhasEscritas)
+	semCPF := testutil.ToFloat64(linhasIncompletas.WithLabelValues("cpf"))
+	semLinha := testutil.ToFloat64(linhasIncompletas.WithLabelValues("linha"))
+	semPlaca := testutil.ToFloat64(linhasIncompletas.WithLabelValues("placa"))
+	arquivosOK := testutil.ToFloat64(arquivosProcessados.WithLabelValues("ok"))
+
+	files := []BatchFile{{Nome: "garagem.xml", Conteudo: []byte(btcXML("1", "",
+		operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"),
+		operacaoXML("9999", "1001", "200", "2024-01-15 10:00:00", "2024-01-15 11:00:00")))}}
+	_, err := ProcessBatchWithOptions(files, "", BatchOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(operacoesProcessadas)-operacoes)
+	assert.Equal(t, 2.0, testutil.ToFloat64(linhasEscritas)-linhas)
+	assert.Equal(t, 2.0, testutil.ToFloat64(linhasIncompletas.WithLabelValues("cpf"))-semCPF)
+	assert.Equal(t, 2.0, testutil.ToFloat64(linhasIncompletas.WithLabelValues("linha"))-semLinha)
+	assert.Equal(t, 1.0, testutil.ToFloat64(linhasIncompletas.WithLabelValues("placa"))-semPlaca, "Veículo 9999 não tem placa")
+	assert.Equal(t, 1.0, testutil.ToFloat64(arquivosProcessados.WithLabelValues("ok"))-arquivosOK)
+
+	body := scrapeMetrics(t)
+	assert.Contains(t, body, `btc_processing_phase_duration_seconds_count{fase="parse"}`)
+	assert.Contains(t, body, `btc_processing_phase_duration_seconds_count{fase="enrich"}`)
+}
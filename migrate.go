@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrações versionadas do esquema: migrations/NNNN_nome.up.sql e, quando reversível, NNNN_nome.down.sql
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID identifica o advisory lock do PostgreSQL que serializa as migrações entre réplicas
+const migrationLockID = 730350035
+
+const schemaMigrationsSQL = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		nome TEXT NOT NULL,
+		aplicado_em TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+`
+
+// Migration é uma versão do esquema com o SQL de aplicação e, opcionalmente, de reversão
+type Migration struct {
+	Version int
+	Nome    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus é a situação de uma migração no banco
+type MigrationStatus struct {
+	Version    int        `json:"version"`
+	Nome       string     `json:"nome"`
+	Aplicada   bool       `json:"aplicada"`
+	AplicadaEm *time.Time `json:"aplicada_em,omitempty"`
+}
+
+// loadMigrations lê as migrações do diretório migrations de fsys, ordenadas por versão
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		name := path.Base(file)
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migração %s: nome deve terminar em .up.sql ou .down.sql", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		prefix, nome, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migração %s: nome deve seguir NNNN_descricao", name)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Nome: nome}
+			byVersion[version] = m
+		} else if m.Nome != nome {
+			return nil, fmt.Errorf("migração %d com nomes diferentes: %s e %s", version, m.Nome, nome)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migração %04d_%s sem arquivo .up.sql", m.Version, m.Nome)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// withMigrationLock executa fn numa conexão que detém o advisory lock das migrações
+func withMigrationLock(db *sql.DB, fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("erro ao obter conexão: %w", err)
+	}
+	defer conn.Close()
+
+	// O lock é da sessão: outra réplica espera aqui até a primeira terminar
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("erro ao obter lock das migrações: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			slog.WarnContext(ctx, "erro ao liberar lock das migrações", "erro", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, schemaMigrationsSQL); err != nil {
+		return fmt.Errorf("erro ao criar schema_migrations: %w", err)
+	}
+	return fn(ctx, conn)
+}
+
+// appliedMigrations devolve as versões já aplicadas e quando foram aplicadas
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, aplicado_em FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao consultar schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var aplicadoEm time.Time
+		if err := rows.Scan(&version, &aplicadoEm); err != nil {
+			return nil, err
+		}
+		applied[version] = aplicadoEm
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executa o SQL da migração e atualiza schema_migrations numa única transação
+func runMigration(ctx context.Context, conn *sql.Conn, m Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, record, args := m.Up, "INSERT INTO schema_migrations (version, nome) VALUES ($1, $2)", []interface{}{m.Version, m.Nome}
+	if !up {
+		script, record, args = m.Down, "DELETE FROM schema_migrations WHERE version = $1", []interface{}{m.Version}
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migração %04d_%s: %w", m.Version, m.Nome, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return fmt.Errorf("migração %04d_%s: erro ao registrar: %w", m.Version, m.Nome, err)
+	}
+	return tx.Commit()
+}
+
+// migrateUp aplica as migrações pendentes e devolve as versões aplicadas
+func migrateUp(db *sql.DB) ([]int, error) {
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []int
+	err = withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "migração aplicada", "migracao", fmt.Sprintf("%04d_%s", m.Version, m.Nome))
+			done = append(done, m.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// migrateDown reverte as últimas steps migrações aplicadas e devolve as versões revertidas.
+// Migrações sem .down.sql (pessoa e parametro_viagem guardam dados mestres) não são revertidas.
+func migrateDown(db *sql.DB, steps int) ([]int, error) {
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []int
+	err = withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migração %04d_%s não é reversível", m.Version, m.Nome)
+			}
+			if err := runMigration(ctx, conn, m, false); err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "migração revertida", "migracao", fmt.Sprintf("%04d_%s", m.Version, m.Nome))
+			done = append(done, m.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// migrationStatus lista todas as migrações conhecidas e se já foram aplicadas. Apenas lê
+// schema_migrations: não espera o advisory lock de uma migração em andamento nem cria a
+// tabela, e um banco ainda sem ela é um banco sem migrações aplicadas.
+func migrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter conexão: %w", err)
+	}
+	defer conn.Close()
+
+	var existe bool
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", "schema_migrations").Scan(&existe); err != nil {
+		return nil, fmt.Errorf("erro ao verificar schema_migrations: %w", err)
+	}
+	applied := map[int]time.Time{}
+	if existe {
+		if applied, err = appliedMigrations(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Nome: m.Nome}
+		if aplicadoEm, ok := applied[m.Version]; ok {
+			s.Aplicada = true
+			s.AplicadaEm = &aplicadoEm
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// migrateConnectWait é quanto o subcomando migrate espera a conexão com o banco
+const migrateConnectWait = time.Minute
+
+// runMigrateCommand implementa "migrate up", "migrate down [n]" e "migrate status"
+func runMigrateCommand(args []string) error {
+	usage := fmt.Errorf("uso: migrate up | migrate down [n] | migrate status")
+	if len(args) == 0 {
+		return usage
+	}
+
+	steps := 1
+	if args[0] == "down" && len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("número de migrações inválido: %q", args[1])
+		}
+		steps = n
+	}
+
+	// O subcomando controla as migrações; não aplicar as pendentes ao conectar
+	cfg.MigrateOnStart = false
+	// No deploy o banco pode estar subindo junto: esperar mais do que uma requisição esperaria
+	ctx, cancel := context.WithTimeout(context.Background(), migrateConnectWait)
+	defer cancel()
+	db, err := dbConn.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := migrateUp(db)
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("Nenhuma migração pendente")
+		}
+	case "down":
+		if _, err := migrateDown(db, steps); err != nil {
+			return err
+		}
+	case "status":
+		status, err := migrationStatus(db)
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(os.Stdout, status)
+	default:
+		return usage
+	}
+	return nil
+}
+
+// printMigrationStatus escreve a tabela de situação das migrações
+func printMigrationStatus(w io.Writer, status []MigrationStatus) {
+	for _, s := range status {
+		situacao := "pendente"
+		if s.Aplicada {
+			situacao = "aplicada em " + s.AplicadaEm.Format("02/01/2006 15:04:05")
+		}
+		fmt.Fprintf(w, "%04d  %-24s %s\n", s.Version, s.Nome, situacao)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadMigrations testa a leitura e a ordem das migrações embutidas
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS)
+	require.NoError(t, err)
+
+	var nomes []string
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "Versões sequenciais")
+		nomes = append(nomes, m.Nome)
+	}
+	assert.Equal(t, []string{"pessoa", "parametro_viagem", "upload_historico", "api_keys", "cache_notify", "jobs", "webhooks", "job_progresso"}, nomes)
+	assert.Contains(t, migrations[1].Up, "CREATE TABLE IF NOT EXISTS parametro_viagem")
+	assert.Empty(t, migrations[0].Down, "Dados mestres não são revertidos")
+	assert.Contains(t, migrations[3].Down, "DROP TABLE IF EXISTS api_keys")
+}
+
+// TestLoadMigrations_Invalid testa a rejeição de arquivos com nome inválido ou sem .up.sql
+func TestLoadMigrations_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		erro  string
+	}{
+		{"Sem versão", fstest.MapFS{"migrations/pessoa.up.sql": {}}, "NNNN_descricao"},
+		{"Sem direção", fstest.MapFS{"migrations/0001_pessoa.sql": {}}, ".up.sql ou .down.sql"},
+		{"Só down", fstest.MapFS{"migrations/0001_pessoa.down.sql": {Data: []byte("DROP TABLE pessoa")}}, "sem arquivo .up.sql"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMigrations(tt.files)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.erro)
+		})
+	}
+}
+
+// expectMigrationLock prepara o lock, a tabela de controle e a consulta das versões aplicadas
+func expectMigrationLock(mock sqlmock.Sqlmock, aplicadas ...int) {
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "aplicado_em"})
+	for _, v := range aplicadas {
+		rows.AddRow(v, time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery("SELECT version, aplicado_em FROM schema_migrations").WillReturnRows(rows)
+}
+
+// TestMigrateUp testa que apenas as migrações pendentes são aplicadas, dentro do advisory lock
+func TestMigrateUp(t *testing.T) {
+	mock := comBancoMock(t)
+	expectMigrationLock(mock, 1, 2, 3)
+	for _, m := range []struct {
+		version int
+		nome    string
+		ddl     string
+	}{{4, "api_keys", "CREATE TABLE IF NOT EXISTS api_keys"}, {5, "cache_notify", "CREATE OR REPLACE FUNCTION notify_cache_invalidation"},
+		{6, "jobs", "CREATE TABLE IF NOT EXISTS job"},
+		{7, "webhooks", "CREATE TABLE IF NOT EXISTS webhook"},
+		{8, "job_progresso", "ALTER TABLE job ADD COLUMN IF NOT EXISTS progresso"}} {
+		mock.ExpectBegin()
+		mock.ExpectExec(m.ddl).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(m.version, m.nome).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := migrateUp(dbConn.db)
+	require.NoError(t, err)
+	assert.Equal(t, []int{4, 5, 6, 7, 8}, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestMigrateUp_Failure testa que uma migração com erro é desfeita e o lock é liberado
+func TestMigrateUp_Failure(t *testing.T) {
+	mock := comBancoMock(t)
+	expectMigrationLock(mock, 1, 2, 3)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS api_keys").WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := migrateUp(dbConn.db)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0004_api_keys")
+	assert.Empty(t, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestMigrateDown testa a reversão das últimas migrações e a recusa das irreversíveis
+func TestMigrateDown(t *testing.T) {
+	mock := comBancoMock(t)
+	expectMigrationLock(mock, 1, 2, 3, 4, 5)
+	for _, m := range []struct {
+		version int
+		ddl     string
+	}{{5, "DROP TRIGGER IF EXISTS parametro_viagem_cache_notify"}, {4, "DROP TABLE IF EXISTS api_keys"}} {
+		mock.ExpectBegin()
+		mock.ExpectExec(m.ddl).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(m.version).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := migrateDown(dbConn.db, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 4}, done)
+
+	expectMigrationLock(mock, 1, 2)
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err = migrateDown(dbConn.db, 1)
+	assert.ErrorContains(t, err, "0002_parametro_viagem não é reversível")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestMigrationStatus testa a listagem das migrações aplicadas e pendentes, sem lock nem CREATE TABLE
+func TestMigrationStatus(t *testing.T) {
+	mock := comBancoMock(t)
+	mock.ExpectQuery("SELECT to_regclass").WithArgs("schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"existe"}).AddRow(true))
+	rows := sqlmock.NewRows([]string{"version", "aplicado_em"})
+	for _, v := range []int{1, 2, 3} {
+		rows.AddRow(v, time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery("SELECT version, aplicado_em FROM schema_migrations").WillReturnRows(rows)
+
+	status, err := migrationStatus(dbConn.db)
+	require.NoError(t, err)
+	require.Len(t, status, 8)
+	assert.True(t, status[2].Aplicada)
+	assert.False(t, status[3].Aplicada)
+
+	var out bytes.Buffer
+	printMigrationStatus(&out, status)
+	assert.Contains(t, out.String(), "0001  pessoa")
+	assert.Contains(t, out.String(), "aplicada em 15/01/2024 12:00:00")
+	assert.Contains(t, out.String(), "0004  api_keys                 pendente")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestMigrationStatus_SemTabela testa que um banco sem schema_migrations tem todas as migrações pendentes
+func TestMigrationStatus_SemTabela(t *testing.T) {
+	mock := comBancoMock(t)
+	mock.ExpectQuery("SELECT to_regclass").WithArgs("schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"existe"}).AddRow(false))
+
+	status, err := migrationStatus(dbConn.db)
+	require.NoError(t, err)
+	require.Len(t, status, 8)
+	for _, s := range status {
+		assert.False(t, s.Aplicada, s.Nome)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "Nem lock nem CREATE TABLE")
+}
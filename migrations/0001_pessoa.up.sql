@@ -1,5 +1,4 @@
--- Criar tabela pessoa se não existir
--- Estrutura real: id_pessoa como chave primária, cod_identificador como campo separado
+-- Motoristas e cobradores; o CPF é buscado pelo cod_identificador (matdmtu do BTC)
 CREATE TABLE IF NOT EXISTS pessoa (
     id_pessoa SERIAL PRIMARY KEY,
     cod_identificador INTEGER NOT NULL,
@@ -8,8 +7,4 @@ CREATE TABLE IF NOT EXISTS pessoa (
     status BOOLEAN DEFAULT true
 );
 
--- Criar índice para melhorar performance nas consultas
 CREATE INDEX IF NOT EXISTS idx_pessoa_cod_identificador ON pessoa(cod_identificador);
-
-
-
@@ -0,0 +1,14 @@
+-- Parâmetros de cada linha: prefixo ANTT, terminais, coordenadas e tempo/distância de referência
+CREATE TABLE IF NOT EXISTS parametro_viagem (
+    cod_linha INTEGER PRIMARY KEY,
+    local1 VARCHAR(100) NOT NULL,
+    local2 VARCHAR(100) NOT NULL,
+    linha VARCHAR(200) NOT NULL,
+    cod_antt VARCHAR(20) NOT NULL,
+    lat1 VARCHAR(20) NOT NULL,
+    long1 VARCHAR(20) NOT NULL,
+    lat2 VARCHAR(20) NOT NULL,
+    long2 VARCHAR(20) NOT NULL,
+    distancia_km INTEGER,
+    distancia_minutos INTEGER
+);
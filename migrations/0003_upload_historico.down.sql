@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS upload_operacao;
+DROP TABLE IF EXISTS upload_arquivo;
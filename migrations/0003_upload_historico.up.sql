@@ -0,0 +1,21 @@
+-- Histórico de uploads: detecção de arquivos e operações já processados
+CREATE TABLE IF NOT EXISTS upload_arquivo (
+    hash VARCHAR(64) PRIMARY KEY,
+    nome TEXT NOT NULL,
+    cod_empresa VARCHAR(20),
+    data_ini VARCHAR(30),
+    data_fim VARCHAR(30),
+    operacoes INTEGER NOT NULL DEFAULT 0,
+    processado_em TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS upload_operacao (
+    chave TEXT PRIMARY KEY,
+    hash_arquivo VARCHAR(64) NOT NULL REFERENCES upload_arquivo(hash),
+    veiculo VARCHAR(20),
+    datainicio VARCHAR(30),
+    linha VARCHAR(20),
+    roleta_inicial VARCHAR(20)
+);
+
+CREATE INDEX IF NOT EXISTS idx_upload_operacao_hash ON upload_operacao(hash_arquivo);
@@ -0,0 +1,11 @@
+-- Chaves de API: apenas o hash SHA-256 da chave é armazenado
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    nome TEXT NOT NULL,
+    prefixo VARCHAR(20) NOT NULL,
+    hash VARCHAR(64) NOT NULL UNIQUE,
+    papeis TEXT[] NOT NULL,
+    criado_em TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ultimo_uso TIMESTAMPTZ,
+    revogado_em TIMESTAMPTZ
+);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS parametro_viagem_cache_notify ON parametro_viagem;
+DROP TRIGGER IF EXISTS pessoa_cache_notify ON pessoa;
+DROP FUNCTION IF EXISTS notify_cache_invalidation();
@@ -0,0 +1,31 @@
+-- Notifica as réplicas (LISTEN btc_cache) quando pessoa ou parametro_viagem mudam,
+-- para que os caches de CPF e de linha sejam invalidados imediatamente
+CREATE OR REPLACE FUNCTION notify_cache_invalidation() RETURNS trigger AS $$
+DECLARE
+    coluna TEXT := TG_ARGV[0];
+    antigo INTEGER;
+    novo INTEGER;
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        EXECUTE format('SELECT ($1).%I', coluna) INTO antigo USING OLD;
+        PERFORM pg_notify('btc_cache', json_build_object('tabela', TG_TABLE_NAME, 'chave', antigo)::text);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        EXECUTE format('SELECT ($1).%I', coluna) INTO novo USING NEW;
+        IF antigo IS DISTINCT FROM novo THEN
+            PERFORM pg_notify('btc_cache', json_build_object('tabela', TG_TABLE_NAME, 'chave', novo)::text);
+        END IF;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS pessoa_cache_notify ON pessoa;
+CREATE TRIGGER pessoa_cache_notify
+    AFTER INSERT OR UPDATE OR DELETE ON pessoa
+    FOR EACH ROW EXECUTE FUNCTION notify_cache_invalidation('cod_identificador');
+
+DROP TRIGGER IF EXISTS parametro_viagem_cache_notify ON parametro_viagem;
+CREATE TRIGGER parametro_viagem_cache_notify
+    AFTER INSERT OR UPDATE OR DELETE ON parametro_viagem
+    FOR EACH ROW EXECUTE FUNCTION notify_cache_invalidation('cod_linha');
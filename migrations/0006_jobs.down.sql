@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS job_arquivo;
+DROP TABLE IF EXISTS job;
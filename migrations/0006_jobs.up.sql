@@ -0,0 +1,36 @@
+-- Fila de processamento: uploads enfileirados em POST /jobs e executados pelo subcomando "worker".
+-- Os workers reservam jobs com FOR UPDATE SKIP LOCKED; um job em execução cuja reserva
+-- (bloqueado_ate) venceu é retomado por outro worker.
+CREATE TABLE IF NOT EXISTS job (
+    id BIGSERIAL PRIMARY KEY,
+    estado VARCHAR(20) NOT NULL DEFAULT 'pendente'
+        CHECK (estado IN ('pendente', 'executando', 'concluido', 'falhou')),
+    formato VARCHAR(10) NOT NULL,
+    opcoes JSONB NOT NULL DEFAULT '{}',
+    criado_por TEXT NOT NULL,
+    request_id TEXT,
+    tentativas INTEGER NOT NULL DEFAULT 0,
+    max_tentativas INTEGER NOT NULL,
+    executar_em TIMESTAMPTZ NOT NULL DEFAULT now(),
+    worker TEXT,
+    bloqueado_ate TIMESTAMPTZ,
+    erro TEXT,
+    resumo JSONB,
+    resultado BYTEA,
+    criado_em TIMESTAMPTZ NOT NULL DEFAULT now(),
+    iniciado_em TIMESTAMPTZ,
+    concluido_em TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_pendente ON job(executar_em) WHERE estado = 'pendente';
+CREATE INDEX IF NOT EXISTS idx_job_executando ON job(bloqueado_ate) WHERE estado = 'executando';
+CREATE INDEX IF NOT EXISTS idx_job_concluido ON job(concluido_em) WHERE estado IN ('concluido', 'falhou');
+
+-- Arquivos do upload, já extraídos de .zip/.tar.gz, na ordem do envio
+CREATE TABLE IF NOT EXISTS job_arquivo (
+    job_id BIGINT NOT NULL REFERENCES job(id) ON DELETE CASCADE,
+    ordem INTEGER NOT NULL,
+    nome TEXT NOT NULL,
+    conteudo BYTEA NOT NULL,
+    PRIMARY KEY (job_id, ordem)
+);
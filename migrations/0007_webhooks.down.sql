@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS webhook_entrega;
+DROP TABLE IF EXISTS webhook;
@@ -0,0 +1,36 @@
+-- Notificações de jobs terminados. Um webhook sem job_id vale para todos os jobs de quem o
+-- cadastrou (POST /webhooks); com job_id, apenas para aquele upload (POST /jobs?webhook=).
+-- O segredo assina o corpo (HMAC-SHA256) e precisa ficar em claro para isso.
+CREATE TABLE IF NOT EXISTS webhook (
+    id BIGSERIAL PRIMARY KEY,
+    url TEXT NOT NULL,
+    segredo TEXT NOT NULL,
+    job_id BIGINT REFERENCES job(id) ON DELETE CASCADE,
+    criado_por TEXT NOT NULL,
+    criado_em TIMESTAMPTZ NOT NULL DEFAULT now(),
+    removido_em TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_criado_por ON webhook(criado_por) WHERE job_id IS NULL AND removido_em IS NULL;
+CREATE INDEX IF NOT EXISTS idx_webhook_job ON webhook(job_id) WHERE job_id IS NOT NULL;
+
+-- Registro das entregas: uma linha por webhook e job, com a situação da última tentativa
+CREATE TABLE IF NOT EXISTS webhook_entrega (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id BIGINT NOT NULL REFERENCES webhook(id) ON DELETE CASCADE,
+    job_id BIGINT NOT NULL REFERENCES job(id) ON DELETE CASCADE,
+    evento VARCHAR(20) NOT NULL,
+    payload JSONB NOT NULL,
+    estado VARCHAR(20) NOT NULL DEFAULT 'pendente'
+        CHECK (estado IN ('pendente', 'entregue', 'falhou')),
+    tentativas INTEGER NOT NULL DEFAULT 0,
+    proxima_tentativa TIMESTAMPTZ NOT NULL DEFAULT now(),
+    status_http INTEGER,
+    erro TEXT,
+    criado_em TIMESTAMPTZ NOT NULL DEFAULT now(),
+    entregue_em TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_entrega_pendente ON webhook_entrega(proxima_tentativa) WHERE estado = 'pendente';
+CREATE INDEX IF NOT EXISTS idx_webhook_entrega_webhook ON webhook_entrega(webhook_id, id);
+CREATE INDEX IF NOT EXISTS idx_webhook_entrega_job ON webhook_entrega(job_id);
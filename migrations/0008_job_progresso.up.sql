@@ -0,0 +1,3 @@
+-- Progresso do job em execução (leitura, enriquecimento, escrita), gravado pelo worker a cada
+-- segundo e transmitido por GET /jobs/:id/events
+ALTER TABLE job ADD COLUMN IF NOT EXISTS progresso JSONB;
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// BatchTiming registra a duração das etapas de enriquecimento de um lote
+type BatchTiming struct {
+	// PrefetchMs é o tempo da pré-carga de motoristas e linhas (uma consulta por tabela)
+	PrefetchMs int64 `json:"prefetch_ms"`
+	// EnriquecimentoMs é o tempo do enriquecimento das operações, já com os dados em memória
+	EnriquecimentoMs int64 `json:"enriquecimento_ms"`
+	Motoristas       int   `json:"motoristas"`
+	Linhas           int   `json:"linhas"`
+	Consultas        int   `json:"consultas"`
+}
+
+// prefetchMasterData carrega de uma vez os CPFs e parâmetros de linha usados no lote,
+// para que buildGroupedData encontre tudo nos caches sem consultar o banco por operação.
+// Sem banco ou com erro na consulta, as buscas individuais continuam funcionando como antes.
+func prefetchMasterData(ctx context.Context, parsed []parsedFile, timing *BatchTiming) {
+	inicio := time.Now()
+	defer func() { timing.PrefetchMs = time.Since(inicio).Milliseconds() }()
+
+	motoristas := make(map[string]bool)
+	linhas := make(map[string]bool)
+	for _, f := range parsed {
+		for _, btc := range f.Btcs.Btc {
+			if btc.Matdmtu != "" {
+				motoristas[btc.Matdmtu] = true
+			}
+			for _, operacao := range btc.Operacoes.Operacao {
+				linhas[operacao.Linha] = true
+			}
+		}
+	}
+	timing.Motoristas = len(motoristas)
+	timing.Linhas = len(linhas)
+
+	if usingLocalMasterData() {
+		return
+	}
+
+	if !dbBreaker.Allow() {
+		return
+	}
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			dbBreaker.Failure()
+		}
+		return
+	}
+
+	if n, err := prefetchCPFs(ctx, db, motoristas); ctx.Err() != nil {
+		return
+	} else if err != nil {
+		dbBreaker.Failure()
+		slog.WarnContext(ctx, "erro na pré-carga de CPFs, usando consultas individuais", "erro", err)
+	} else {
+		timing.Consultas += n
+	}
+
+	if n, err := prefetchLinhas(ctx, db, linhas); ctx.Err() != nil {
+		return
+	} else if err != nil {
+		dbBreaker.Failure()
+		slog.WarnContext(ctx, "erro na pré-carga de parametro_viagem, usando consultas individuais", "erro", err)
+	} else {
+		timing.Consultas += n
+	}
+}
+
+// pendingCodes separa os códigos ainda fora do cache em numéricos (a consultar) e inválidos
+func pendingCodes(codes map[string]bool, cached func(string) bool) (ids map[int][]string, invalidos []string) {
+	ids = make(map[int][]string)
+	for code := range codes {
+		if cached(code) {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(code))
+		if err != nil {
+			invalidos = append(invalidos, code)
+			continue
+		}
+		ids[id] = append(ids[id], code)
+	}
+	return ids, invalidos
+}
+
+func idList(ids map[int][]string) []int64 {
+	list := make([]int64, 0, len(ids))
+	for id := range ids {
+		list = append(list, int64(id))
+	}
+	return list
+}
+
+// prefetchCPFs preenche cpfCache com uma consulta ANY($1); códigos sem pessoa ficam com CPF vazio
+func prefetchCPFs(ctx context.Context, db *sql.DB, motoristas map[string]bool) (int, error) {
+	ids, invalidos := pendingCodes(motoristas, cpfCache.Contains)
+
+	found := make(map[string]string)
+	if len(ids) > 0 {
+		queryCtx, cancel := statementContext(ctx)
+		defer cancel()
+		rows, err := db.QueryContext(queryCtx, "SELECT cod_identificador, cpf FROM pessoa WHERE cod_identificador = ANY($1)", pq.Array(idList(ids)))
+		if err != nil {
+			return 0, fmt.Errorf("erro ao consultar CPFs: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int
+			var cpf sql.NullString
+			if err := rows.Scan(&id, &cpf); err != nil {
+				return 0, fmt.Errorf("erro ao ler CPFs: %w", err)
+			}
+			for _, code := range ids[id] {
+				// Com mais de uma pessoa para o código, manter o primeiro CPF preenchido
+				if found[code] == "" {
+					found[code] = cpf.String
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("erro ao ler CPFs: %w", err)
+		}
+	}
+
+	for _, codes := range ids {
+		for _, code := range codes {
+			cpfCache.Set(code, found[code], found[code] == "")
+		}
+	}
+	for _, code := range invalidos {
+		cpfCache.Set(code, "", true)
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// prefetchLinhas preenche linhaCache com uma consulta ANY($1); linhas sem parâmetro ficam nil
+func prefetchLinhas(ctx context.Context, db *sql.DB, linhas map[string]bool) (int, error) {
+	ids, invalidos := pendingCodes(linhas, linhaCache.Contains)
+
+	found := make(map[string]*ParametroViagem)
+	if len(ids) > 0 {
+		queryCtx, cancel := statementContext(ctx)
+		defer cancel()
+		rows, err := db.QueryContext(queryCtx, `
+			SELECT cod_linha, local1, local2, linha, cod_antt,
+			       lat1, long1, lat2, long2, distancia_km, distancia_minutos
+			FROM parametro_viagem
+			WHERE cod_linha = ANY($1)
+		`, pq.Array(idList(ids)))
+		if err != nil {
+			return 0, fmt.Errorf("erro ao consultar parametro_viagem: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var param ParametroViagem
+			if err := rows.Scan(
+				&param.CodLinha,
+				&param.Local1,
+				&param.Local2,
+				&param.Linha,
+				&param.CodANTT,
+				&param.Lat1,
+				&param.Long1,
+				&param.Lat2,
+				&param.Long2,
+				&param.DistanciaKm,
+				&param.DistanciaMinutos,
+			); err != nil {
+				return 0, fmt.Errorf("erro ao ler parametro_viagem: %w", err)
+			}
+			for _, code := range ids[param.CodLinha] {
+				found[code] = &param
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("erro ao ler parametro_viagem: %w", err)
+		}
+	}
+
+	for _, codes := range ids {
+		for _, code := range codes {
+			linhaCache.Set(code, found[code], found[code] == nil)
+		}
+	}
+	for _, code := range invalidos {
+		linhaCache.Set(code, nil, true)
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return 1, nil
+}
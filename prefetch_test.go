@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var parametroViagemColumns = []string{"cod_linha", "local1", "local2", "linha", "cod_antt", "lat1", "long1", "lat2", "long2", "distancia_km", "distancia_minutos"}
+
+// TestPrefetchMasterData testa a pré-carga com uma consulta por tabela e o uso dos caches por operação
+func TestPrefetchMasterData(t *testing.T) {
+	mock := comBancoMock(t)
+
+	files := []BatchFile{
+		{Nome: "a.xml", Conteudo: []byte(btcXML("1", "951716",
+			operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"),
+			operacaoXML("1001", "1001", "200", "2024-01-15 10:00:00", "2024-01-15 11:00:00"),
+			operacaoXML("1002", "2002", "300", "2024-01-15 12:00:00", "2024-01-15 13:00:00")))},
+		{Nome: "b.xml", Conteudo: []byte(btcXML("2", "951717",
+			operacaoXML("1003", "1001", "400", "2024-01-15 14:00:00", "2024-01-15 15:00:00")))},
+	}
+
+	// Sem ordem garantida nos argumentos: conferir apenas que há uma consulta por tabela
+	mock.ExpectQuery("SELECT cod_identificador, cpf FROM pessoa WHERE cod_identificador = ANY").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"cod_identificador", "cpf"}).AddRow(951716, "123.456.789-01"))
+	mock.ExpectQuery("FROM parametro_viagem").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(parametroViagemColumns).
+			AddRow(1001, "Goiânia", "Brasília", "GOIANIA - BRASILIA", "12-0345-00", "-16.68", "-49.25", "-15.79", "-47.88", 209, 180))
+
+	result, err := ProcessBatch(files, filepath.Join(t.TempDir(), "output.csv"))
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet(), "Apenas as duas consultas da pré-carga")
+
+	require.Len(t, result.Rows, 4)
+	assert.Equal(t, "12345678901", result.Rows[0].CPFRodoviario)
+	assert.Equal(t, "1001", result.Rows[0].Linha)
+	assert.Equal(t, "", result.Rows[2].Linha, "Linha sem parâmetro")
+	assert.Equal(t, "", result.Rows[3].CPFRodoviario, "Motorista sem pessoa")
+
+	assert.Equal(t, 2, result.Tempos.Motoristas)
+	assert.Equal(t, 2, result.Tempos.Linhas)
+	assert.Equal(t, 2, result.Tempos.Consultas)
+
+	cpf, ok := cpfCache.Get("951717")
+	assert.True(t, ok, "Ausência também fica no cache")
+	assert.Equal(t, "", cpf)
+	param, ok := linhaCache.Get("2002")
+	assert.True(t, ok)
+	assert.Nil(t, param)
+}
+
+// TestPrefetchMasterData_SkipsCached testa que códigos já em cache não são consultados de novo
+func TestPrefetchMasterData_SkipsCached(t *testing.T) {
+	mock := comBancoMock(t)
+
+	cpfCache.Set("951716", "123.456.789-01", false)
+	linhaCache.Set("abc", nil, true)
+
+	var btcs Btcs
+	btcs.Btc = []Btc{{Matdmtu: "951716", Operacoes: Operacoes{Operacao: []Operacao{{Linha: "1001"}, {Linha: "xyz"}}}}}
+
+	mock.ExpectQuery("FROM parametro_viagem").WithArgs("{1001}").
+		WillReturnRows(sqlmock.NewRows(parametroViagemColumns))
+
+	var timing BatchTiming
+	prefetchMasterData(context.Background(), []parsedFile{{Btcs: btcs}}, &timing)
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 1, timing.Consultas)
+
+	assert.True(t, linhaCache.Contains("xyz"), "Código não numérico fica no cache sem consulta")
+}
+
+// TestPrefetchMasterData_WithoutDB testa que sem banco a pré-carga só conta os códigos
+func TestPrefetchMasterData_WithoutDB(t *testing.T) {
+	semBanco(t)
+
+	var btcs Btcs
+	btcs.Btc = []Btc{{Matdmtu: "951716", Operacoes: Operacoes{Operacao: []Operacao{{Linha: "1001"}}}}}
+
+	var timing BatchTiming
+	prefetchMasterData(context.Background(), []parsedFile{{Btcs: btcs}}, &timing)
+	assert.Equal(t, BatchTiming{Motoristas: 1, Linhas: 1, PrefetchMs: timing.PrefetchMs}, timing)
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/csv"
 	"os"
 	"strconv"
-	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,21 +12,13 @@ import (
 // TestProcessXML_ValidXML testa processamento de XML válido
 func TestProcessXML_ValidXML(t *testing.T) {
 	// Limpar cache de CPF e mockar banco para não falhar
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
+	cpfCache.Clear()
 
 	// Mockar banco para não tentar conectar
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-	dbPool = nil
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
+	originalDBConn := dbConn
+	dbConn = newConnManager(cfg.DB, openDatabase)
 	defer func() {
-		dbPool = originalDBPool
-		dbPoolOnce = originalDBPoolOnce
-		dbPoolInitErr = originalDBPoolInitErr
+		dbConn = originalDBConn
 	}()
 
 	// Criar arquivo XML temporário
@@ -126,20 +117,12 @@ func TestProcessXML_ValidXML(t *testing.T) {
 
 // TestProcessXML_SentidoAlternado testa alternância de sentido
 func TestProcessXML_SentidoAlternado(t *testing.T) {
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
-
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-	dbPool = nil
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
+	cpfCache.Clear()
+
+	originalDBConn := dbConn
+	dbConn = newConnManager(cfg.DB, openDatabase)
 	defer func() {
-		dbPool = originalDBPool
-		dbPoolOnce = originalDBPoolOnce
-		dbPoolInitErr = originalDBPoolInitErr
+		dbConn = originalDBConn
 	}()
 
 	xmlContent := `<?xml version="1.0" encoding="UTF-8"?>
@@ -209,20 +192,12 @@ func TestProcessXML_SentidoAlternado(t *testing.T) {
 
 // TestProcessXML_Coordenadas testa preenchimento de coordenadas
 func TestProcessXML_Coordenadas(t *testing.T) {
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
-
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-	dbPool = nil
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
+	cpfCache.Clear()
+
+	originalDBConn := dbConn
+	dbConn = newConnManager(cfg.DB, openDatabase)
 	defer func() {
-		dbPool = originalDBPool
-		dbPoolOnce = originalDBPoolOnce
-		dbPoolInitErr = originalDBPoolInitErr
+		dbConn = originalDBConn
 	}()
 
 	xmlContent := `<?xml version="1.0" encoding="UTF-8"?>
@@ -285,20 +260,12 @@ func TestProcessXML_Coordenadas(t *testing.T) {
 
 // TestProcessXML_PrefixoANTT testa formatação do prefixo ANTT
 func TestProcessXML_PrefixoANTT(t *testing.T) {
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
-
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-	dbPool = nil
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
+	cpfCache.Clear()
+
+	originalDBConn := dbConn
+	dbConn = newConnManager(cfg.DB, openDatabase)
 	defer func() {
-		dbPool = originalDBPool
-		dbPoolOnce = originalDBPoolOnce
-		dbPoolInitErr = originalDBPoolInitErr
+		dbConn = originalDBConn
 	}()
 
 	xmlContent := `<?xml version="1.0" encoding="UTF-8"?>
@@ -356,20 +323,12 @@ func TestProcessXML_PrefixoANTT(t *testing.T) {
 
 // TestProcessXML_PlacaVeiculo testa busca de placa do veículo
 func TestProcessXML_PlacaVeiculo(t *testing.T) {
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
-
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-	dbPool = nil
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
+	cpfCache.Clear()
+
+	originalDBConn := dbConn
+	dbConn = newConnManager(cfg.DB, openDatabase)
 	defer func() {
-		dbPool = originalDBPool
-		dbPoolOnce = originalDBPoolOnce
-		dbPoolInitErr = originalDBPoolInitErr
+		dbConn = originalDBConn
 	}()
 
 	xmlContent := `<?xml version="1.0" encoding="UTF-8"?>
@@ -451,20 +410,12 @@ func TestProcessXML_InvalidXML(t *testing.T) {
 
 // TestProcessXML_TimeLimitation testa limitação de tempo máximo
 func TestProcessXML_TimeLimitation(t *testing.T) {
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
-
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-	dbPool = nil
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
+	cpfCache.Clear()
+
+	originalDBConn := dbConn
+	dbConn = newConnManager(cfg.DB, openDatabase)
 	defer func() {
-		dbPool = originalDBPool
-		dbPoolOnce = originalDBPoolOnce
-		dbPoolInitErr = originalDBPoolInitErr
+		dbConn = originalDBConn
 	}()
 
 	// XML com tempo suspeito (8 horas - turno não invertido)
@@ -531,20 +482,12 @@ func TestProcessXML_TimeLimitation(t *testing.T) {
 
 // TestProcessXML_ZeroTime testa caso de tempo zero
 func TestProcessXML_ZeroTime(t *testing.T) {
-	cpfCacheLock.Lock()
-	cpfCache = make(map[string]string)
-	cpfCacheLock.Unlock()
-
-	originalDBPool := dbPool
-	originalDBPoolOnce := dbPoolOnce
-	originalDBPoolInitErr := dbPoolInitErr
-	dbPool = nil
-	dbPoolOnce = sync.Once{}
-	dbPoolInitErr = nil
+	cpfCache.Clear()
+
+	originalDBConn := dbConn
+	dbConn = newConnManager(cfg.DB, openDatabase)
 	defer func() {
-		dbPool = originalDBPool
-		dbPoolOnce = originalDBPoolOnce
-		dbPoolInitErr = originalDBPoolInitErr
+		dbConn = originalDBConn
 	}()
 
 	// XML com tempo zero (mesma data/hora início e fim)
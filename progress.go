@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jobProgressInterval é o intervalo entre as gravações do progresso de um job em execução
+const jobProgressInterval = time.Second
+
+// jobEventsInterval é o intervalo entre as consultas de GET /jobs/:id/events
+var jobEventsInterval = time.Second
+
+// jobEventsKeepAlive é o silêncio máximo do fluxo SSE; proxies fecham conexões ociosas
+const jobEventsKeepAlive = 15 * time.Second
+
+// JobProgresso é o andamento de um lote: o que já foi lido, enriquecido e escrito
+type JobProgresso struct {
+	// Fase é a etapa atual: parse, enrich ou write
+	Fase                  string   `json:"fase"`
+	Arquivos              int      `json:"arquivos"`
+	Btcs                  int      `json:"btcs"`
+	Operacoes             int      `json:"operacoes"`
+	OperacoesEnriquecidas int      `json:"operacoes_enriquecidas"`
+	LinhasEscritas        int      `json:"linhas_escritas"`
+	Avisos                []string `json:"avisos"`
+}
+
+// batchProgress acumula o progresso de um lote; os métodos aceitam receptor nil
+// (lote sem acompanhamento, como /upload e a linha de comando)
+type batchProgress struct {
+	mu     sync.Mutex
+	estado JobProgresso
+	// versao muda a cada alteração, para gravar apenas quando houver novidade
+	versao int
+}
+
+type batchProgressKey struct{}
+
+// withBatchProgress devolve um contexto que acumula o progresso do lote
+func withBatchProgress(ctx context.Context) (context.Context, *batchProgress) {
+	p := &batchProgress{estado: JobProgresso{Avisos: []string{}}}
+	return context.WithValue(ctx, batchProgressKey{}, p), p
+}
+
+// progressFrom devolve o progresso acumulado no contexto (nil se não houver)
+func progressFrom(ctx context.Context) *batchProgress {
+	p, _ := ctx.Value(batchProgressKey{}).(*batchProgress)
+	return p
+}
+
+func (p *batchProgress) update(f func(e *JobProgresso)) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f(&p.estado)
+	p.versao++
+}
+
+func (p *batchProgress) fase(fase string) {
+	p.update(func(e *JobProgresso) { e.Fase = fase })
+}
+
+// lidos registra o resultado da leitura: arquivos, BTCs e operações a enriquecer
+func (p *batchProgress) lidos(result *BatchResult, operacoes int) {
+	p.update(func(e *JobProgresso) {
+		e.Arquivos, e.Operacoes = len(result.Arquivos), operacoes
+		for _, arquivo := range result.Arquivos {
+			e.Btcs += arquivo.Btcs
+			if arquivo.Erro != "" {
+				e.Avisos = append(e.Avisos, fmt.Sprintf("%s: %s", arquivo.Arquivo, arquivo.Erro))
+			}
+		}
+	})
+}
+
+func (p *batchProgress) enriquecida() {
+	p.update(func(e *JobProgresso) { e.OperacoesEnriquecidas++ })
+}
+
+func (p *batchProgress) escritas(linhas int) {
+	p.update(func(e *JobProgresso) { e.LinhasEscritas = linhas })
+}
+
+func (p *batchProgress) aviso(format string, args ...interface{}) {
+	p.update(func(e *JobProgresso) { e.Avisos = append(e.Avisos, fmt.Sprintf(format, args...)) })
+}
+
+// snapshot devolve uma cópia do progresso e a versão correspondente
+func (p *batchProgress) snapshot() (JobProgresso, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.estado
+	e.Avisos = append([]string{}, e.Avisos...)
+	return e, p.versao
+}
+
+// saveJobProgress grava o progresso do job, se a reserva ainda é deste worker
+func saveJobProgress(ctx context.Context, db *sql.DB, id int64, worker string, progresso JobProgresso) error {
+	data, err := json.Marshal(progresso)
+	if err != nil {
+		return err
+	}
+	return updateOwnedJob(ctx, db, `
+		UPDATE job SET progresso = $3 WHERE id = $1 AND worker = $2 AND estado = 'executando'
+	`, id, worker, data)
+}
+
+// reportProgress grava o progresso do job a cada jobProgressInterval quando ele muda.
+// A função devolvida interrompe a gravação, registrando o último estado.
+func (w *jobWorker) reportProgress(ctx context.Context, db *sql.DB, id int64, p *batchProgress) func() {
+	gravada := 0
+	grava := func() {
+		progresso, versao := p.snapshot()
+		if versao == gravada || ctx.Err() != nil {
+			return
+		}
+		if err := saveJobProgress(ctx, db, id, w.nome, progresso); err != nil && !errors.Is(err, errJobPerdido) && ctx.Err() == nil {
+			slog.WarnContext(ctx, "erro ao gravar o progresso do job", "job", id, "erro", err)
+			return
+		}
+		gravada = versao
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(jobProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				grava()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+		grava()
+	}
+}
+
+// jobFinalEvent é o evento final do fluxo: contagens, avisos e o link do resultado, como nos webhooks
+func jobFinalEvent(job *Job) *WebhookNotificacao {
+	var result *BatchResult
+	if len(job.Resumo) > 0 {
+		var r BatchResult
+		if json.Unmarshal(job.Resumo, &r) == nil {
+			result = &r
+		}
+	}
+	var erro error
+	if job.Erro != "" {
+		erro = errors.New(job.Erro)
+	}
+	n := newWebhookNotificacao(job, job.Estado, result, erro)
+	if job.ConcluidoEm != nil {
+		n.ConcluidoEm = job.ConcluidoEm.UTC()
+	}
+	return n
+}
+
+// jobEventsHandler transmite o andamento do job por Server-Sent Events: "estado" quando o job
+// muda de estado ou de tentativa, "progresso" durante a conversão e, por fim, "concluido" ou
+// "falhou" com o link do resultado. Um job já terminado recebe apenas o evento final.
+func jobEventsHandler(c *gin.Context) {
+	job, db, ok := loadJobFor(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	// Sem buffer no nginx, para os eventos chegarem enquanto o job executa
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	ultimoEstado, ultimaTentativa, ultimoProgresso := "", -1, ""
+	ultimoEnvio := time.Now()
+	ticker := time.NewTicker(jobEventsInterval)
+	defer ticker.Stop()
+
+	for {
+		terminado := job.Estado == jobConcluido || job.Estado == jobFalhou
+		if terminado {
+			c.SSEvent(job.Estado, jobFinalEvent(job))
+			c.Writer.Flush()
+			return
+		}
+
+		enviou := false
+		if job.Estado != ultimoEstado || job.Tentativas != ultimaTentativa {
+			c.SSEvent("estado", gin.H{"estado": job.Estado, "tentativas": job.Tentativas, "erro": job.Erro})
+			ultimoEstado, ultimaTentativa, enviou = job.Estado, job.Tentativas, true
+		}
+		if progresso := string(job.Progresso); progresso != "" && progresso != ultimoProgresso {
+			c.SSEvent("progresso", job.Progresso)
+			ultimoProgresso, enviou = progresso, true
+		}
+		if !enviou && time.Since(ultimoEnvio) >= jobEventsKeepAlive {
+			c.Writer.WriteString(": ping\n\n")
+			enviou = true
+		}
+		if enviou {
+			ultimoEnvio = time.Now()
+			c.Writer.Flush()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		atual, err := getJob(ctx, db, job.ID)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, errJobNaoEncontrado):
+			c.SSEvent("erro", gin.H{"error": errJobNaoEncontrado.Error()})
+			c.Writer.Flush()
+			return
+		case err != nil:
+			// Falha passageira do banco: tentar de novo na próxima volta
+			slog.WarnContext(ctx, "erro ao consultar job para o fluxo de eventos", "job", job.ID, "erro", err)
+		default:
+			job = atual
+		}
+	}
+}
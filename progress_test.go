@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBatchProgress testa o progresso acumulado nas fases do lote e o receptor nil
+func TestBatchProgress(t *testing.T) {
+	dadosLocaisDeTeste(t)
+	files := []BatchFile{
+		{Nome: "a.xml", Conteudo: []byte(btcXML("1", "951716",
+			operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"),
+			operacaoXML("1002", "1001", "200", "2024-01-15 10:00:00", "2024-01-15 11:00:00")))},
+		{Nome: "quebrado.xml", Conteudo: []byte("<btcs><btc>")},
+	}
+
+	ctx, progresso := withBatchProgress(context.Background())
+	result, err := ProcessBatchContext(ctx, files, "", BatchOptions{Degradado: degradadoFalhar})
+	require.NoError(t, err)
+
+	estado, _ := progresso.snapshot()
+	assert.Equal(t, faseEnriquecimento, estado.Fase)
+	assert.Equal(t, 2, estado.Arquivos)
+	assert.Equal(t, 1, estado.Btcs)
+	assert.Equal(t, 2, estado.Operacoes)
+	assert.Equal(t, 2, estado.OperacoesEnriquecidas)
+	assert.Zero(t, estado.LinhasEscritas)
+	require.Len(t, estado.Avisos, 1)
+	assert.Contains(t, estado.Avisos[0], "quebrado.xml: ")
+
+	layout, err := findLayout("")
+	require.NoError(t, err)
+	require.NoError(t, writeBatchOutput(ctx, io.Discard, formatCSV, layout, result))
+	estado, versao := progresso.snapshot()
+	assert.Equal(t, faseEscrita, estado.Fase)
+	assert.Equal(t, 2, estado.LinhasEscritas)
+
+	estado.Avisos[0] = "alterado"
+	copia, mesmaVersao := progresso.snapshot()
+	assert.Equal(t, versao, mesmaVersao)
+	assert.NotEqual(t, "alterado", copia.Avisos[0], "snapshot devolve uma cópia")
+
+	assert.NotPanics(t, func() { progressFrom(context.Background()).enriquecida() })
+}
+
+// TestReportProgress testa a gravação do progresso apenas quando ele muda
+func TestReportProgress(t *testing.T) {
+	mock := comBancoMock(t)
+	db, err := getDBConnection(context.Background())
+	require.NoError(t, err)
+	w := workerDeTeste()
+
+	ctx, progresso := withBatchProgress(context.Background())
+	parar := w.reportProgress(ctx, db, 7, progresso)
+	parar()
+
+	progresso.fase(faseEnriquecimento)
+	progresso.enriquecida()
+	mock.ExpectExec("UPDATE job SET progresso").
+		WithArgs(int64(7), "teste-1", []byte(`{"fase":"enrich","arquivos":0,"btcs":0,"operacoes":0,"operacoes_enriquecidas":1,"linhas_escritas":0,"avisos":[]}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	parar = w.reportProgress(ctx, db, 7, progresso)
+	parar()
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "Sem novidade não há gravação; a última alteração é gravada ao parar")
+}
+
+// TestJobEventsHandler testa os eventos de estado e progresso e o evento final com o link do resultado
+func TestJobEventsHandler(t *testing.T) {
+	mock := comBancoMock(t)
+	comConfig(t, func(c *Config) { c.Auth.JWTSecret = "segredo" })
+	original := jobEventsInterval
+	jobEventsInterval = time.Millisecond
+	t.Cleanup(func() { jobEventsInterval = original })
+	router := newRouter()
+
+	criadoEm := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	linhaJob := func(estado string, tentativas int, resumo, progresso interface{}) *sqlmock.Rows {
+		return sqlmock.NewRows(colunasJob).AddRow(42, estado, formatCSV, `{"layout":"antt","banco_indisponivel":"falhar"}`,
+			"integracao", "req-1", tentativas, 5, criadoEm, nil, resumo, progresso, criadoEm, nil, criadoEm)
+	}
+	mock.ExpectQuery("FROM job WHERE id").WithArgs(int64(42)).WillReturnRows(linhaJob(jobPendente, 0, nil, nil))
+	mock.ExpectQuery("FROM job WHERE id").WithArgs(int64(42)).
+		WillReturnRows(linhaJob(jobExecutando, 1, nil, `{"fase":"enrich","operacoes":10,"operacoes_enriquecidas":4}`))
+	mock.ExpectQuery("FROM job WHERE id").WithArgs(int64(42)).
+		WillReturnRows(linhaJob(jobExecutando, 1, nil, `{"fase":"enrich","operacoes":10,"operacoes_enriquecidas":4}`))
+	mock.ExpectQuery("FROM job WHERE id").WithArgs(int64(42)).
+		WillReturnRows(linhaJob(jobExecutando, 1, nil, `{"fase":"write","operacoes":10,"operacoes_enriquecidas":10,"linhas_escritas":10}`))
+	mock.ExpectQuery("FROM job WHERE id").WithArgs(int64(42)).
+		WillReturnRows(linhaJob(jobConcluido, 1, `{"linhas":10,"arquivos":[{"arquivo":"a.xml","operacoes":10}]}`, nil))
+
+	credencial := "Bearer " + signJWT(t, `{"alg":"HS256"}`, map[string]interface{}{"sub": "integracao", "papeis": []string{roleUploader}, "exp": time.Now().Add(time.Hour).Unix()}, "segredo")
+	req, _ := http.NewRequest("GET", "/jobs/42/events", nil)
+	req.Header.Set("Authorization", credencial)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/event-stream")
+
+	var eventos []string
+	var final map[string]interface{}
+	for _, bloco := range strings.Split(strings.TrimSpace(w.Body.String()), "\n\n") {
+		evento, dados, _ := strings.Cut(bloco, "\n")
+		eventos = append(eventos, strings.TrimPrefix(evento, "event:"))
+		if evento == "event:"+jobConcluido {
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(dados, "data:")), &final))
+		}
+	}
+	assert.Equal(t, []string{"estado", "estado", "progresso", "progresso", jobConcluido}, eventos, "Progresso repetido não é reenviado")
+	assert.Equal(t, "/jobs/42/resultado", final["resultado_url"])
+	assert.Equal(t, 10.0, final["linhas"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
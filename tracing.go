@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exportadores de spans aceitos em tracing.exporter (OTEL_TRACES_EXPORTER)
+const (
+	tracingNone   = "none"
+	tracingOTLP   = "otlp"
+	tracingStdout = "stdout"
+	// tracingConsole é o nome usado pela especificação do OpenTelemetry para o mesmo exportador
+	tracingConsole = "console"
+)
+
+// tracerName identifica os spans criados pela aplicação
+const tracerName = "btc-api"
+
+// tracer devolve o tracer do provedor instalado; até setupTracing, os spans não são registrados
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// stdoutTraces é onde o exportador stdout escreve (substituído nos testes)
+var stdoutTraces io.Writer = os.Stdout
+
+// setupTracing instala o provedor de spans com o exportador configurado e a propagação W3C
+// (traceparent), devolvendo a função que descarrega os spans pendentes ao encerrar
+func setupTracing(ctx context.Context, c TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch c.Exporter {
+	case tracingNone, "":
+		return func(context.Context) error { return nil }, nil
+	case tracingStdout, tracingConsole:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(stdoutTraces))
+	case tracingOTLP:
+		// Sem endpoint no arquivo, o exportador lê OTEL_EXPORTER_OTLP_(TRACES_)ENDPOINT e OTEL_EXPORTER_OTLP_HEADERS
+		var opts []otlptracehttp.Option
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(c.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		err = fmt.Errorf("exportador desconhecido: %q", c.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar exportador de spans: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(c.ServiceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao descrever o serviço para os spans: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware cria o span de cada requisição, continuando o traceparent recebido,
+// e o marca com o X-Request-ID; as sondas de saúde e a coleta de /metrics não são rastreadas
+func tracingMiddleware() gin.HandlersChain {
+	rastrear := func(r *http.Request) bool {
+		return r.URL.Path != "/metrics" && !strings.HasPrefix(r.URL.Path, "/health")
+	}
+	return gin.HandlersChain{
+		otelgin.Middleware(cfg.Tracing.ServiceName, otelgin.WithFilter(rastrear)),
+		func(c *gin.Context) {
+			trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("btc.request_id", c.GetString(ctxRequestID)))
+			c.Next()
+		},
+	}
+}
+
+// phase é o span de uma fase do processamento de um lote (parse, enrich, write)
+type phase struct {
+	span   trace.Span
+	fase   string
+	inicio time.Time
+}
+
+// startPhase inicia o span da fase; End encerra o span e registra a duração em
+// btc_processing_phase_duration_seconds
+func startPhase(ctx context.Context, fase string, attrs ...attribute.KeyValue) (context.Context, *phase) {
+	ctx, span := tracer().Start(ctx, "lote."+fase, trace.WithAttributes(attrs...))
+	progressFrom(ctx).fase(fase)
+	return ctx, &phase{span: span, fase: fase, inicio: time.Now()}
+}
+
+// End encerra a fase, marcando o span com o erro quando houver
+func (p *phase) End(err error, attrs ...attribute.KeyValue) {
+	observePhase(p.fase, p.inicio)
+	p.span.SetAttributes(attrs...)
+	if err != nil {
+		p.span.RecordError(err)
+		p.span.SetStatus(codes.Error, err.Error())
+	}
+	p.span.End()
+}
+
+// cacheUsage conta os acertos e falhas dos caches durante um lote, para os atributos do span
+// de enriquecimento (as estatísticas globais misturam as requisições simultâneas)
+type cacheUsage struct {
+	cpfHits, cpfMisses     atomic.Int64
+	linhaHits, linhaMisses atomic.Int64
+}
+
+type cacheUsageKey struct{}
+
+// withCacheUsage devolve um contexto que acumula o uso dos caches
+func withCacheUsage(ctx context.Context) (context.Context, *cacheUsage) {
+	u := &cacheUsage{}
+	return context.WithValue(ctx, cacheUsageKey{}, u), u
+}
+
+// countCacheLookup registra uma consulta ao cache (cpf ou linha) no contexto, se ele acumula o uso
+func countCacheLookup(ctx context.Context, cache string, hit bool) {
+	u, _ := ctx.Value(cacheUsageKey{}).(*cacheUsage)
+	if u == nil {
+		return
+	}
+	switch {
+	case cache == "cpf" && hit:
+		u.cpfHits.Add(1)
+	case cache == "cpf":
+		u.cpfMisses.Add(1)
+	case hit:
+		u.linhaHits.Add(1)
+	default:
+		u.linhaMisses.Add(1)
+	}
+}
+
+// attributes descreve o uso dos caches para o span
+func (u *cacheUsage) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("btc.cache.cpf.hits", u.cpfHits.Load()),
+		attribute.Int64("btc.cache.cpf.misses", u.cpfMisses.Load()),
+		attribute.Int64("btc.cache.linha.hits", u.linhaHits.Load()),
+		attribute.Int64("btc.cache.linha.misses", u.linhaMisses.Load()),
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// gravaSpans instala um provedor que guarda os spans em memória durante o teste
+func gravaSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return rec
+}
+
+// spansPorNome indexa os spans encerrados pelo nome
+func spansPorNome(rec *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	out := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range rec.Ended() {
+		out[s.Name()] = s
+	}
+	return out
+}
+
+// atributos devolve os atributos de um span como mapa
+func atributos(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	out := make(map[attribute.Key]attribute.Value)
+	for _, kv := range s.Attributes() {
+		out[kv.Key] = kv.Value
+	}
+	return out
+}
+
+// TestProcessBatch_Spans testa os spans do lote e de cada fase, com tamanho, operações e uso dos caches
+func TestProcessBatch_Spans(t *testing.T) {
+	semCadastro(t, "1001")
+	cpfCache.Set("951716", "12345678901", false)
+	rec := gravaSpans(t)
+
+	conteudo := []byte(btcXML("1", "951716",
+		operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"),
+		operacaoXML("1002", "1001", "200", "2024-01-15 10:00:00", "2024-01-15 11:00:00")))
+	csvPath := filepath.Join(t.TempDir(), "output.csv")
+	_, err := ProcessBatchContext(context.Background(), []BatchFile{{Nome: "garagem.xml", Conteudo: conteudo}}, csvPath, BatchOptions{Degradado: degradadoMarcar})
+	require.NoError(t, err)
+
+	spans := spansPorNome(rec)
+	require.Contains(t, spans, "lote")
+	lote := spans["lote"]
+	assert.Equal(t, int64(len(conteudo)), atributos(lote)["btc.bytes"].AsInt64())
+	assert.Equal(t, int64(2), atributos(lote)["btc.linhas"].AsInt64())
+
+	for _, nome := range []string{"lote.parse", "lote.enrich", "lote.write"} {
+		require.Contains(t, spans, nome)
+		assert.Equal(t, lote.SpanContext().SpanID(), spans[nome].Parent().SpanID(), nome)
+	}
+	assert.Equal(t, int64(2), atributos(spans["lote.parse"])["btc.operacoes"].AsInt64())
+
+	enrich := atributos(spans["lote.enrich"])
+	assert.Equal(t, int64(2), enrich["btc.operacoes"].AsInt64())
+	assert.Equal(t, int64(2), enrich["btc.cache.linha.hits"].AsInt64(), "Linha 1001 já está no cache como inexistente")
+	assert.Equal(t, int64(2), enrich["btc.cache.cpf.hits"].AsInt64())
+	assert.Equal(t, int64(0), enrich["btc.cache.cpf.misses"].AsInt64())
+	assert.Equal(t, "antt", atributos(spans["lote.write"])["btc.layout"].AsString())
+}
+
+// TestTracingMiddleware testa o span da requisição continuando o traceparent recebido
+func TestTracingMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := gravaSpans(t)
+
+	router := gin.New()
+	router.Use(requestIDMiddleware())
+	router.Use(tracingMiddleware()...)
+	router.GET("/layouts/:nome", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.GET("/health/live", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req, _ := http.NewRequest("GET", "/layouts/antt", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(headerRequestID, "req-42")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("GET", "/health/live", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	ended := rec.Ended()
+	require.Len(t, ended, 1, "Sondas de saúde não são rastreadas")
+	assert.Equal(t, "/layouts/:nome", ended[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", ended[0].SpanContext().TraceID().String())
+	assert.Equal(t, "req-42", atributos(ended[0])["btc.request_id"].AsString())
+}
+
+// TestSetupTracing_Stdout testa o exportador local e o trace_id nos logs
+func TestSetupTracing_Stdout(t *testing.T) {
+	var buf bytes.Buffer
+	original := stdoutTraces
+	stdoutTraces = &buf
+	t.Cleanup(func() {
+		stdoutTraces = original
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	})
+	logs := capturaLogs(t)
+
+	shutdown, err := setupTracing(context.Background(), TracingConfig{Exporter: tracingStdout, ServiceName: "btc-teste", SampleRatio: 1})
+	require.NoError(t, err)
+
+	ctx, span := tracer().Start(context.Background(), "lote.parse")
+	slog.InfoContext(ctx, "dentro do span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	assert.Contains(t, buf.String(), `"Name":"lote.parse"`)
+	assert.Contains(t, buf.String(), "btc-teste")
+	assert.Contains(t, logs.String(), `"trace_id":"`+span.SpanContext().TraceID().String()+`"`)
+}
+
+// TestSetupTracing_None testa que sem exportador nada é instalado
+func TestSetupTracing_None(t *testing.T) {
+	shutdown, err := setupTracing(context.Background(), TracingConfig{Exporter: tracingNone})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = setupTracing(context.Background(), TracingConfig{Exporter: "jaeger"})
+	assert.ErrorContains(t, err, "exportador desconhecido")
+}
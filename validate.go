@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// duracaoMaximaViagem é a duração acima da qual uma operação é considerada anômala
+const duracaoMaximaViagem = 3 * time.Hour
+
+// ValidationIssue aponta um problema encontrado em uma operação do BTC
+type ValidationIssue struct {
+	Arquivo string `json:"arquivo"`
+	Doc     string `json:"doc"`
+	Indice  int    `json:"indice"`
+	Valor   string `json:"valor"`
+	Detalhe string `json:"detalhe,omitempty"`
+}
+
+// ValidationReport é o resultado da validação de um lote sem geração do CSV
+type ValidationReport struct {
+	Valido bool `json:"valido"`
+	// BancoDisponivel fica ausente quando CPFs e linhas vêm de arquivos e o banco não é consultado
+	BancoDisponivel *bool `json:"banco_disponivel,omitempty"`
+	// FonteDados indica de onde vieram CPFs e linhas: "banco" ou "arquivos" (linha de comando)
+	FonteDados string        `json:"fonte_dados"`
+	Operacoes  int           `json:"operacoes"`
+	Arquivos   []FileSummary `json:"arquivos"`
+	// ContagemPorLinha conta as operações por código de linha e sentido
+	ContagemPorLinha      map[string]map[string]int `json:"contagem_por_linha"`
+	CPFsAusentes          []ValidationIssue         `json:"cpfs_ausentes"`
+	VeiculosDesconhecidos []ValidationIssue         `json:"veiculos_desconhecidos"`
+	LinhasSemParametro    []ValidationIssue         `json:"linhas_sem_parametro"`
+	// NaoVerificados são os CPFs e linhas cuja consulta falhou no banco: não se sabe se existem
+	NaoVerificados     []ValidationIssue `json:"nao_verificados"`
+	TiposDesconhecidos []ValidationIssue `json:"tipos_desconhecidos"`
+	AnomaliasHorario   []ValidationIssue `json:"anomalias_horario"`
+	Duplicados         *DuplicateReport  `json:"duplicados,omitempty"`
+	Tempos             BatchTiming       `json:"tempos"`
+}
+
+// ValidateBatch executa a mesma leitura e enriquecimento de ProcessBatch sem gravar arquivo de saída
+func ValidateBatch(files []BatchFile) (*ValidationReport, error) {
+	return ValidateBatchContext(context.Background(), files)
+}
+
+// ValidateBatchContext é ValidateBatch com as consultas ao banco ligadas a ctx
+func ValidateBatchContext(ctx context.Context, files []BatchFile) (*ValidationReport, error) {
+	result := &BatchResult{}
+
+	parsed, err := parseBatch(files, result)
+	if err != nil {
+		return nil, err
+	}
+
+	enriched, err := enrichBatch(ctx, parsed, result, false)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ValidationReport{
+		ContagemPorLinha:      make(map[string]map[string]int),
+		CPFsAusentes:          []ValidationIssue{},
+		VeiculosDesconhecidos: []ValidationIssue{},
+		LinhasSemParametro:    []ValidationIssue{},
+		NaoVerificados:        []ValidationIssue{},
+		TiposDesconhecidos:    []ValidationIssue{},
+		AnomaliasHorario:      []ValidationIssue{},
+		Tempos:                result.Tempos,
+		FonteDados:            masterDataSource(),
+	}
+	bancoDisponivel := false
+	if !usingLocalMasterData() {
+		_, dbErr := getDBConnection(ctx)
+		bancoDisponivel = dbErr == nil
+		report.BancoDisponivel = &bancoDisponivel
+	}
+
+	if bancoDisponivel {
+		duplicados, err := checkDuplicates(ctx, parsed)
+		if err != nil {
+			slog.WarnContext(ctx, "não foi possível verificar uploads repetidos", "erro", err)
+		} else if !duplicados.Empty() {
+			report.Duplicados = duplicados
+		}
+	}
+
+	for _, e := range enriched {
+		issue := ValidationIssue{Arquivo: e.Arquivo, Doc: e.Doc, Indice: e.Indice}
+		report.Operacoes++
+
+		if e.Err != nil {
+			issue.Valor = fmt.Sprintf("%s - %s", e.Operacao.Datainicio, e.Operacao.Datafim)
+			issue.Detalhe = fmt.Sprintf("data inválida: %v", e.Err)
+			report.AnomaliasHorario = append(report.AnomaliasHorario, issue)
+			continue
+		}
+
+		if report.ContagemPorLinha[e.Operacao.Linha] == nil {
+			report.ContagemPorLinha[e.Operacao.Linha] = make(map[string]int)
+		}
+		report.ContagemPorLinha[e.Operacao.Linha][e.Dados.Sentido]++
+
+		// Consultas que falharam no banco não dizem se o CPF ou a linha existem
+		naoVerificado := make(map[string]bool)
+		for _, errBanco := range e.Info.ErrosBanco {
+			i := issue
+			i.Detalhe = errBanco.Error()
+			var enrichErr *EnrichmentError
+			if errors.As(errBanco, &enrichErr) {
+				naoVerificado[enrichErr.Campo] = true
+				i.Valor = enrichErr.Codigo
+			}
+			report.NaoVerificados = append(report.NaoVerificados, i)
+			bancoDisponivel = false
+		}
+
+		if !e.Info.CPFEncontrado && !naoVerificado["cpf"] {
+			i := issue
+			i.Valor = e.Matdmtu
+			if i.Valor == "" {
+				i.Detalhe = "btc sem matdmtu"
+			}
+			report.CPFsAusentes = append(report.CPFsAusentes, i)
+		}
+
+		if !e.Info.VeiculoEncontrado {
+			i := issue
+			i.Valor = e.Operacao.Veiculo
+			report.VeiculosDesconhecidos = append(report.VeiculosDesconhecidos, i)
+		}
+
+		if !e.Info.LinhaEncontrada && !naoVerificado["linha"] {
+			i := issue
+			i.Valor = e.Operacao.Linha
+			report.LinhasSemParametro = append(report.LinhasSemParametro, i)
+		}
+
+		for _, tipo := range e.Info.TiposDesconhecidos {
+			i := issue
+			i.Valor = tipo
+			report.TiposDesconhecidos = append(report.TiposDesconhecidos, i)
+		}
+
+		if anomalia := timeAnomaly(e.Info.Duracao); anomalia != "" {
+			i := issue
+			i.Valor = fmt.Sprintf("%s - %s", e.Operacao.Datainicio, e.Operacao.Datafim)
+			i.Detalhe = anomalia
+			report.AnomaliasHorario = append(report.AnomaliasHorario, i)
+		}
+	}
+
+	report.Arquivos = result.Arquivos
+	report.Valido = len(report.CPFsAusentes) == 0 &&
+		len(report.VeiculosDesconhecidos) == 0 &&
+		len(report.LinhasSemParametro) == 0 &&
+		len(report.NaoVerificados) == 0 &&
+		len(report.TiposDesconhecidos) == 0 &&
+		len(report.AnomaliasHorario) == 0 &&
+		report.Duplicados == nil
+
+	return report, nil
+}
+
+// timeAnomaly descreve problemas na duração de uma operação
+func timeAnomaly(duracao time.Duration) string {
+	switch {
+	case duracao < 0:
+		return "datafim anterior a datainicio"
+	case duracao == 0:
+		return "duração zero"
+	case duracao > duracaoMaximaViagem:
+		return fmt.Sprintf("duração acima de %s", duracaoMaximaViagem)
+	}
+	return ""
+}
+
+// validateHandler valida um ou mais arquivos de BTC e devolve o relatório em JSON, sem gerar o CSV
+func validateHandler(c *gin.Context) {
+	files, ok := collectUploadFiles(c)
+	if !ok {
+		return
+	}
+
+	report, err := ValidateBatchContext(c.Request.Context(), files)
+	if errors.Is(err, context.Canceled) {
+		slog.WarnContext(c.Request.Context(), "validação cancelada pelo cliente", "erro", err)
+		c.AbortWithStatus(statusClientClosedRequest)
+		return
+	}
+	if err != nil && respondUploadLimit(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
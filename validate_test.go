@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidateBatch_Issues testa se cada problema é apontado com o doc e o índice da operação
+func TestValidateBatch_Issues(t *testing.T) {
+	semBanco(t)
+
+	tipoDesconhecido := strings.Replace(
+		operacaoXML("1001", "1001", "300", "2024-01-15 12:00:00", "2024-01-15 13:00:00"),
+		"<tipo>4</tipo>", "<tipo>9</tipo>", 1)
+
+	conteudo := btcXML("55", "951716",
+		operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"),
+		operacaoXML("9999", "1001", "200", "2024-01-15 10:00:00", "2024-01-15 09:00:00"),
+		tipoDesconhecido,
+		operacaoXML("1001", "1001", "400", "ontem", "2024-01-15 13:00:00"),
+	)
+
+	report, err := ValidateBatch([]BatchFile{{Nome: "btc.xml", Conteudo: []byte(conteudo)}})
+	require.NoError(t, err)
+
+	assert.False(t, report.Valido)
+	require.NotNil(t, report.BancoDisponivel)
+	assert.False(t, *report.BancoDisponivel)
+	assert.Equal(t, 4, report.Operacoes)
+	assert.Equal(t, map[string]int{"GO-DF": 2, "DF-GO": 1}, report.ContagemPorLinha["1001"])
+
+	require.Len(t, report.VeiculosDesconhecidos, 1)
+	assert.Equal(t, ValidationIssue{Arquivo: "btc.xml", Doc: "55", Indice: 1, Valor: "9999"}, report.VeiculosDesconhecidos[0])
+
+	require.Len(t, report.TiposDesconhecidos, 1)
+	assert.Equal(t, 2, report.TiposDesconhecidos[0].Indice)
+	assert.Equal(t, "9", report.TiposDesconhecidos[0].Valor)
+
+	require.Len(t, report.AnomaliasHorario, 2)
+	assert.Equal(t, 1, report.AnomaliasHorario[0].Indice)
+	assert.Equal(t, "datafim anterior a datainicio", report.AnomaliasHorario[0].Detalhe)
+	assert.Equal(t, 3, report.AnomaliasHorario[1].Indice)
+	assert.Contains(t, report.AnomaliasHorario[1].Detalhe, "data inválida")
+
+	// Sem banco, CPF e parâmetros da linha não são verificados, nem dados como ausentes
+	assert.Empty(t, report.CPFsAusentes)
+	assert.Empty(t, report.LinhasSemParametro)
+	require.Len(t, report.NaoVerificados, 6)
+	assert.Equal(t, "1001", report.NaoVerificados[0].Valor)
+	assert.Contains(t, report.NaoVerificados[0].Detalhe, "linha 1001")
+	assert.Equal(t, "951716", report.NaoVerificados[1].Valor)
+	assert.Contains(t, report.NaoVerificados[1].Detalhe, "cpf 951716")
+}
+
+// TestValidateBatch_FalhaNoMeioDoLote testa que só a operação cuja consulta falhou fica como não verificada
+func TestValidateBatch_FalhaNoMeioDoLote(t *testing.T) {
+	mock := comBancoMock(t)
+	mock.ExpectQuery("FROM parametro_viagem").WillReturnError(errors.New("conexão perdida"))
+	mock.ExpectQuery("WHERE cod_linha = \\$1").WithArgs(1001).
+		WillReturnRows(sqlmock.NewRows(parametroViagemColumns).
+			AddRow(1001, "Goiânia", "Brasília", "GOIANIA - BRASILIA", "12-0345-00", "-16.68", "-49.25", "-15.79", "-47.88", 209, 180))
+	mock.ExpectQuery("WHERE cod_linha = \\$1").WithArgs(2002).WillReturnError(errors.New("conexão perdida"))
+	mock.ExpectQuery("WHERE cod_linha = \\$1").WithArgs(3003).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM upload_arquivo").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "nome", "data_ini", "data_fim", "processado_em"}))
+	mock.ExpectQuery("FROM upload_operacao").
+		WillReturnRows(sqlmock.NewRows([]string{"chave", "nome", "processado_em"}))
+
+	conteudo := btcXML("77", "",
+		operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"),
+		operacaoXML("1001", "2002", "200", "2024-01-15 10:00:00", "2024-01-15 11:00:00"),
+		operacaoXML("1001", "3003", "300", "2024-01-15 12:00:00", "2024-01-15 13:00:00"),
+	)
+
+	report, err := ValidateBatch([]BatchFile{{Nome: "btc.xml", Conteudo: []byte(conteudo)}})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.False(t, report.Valido)
+	require.NotNil(t, report.BancoDisponivel)
+	assert.False(t, *report.BancoDisponivel, "Falha de consulta no meio do lote")
+
+	require.Len(t, report.NaoVerificados, 1)
+	assert.Equal(t, 1, report.NaoVerificados[0].Indice)
+	assert.Equal(t, "2002", report.NaoVerificados[0].Valor)
+
+	require.Len(t, report.LinhasSemParametro, 1, "Só a linha consultada com sucesso é dada como ausente")
+	assert.Equal(t, "3003", report.LinhasSemParametro[0].Valor)
+}
+
+// TestValidateBatch_DadosLocais testa que banco_disponivel fica fora do relatório com dados mestres de arquivos
+func TestValidateBatch_DadosLocais(t *testing.T) {
+	semBanco(t)
+	comDadosLocais(t, &localMasterData{cpfs: map[string]string{}, linhas: map[string]*ParametroViagem{}})
+
+	conteudo := btcXML("1", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"))
+	report, err := ValidateBatch([]BatchFile{{Nome: "btc.xml", Conteudo: []byte(conteudo)}})
+	require.NoError(t, err)
+
+	assert.Nil(t, report.BancoDisponivel)
+	assert.Empty(t, report.NaoVerificados)
+	assert.Len(t, report.LinhasSemParametro, 1)
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "banco_disponivel")
+}
+
+// TestTimeAnomaly testa a classificação da duração das operações
+func TestTimeAnomaly(t *testing.T) {
+	assert.Equal(t, "", timeAnomaly(90*time.Minute))
+	assert.Equal(t, "duração zero", timeAnomaly(0))
+	assert.Equal(t, "datafim anterior a datainicio", timeAnomaly(-time.Minute))
+	assert.Contains(t, timeAnomaly(8*time.Hour), "duração acima")
+}
+
+// TestValidateHandler_NoOutputFile testa que a validação responde JSON e não grava o CSV
+func TestValidateHandler_NoOutputFile(t *testing.T) {
+	semBanco(t)
+	gin.SetMode(gin.TestMode)
+
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	router := gin.New()
+	router.POST("/validate", validateHandler)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "btc.xml")
+	part.Write([]byte(btcXML("1", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"))))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/validate", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+
+	var report ValidationReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, 1, report.Operacoes)
+	assert.NoFileExists(t, "output.csv", "Validação não deve gravar arquivo de saída")
+}
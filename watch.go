@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// watchHistorico é o arquivo, no diretório de processados, com os hashes do conteúdo já
+// convertido: um reinício (ou o mesmo arquivo copiado de novo) não gera a saída outra vez
+const watchHistorico = ".historico.jsonl"
+
+// watchOptions são as opções de "watch"
+type watchOptions struct {
+	inbox        string
+	outbox       string
+	processados  string
+	falhas       string
+	formato      string
+	layout       string
+	intervalo    time.Duration
+	estabilidade time.Duration
+	master       masterDataFlags
+}
+
+// parseWatchArgs lê as opções de "watch"; processed/ e failed/ ficam dentro da caixa de entrada por padrão
+func parseWatchArgs(args []string, stderr io.Writer) (*watchOptions, error) {
+	opts := &watchOptions{}
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.inbox, "inbox", "", "diretório monitorado (.xml, .zip ou .tar.gz)")
+	fs.StringVar(&opts.outbox, "outbox", "", "diretório das saídas convertidas")
+	fs.StringVar(&opts.processados, "processed", "", "para onde vão os arquivos convertidos (padrão: <inbox>/processed)")
+	fs.StringVar(&opts.falhas, "failed", "", "para onde vão os arquivos com erro (padrão: <inbox>/failed)")
+	fs.StringVar(&opts.formato, "format", formatCSV, "csv, json ou xlsx")
+	fs.StringVar(&opts.layout, "layout", "", "layout das colunas do CSV (padrão: antt)")
+	fs.DurationVar(&opts.intervalo, "interval", 10*time.Second, "intervalo entre as leituras da caixa de entrada")
+	fs.DurationVar(&opts.estabilidade, "settle", 30*time.Second, "tempo sem alterações antes de processar um arquivo")
+	opts.master.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("argumento inesperado: %s", fs.Arg(0))
+	}
+	if opts.inbox == "" || opts.outbox == "" {
+		return nil, fmt.Errorf("informe --inbox e --outbox")
+	}
+	if opts.processados == "" {
+		opts.processados = filepath.Join(opts.inbox, "processed")
+	}
+	if opts.falhas == "" {
+		opts.falhas = filepath.Join(opts.inbox, "failed")
+	}
+	switch opts.formato {
+	case formatCSV, formatJSON, formatXLSX:
+	default:
+		return nil, fmt.Errorf("formato não suportado: %s (use csv, json ou xlsx)", opts.formato)
+	}
+	if opts.intervalo <= 0 || opts.estabilidade < 0 {
+		return nil, fmt.Errorf("--interval deve ser positivo e --settle não pode ser negativo")
+	}
+	return opts, nil
+}
+
+// runWatchCommand executa "watch" até SIGINT/SIGTERM, terminando o arquivo em andamento
+func runWatchCommand(args []string) error {
+	opts, err := parseWatchArgs(args, os.Stderr)
+	if err != nil {
+		return err
+	}
+	shutdown, err := startCommand(opts.master)
+	if err != nil {
+		return err
+	}
+	defer shutdown(context.Background())
+
+	w, err := newWatcher(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("monitorando caixa de entrada", "inbox", opts.inbox, "outbox", opts.outbox,
+		"formato", opts.formato, "dados_mestres", masterDataSource())
+	return w.run(ctx)
+}
+
+// observacao é o estado de um arquivo na última leitura da caixa de entrada
+type observacao struct {
+	tamanho    int64
+	modificado time.Time
+}
+
+func (o observacao) igual(outra observacao) bool {
+	return o.tamanho == outra.tamanho && o.modificado.Equal(outra.modificado)
+}
+
+// watcher converte os arquivos que chegam na caixa de entrada, um de cada vez
+type watcher struct {
+	opts      *watchOptions
+	layout    *ExportLayout
+	historico *watchLedger
+	// vistos guarda a leitura anterior: um arquivo só é processado se não mudou desde ela
+	vistos map[string]observacao
+	now    func() time.Time
+}
+
+// newWatcher cria os diretórios de saída e carrega o histórico de arquivos convertidos
+func newWatcher(opts *watchOptions) (*watcher, error) {
+	layout, err := findLayout(opts.layout)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{opts.outbox, opts.processados, opts.falhas} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	historico, err := openWatchLedger(filepath.Join(opts.processados, watchHistorico))
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{
+		opts:      opts,
+		layout:    layout,
+		historico: historico,
+		vistos:    make(map[string]observacao),
+		now:       time.Now,
+	}, nil
+}
+
+// run lê a caixa de entrada a cada intervalo até ctx terminar
+func (w *watcher) run(ctx context.Context) error {
+	ticker := time.NewTicker(w.opts.intervalo)
+	defer ticker.Stop()
+	for {
+		if err := w.scan(ctx); err != nil {
+			slog.ErrorContext(ctx, "erro ao ler a caixa de entrada", "inbox", w.opts.inbox, "erro", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchSuportado indica os arquivos aceitos; ocultos e temporários de cópia são ignorados
+func watchSuportado(nome string) bool {
+	lower := strings.ToLower(nome)
+	if strings.HasPrefix(nome, ".") || strings.HasPrefix(nome, "~") {
+		return false
+	}
+	for _, ext := range []string{".xml", ".zip", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// scan processa os arquivos que ficaram iguais desde a leitura anterior e sem
+// modificação há pelo menos --settle (a cópia terminou)
+func (w *watcher) scan(ctx context.Context) error {
+	entradas, err := os.ReadDir(w.opts.inbox)
+	if err != nil {
+		return err
+	}
+
+	vistos := make(map[string]observacao, len(entradas))
+	for _, e := range entradas {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !e.Type().IsRegular() || !watchSuportado(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // removido entre a listagem e a leitura
+		}
+
+		atual := observacao{tamanho: info.Size(), modificado: info.ModTime()}
+		anterior, visto := w.vistos[e.Name()]
+		vistos[e.Name()] = atual
+		if !visto || !anterior.igual(atual) || w.now().Sub(atual.modificado) < w.opts.estabilidade {
+			continue
+		}
+
+		if err := w.process(ctx, e.Name()); err != nil {
+			slog.ErrorContext(ctx, "erro ao processar arquivo; nova tentativa na próxima leitura", "arquivo", e.Name(), "erro", err)
+			continue
+		}
+		delete(vistos, e.Name())
+	}
+	w.vistos = vistos
+	return nil
+}
+
+// process converte um arquivo e o move para processados ou, com erro no conteúdo, para falhas.
+// Banco indisponível, cancelamento e erros ao gravar a saída deixam o arquivo na caixa de entrada.
+func (w *watcher) process(ctx context.Context, nome string) error {
+	origem := filepath.Join(w.opts.inbox, nome)
+	conteudo, err := os.ReadFile(origem)
+	if err != nil {
+		return err
+	}
+	hash := contentHash(conteudo)
+
+	if anterior, ok := w.historico.get(hash); ok {
+		slog.InfoContext(ctx, "arquivo já convertido; movido sem gerar nova saída", "arquivo", nome, "saida", anterior.Saida)
+		_, err := moveUnique(origem, w.opts.processados)
+		return err
+	}
+
+	files, err := expandUpload(nome, conteudo)
+	var result *BatchResult
+	if err == nil {
+		result, err = ProcessBatchContext(ctx, files, "", BatchOptions{Layout: w.layout, Degradado: degradadoFalhar})
+	}
+	if err != nil {
+		if errors.Is(err, ErrBancoIndisponivel) || ctx.Err() != nil {
+			return err
+		}
+		return w.fail(ctx, origem, err)
+	}
+
+	saida, err := w.writeOutput(ctx, nome, result)
+	if err != nil {
+		return err
+	}
+	if err := w.historico.add(watchEntry{Hash: hash, Arquivo: nome, Saida: filepath.Base(saida), Linhas: result.Linhas, Em: w.now()}); err != nil {
+		return err
+	}
+	if _, err := moveUnique(origem, w.opts.processados); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "arquivo convertido", "arquivo", nome, "saida", saida, "linhas", result.Linhas)
+	return nil
+}
+
+// writeOutput grava a saída em um temporário na outbox e o renomeia, para que quem lê a
+// outbox nunca veja um arquivo pela metade
+func (w *watcher) writeOutput(ctx context.Context, nome string, result *BatchResult) (string, error) {
+	destino := uniquePath(w.opts.outbox, watchBaseName(nome)+"."+w.opts.formato)
+	tmp, err := os.CreateTemp(w.opts.outbox, ".convertendo-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeBatchOutput(ctx, tmp, w.opts.formato, w.layout, result); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return destino, os.Rename(tmp.Name(), destino)
+}
+
+// fail move o arquivo para falhas com um <arquivo>.erro.txt ao lado descrevendo o erro
+func (w *watcher) fail(ctx context.Context, origem string, causa error) error {
+	destino, err := moveUnique(origem, w.opts.falhas)
+	if err != nil {
+		return err
+	}
+	detalhe := fmt.Sprintf("arquivo: %s\nquando: %s\nerro: %v\n", filepath.Base(origem), w.now().Format(time.RFC3339), causa)
+	if err := os.WriteFile(destino+".erro.txt", []byte(detalhe), 0o644); err != nil {
+		slog.WarnContext(ctx, "não foi possível gravar o arquivo de erro", "arquivo", destino, "erro", err)
+	}
+	slog.WarnContext(ctx, "arquivo com erro movido para falhas", "arquivo", filepath.Base(origem), "destino", destino, "erro", causa)
+	return nil
+}
+
+// watchBaseName remove a extensão (inclusive .tar.gz) do nome do arquivo recebido
+func watchBaseName(nome string) string {
+	lower := strings.ToLower(nome)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip", ".xml"} {
+		if strings.HasSuffix(lower, ext) {
+			return nome[:len(nome)-len(ext)]
+		}
+	}
+	return nome
+}
+
+// uniquePath devolve dir/nome, ou dir/<base>-2.<ext>, -3... se o nome já existir
+func uniquePath(dir, nome string) string {
+	destino := filepath.Join(dir, nome)
+	base := watchBaseName(nome)
+	ext := nome[len(base):]
+	if ext == "" {
+		ext = filepath.Ext(nome)
+		base = strings.TrimSuffix(nome, ext)
+	}
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(destino); errors.Is(err, os.ErrNotExist) {
+			return destino
+		}
+		destino = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+	}
+}
+
+// moveUnique move o arquivo para dir sem sobrescrever outro com o mesmo nome
+func moveUnique(origem, dir string) (string, error) {
+	destino := uniquePath(dir, filepath.Base(origem))
+	return destino, os.Rename(origem, destino)
+}
+
+// watchEntry é uma linha do histórico de arquivos convertidos
+type watchEntry struct {
+	Hash    string    `json:"hash"`
+	Arquivo string    `json:"arquivo"`
+	Saida   string    `json:"saida"`
+	Linhas  int       `json:"linhas"`
+	Em      time.Time `json:"em"`
+}
+
+// watchLedger é o histórico em JSON Lines, só com acréscimos: uma linha truncada por
+// queda do processo é ignorada na leitura
+type watchLedger struct {
+	path     string
+	entradas map[string]watchEntry
+	quebra   bool
+}
+
+func openWatchLedger(path string) (*watchLedger, error) {
+	l := &watchLedger{path: path, entradas: make(map[string]watchEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, linha := range bytes.Split(data, []byte("\n")) {
+		var e watchEntry
+		if json.Unmarshal(linha, &e) == nil && e.Hash != "" {
+			l.entradas[e.Hash] = e
+		}
+	}
+	// A próxima entrada não pode continuar a linha truncada
+	l.quebra = len(data) > 0 && data[len(data)-1] != '\n'
+	return l, nil
+}
+
+func (l *watchLedger) get(hash string) (watchEntry, bool) {
+	e, ok := l.entradas[hash]
+	return e, ok
+}
+
+// add grava a entrada no disco antes de mover o arquivo de origem
+func (l *watchLedger) add(e watchEntry) error {
+	linha, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if l.quebra {
+		linha = append([]byte("\n"), linha...)
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(linha, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	l.entradas[e.Hash] = e
+	l.quebra = false
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// watcherDeTeste cria um watcher com dados mestres locais em diretórios temporários
+func watcherDeTeste(t *testing.T) *watcher {
+	t.Helper()
+	dadosLocaisDeTeste(t)
+	dir := t.TempDir()
+	opts := &watchOptions{
+		inbox:        filepath.Join(dir, "inbox"),
+		outbox:       filepath.Join(dir, "outbox"),
+		processados:  filepath.Join(dir, "inbox", "processed"),
+		falhas:       filepath.Join(dir, "inbox", "failed"),
+		formato:      formatCSV,
+		intervalo:    time.Second,
+		estabilidade: 30 * time.Second,
+	}
+	require.NoError(t, os.MkdirAll(opts.inbox, 0o755))
+	w, err := newWatcher(opts)
+	require.NoError(t, err)
+	return w
+}
+
+// depositaArquivo grava um arquivo na caixa de entrada com a data de modificação informada
+func depositaArquivo(t *testing.T, w *watcher, nome, conteudo string, modificado time.Time) {
+	t.Helper()
+	path := filepath.Join(w.opts.inbox, nome)
+	require.NoError(t, os.WriteFile(path, []byte(conteudo), 0o644))
+	require.NoError(t, os.Chtimes(path, modificado, modificado))
+}
+
+// nomesEm lista os arquivos de um diretório
+func nomesEm(t *testing.T, dir string) []string {
+	t.Helper()
+	entradas, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var nomes []string
+	for _, e := range entradas {
+		if !e.IsDir() {
+			nomes = append(nomes, e.Name())
+		}
+	}
+	return nomes
+}
+
+var btcValido = btcXML("1", "951716", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:00:00"))
+
+// TestWatcher_ProcessaQuandoEstavel testa que o arquivo só é convertido quando não muda entre leituras e após --settle
+func TestWatcher_ProcessaQuandoEstavel(t *testing.T) {
+	w := watcherDeTeste(t)
+	agora := time.Now()
+	w.now = func() time.Time { return agora }
+	ctx := context.Background()
+
+	depositaArquivo(t, w, "garagem.xml", btcValido, agora.Add(-time.Minute))
+	depositaArquivo(t, w, "copiando.xml", btcValido[:50], agora.Add(-time.Second))
+	depositaArquivo(t, w, "notas.txt", "ignorado", agora.Add(-time.Hour))
+
+	require.NoError(t, w.scan(ctx))
+	assert.Empty(t, nomesEm(t, w.opts.outbox), "Primeira leitura só observa")
+
+	// copiando.xml continua crescendo
+	depositaArquivo(t, w, "copiando.xml", btcValido[:80], agora.Add(-time.Second))
+	require.NoError(t, w.scan(ctx))
+
+	assert.Equal(t, []string{"garagem.csv"}, nomesEm(t, w.opts.outbox))
+	assert.Equal(t, []string{".historico.jsonl", "garagem.xml"}, nomesEm(t, w.opts.processados))
+	assert.ElementsMatch(t, []string{"copiando.xml", "notas.txt"}, nomesEm(t, w.opts.inbox))
+
+	rows := readCSV(t, filepath.Join(w.opts.outbox, "garagem.csv"))
+	require.Len(t, rows, 2)
+	assert.Equal(t, "12345678901", rows[1][len(rows[1])-1])
+}
+
+// TestWatcher_Falha testa que conteúdo inválido vai para failed/ com o arquivo de erro ao lado
+func TestWatcher_Falha(t *testing.T) {
+	w := watcherDeTeste(t)
+	agora := time.Now()
+	w.now = func() time.Time { return agora }
+
+	depositaArquivo(t, w, "quebrado.xml", "<btcs><btc>", agora.Add(-time.Minute))
+	require.NoError(t, w.scan(context.Background()))
+	require.NoError(t, w.scan(context.Background()))
+
+	assert.Empty(t, nomesEm(t, w.opts.inbox))
+	assert.Empty(t, nomesEm(t, w.opts.outbox))
+	assert.Equal(t, []string{"quebrado.xml", "quebrado.xml.erro.txt"}, nomesEm(t, w.opts.falhas))
+
+	detalhe, err := os.ReadFile(filepath.Join(w.opts.falhas, "quebrado.xml.erro.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(detalhe), "arquivo: quebrado.xml")
+	assert.Contains(t, string(detalhe), "erro: ")
+}
+
+// TestWatcher_Reinicio testa que, após reiniciar, conteúdo já convertido é movido sem gerar nova saída
+func TestWatcher_Reinicio(t *testing.T) {
+	w := watcherDeTeste(t)
+	agora := time.Now()
+	w.now = func() time.Time { return agora }
+
+	depositaArquivo(t, w, "garagem.xml", btcValido, agora.Add(-time.Minute))
+	require.NoError(t, w.scan(context.Background()))
+	require.NoError(t, w.scan(context.Background()))
+	require.Equal(t, []string{"garagem.csv"}, nomesEm(t, w.opts.outbox))
+
+	// Queda entre a gravação do histórico e a movimentação: o arquivo volta à caixa de entrada
+	reiniciado, err := newWatcher(w.opts)
+	require.NoError(t, err)
+	reiniciado.now = w.now
+	depositaArquivo(t, reiniciado, "garagem.xml", btcValido, agora.Add(-time.Minute))
+	require.NoError(t, reiniciado.scan(context.Background()))
+	require.NoError(t, reiniciado.scan(context.Background()))
+
+	assert.Equal(t, []string{"garagem.csv"}, nomesEm(t, w.opts.outbox), "Sem saída repetida")
+	assert.Equal(t, []string{".historico.jsonl", "garagem-2.xml", "garagem.xml"}, nomesEm(t, w.opts.processados))
+}
+
+// TestWatchLedger_LinhaTruncada testa que uma linha incompleta do histórico é ignorada sem corromper a seguinte
+func TestWatchLedger_LinhaTruncada(t *testing.T) {
+	path := filepath.Join(t.TempDir(), watchHistorico)
+	require.NoError(t, os.WriteFile(path, []byte(`{"hash":"aaa","arquivo":"a.xml"}`+"\n"+`{"hash":"bb`), 0o644))
+
+	l, err := openWatchLedger(path)
+	require.NoError(t, err)
+	_, ok := l.get("aaa")
+	assert.True(t, ok)
+	require.NoError(t, l.add(watchEntry{Hash: "ccc", Arquivo: "c.xml"}))
+
+	l, err = openWatchLedger(path)
+	require.NoError(t, err)
+	assert.Len(t, l.entradas, 2)
+	_, ok = l.get("ccc")
+	assert.True(t, ok)
+}
+
+// TestParseWatchArgs testa os padrões de processed/ e failed/ e as opções obrigatórias
+func TestParseWatchArgs(t *testing.T) {
+	opts, err := parseWatchArgs([]string{"--inbox", "/srv/btc/entrada", "--outbox", "/srv/btc/saida", "--settle", "1m"}, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, "/srv/btc/entrada/processed", opts.processados)
+	assert.Equal(t, "/srv/btc/entrada/failed", opts.falhas)
+	assert.Equal(t, formatCSV, opts.formato)
+	assert.Equal(t, time.Minute, opts.estabilidade)
+	assert.Equal(t, 10*time.Second, opts.intervalo)
+
+	_, err = parseWatchArgs([]string{"--inbox", "/srv/btc/entrada"}, io.Discard)
+	assert.ErrorContains(t, err, "informe --inbox e --outbox")
+	_, err = parseWatchArgs([]string{"--inbox", "a", "--outbox", "b", "--format", "pdf"}, io.Discard)
+	assert.ErrorContains(t, err, "formato não suportado")
+}
+
+// TestUniquePath testa os sufixos para nomes já existentes, inclusive .tar.gz
+func TestUniquePath(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, filepath.Join(dir, "lote.tar.gz"), uniquePath(dir, "lote.tar.gz"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lote.tar.gz"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lote-2.tar.gz"), nil, 0o644))
+	assert.Equal(t, filepath.Join(dir, "lote-3.tar.gz"), uniquePath(dir, "lote.tar.gz"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "viagens.csv"), nil, 0o644))
+	assert.Equal(t, filepath.Join(dir, "viagens-2.csv"), uniquePath(dir, "viagens.csv"))
+}
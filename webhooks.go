@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Eventos enviados aos webhooks quando um job termina
+const (
+	eventoJobConcluido = "job.concluido"
+	eventoJobFalhou    = "job.falhou"
+)
+
+// Estados de uma entrega na tabela webhook_entrega
+const (
+	entregaPendente = "pendente"
+	entregaEntregue = "entregue"
+	entregaFalhou   = "falhou"
+)
+
+// Cabeçalhos das notificações. A assinatura é "t=<unix>,v1=<hex>", com v1 = HMAC-SHA256 do
+// segredo sobre "<t>.<corpo>"; o destinatário deve recalcular e recusar t muito antigo.
+const (
+	headerWebhookEvent     = "X-Webhook-Event"
+	headerWebhookDelivery  = "X-Webhook-Delivery"
+	headerWebhookSignature = "X-Webhook-Signature"
+)
+
+// webhookResponseLimit limita a leitura da resposta, que é descartada: o registro de entregas
+// guarda apenas o status, para o webhook não servir de leitor de serviços internos
+const webhookResponseLimit = 512
+
+// errWebhookEnderecoInterno recusa destinos internos (loopback, rede privada, link-local),
+// a menos que webhooks.allow_private esteja ligado
+var errWebhookEnderecoInterno = errors.New("endereço interno não permitido para webhooks")
+
+// lookupWebhookHost resolve o host do webhook no cadastro; os testes a substituem
+var lookupWebhookHost = net.DefaultResolver.LookupIPAddr
+
+// redesInternas são faixas fora de net.IP.IsPrivate que também não são destinos públicos:
+// "esta rede" e o espaço compartilhado de CGNAT, usado por metadados de alguns provedores
+var redesInternas = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+}
+
+// webhookIPInterno indica se ip é loopback, privado, link-local, multicast ou não especificado
+func webhookIPInterno(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, rede := range redesInternas {
+		if rede.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook é um endereço cadastrado para receber as notificações (sem o segredo)
+type Webhook struct {
+	ID         int64      `json:"id"`
+	URL        string     `json:"url"`
+	JobID      *int64     `json:"job_id,omitempty"`
+	CriadoPor  string     `json:"criado_por"`
+	CriadoEm   time.Time  `json:"criado_em"`
+	RemovidoEm *time.Time `json:"removido_em,omitempty"`
+}
+
+// jobWebhook é o webhook de um único upload (POST /jobs?webhook=), gravado com o job
+type jobWebhook struct {
+	URL     string
+	Segredo string
+}
+
+// WebhookNotificacao é o corpo JSON enviado quando um job termina
+type WebhookNotificacao struct {
+	Evento      string    `json:"evento"`
+	JobID       int64     `json:"job_id"`
+	Estado      string    `json:"estado"`
+	Formato     string    `json:"formato"`
+	Tentativas  int       `json:"tentativas"`
+	ConcluidoEm time.Time `json:"concluido_em"`
+	Linhas      int       `json:"linhas"`
+	Arquivos    int       `json:"arquivos"`
+	Operacoes   int       `json:"operacoes"`
+	Avisos      []string  `json:"avisos"`
+	Erro        string    `json:"erro,omitempty"`
+	// JobURL é a situação do job; ResultadoURL, o download da saída (apenas em job.concluido)
+	JobURL       string `json:"job_url"`
+	ResultadoURL string `json:"resultado_url,omitempty"`
+}
+
+// WebhookEntrega é uma linha do registro de entregas
+type WebhookEntrega struct {
+	ID               int64           `json:"id"`
+	WebhookID        int64           `json:"webhook_id"`
+	URL              string          `json:"url"`
+	JobID            int64           `json:"job_id"`
+	Evento           string          `json:"evento"`
+	Estado           string          `json:"estado"`
+	Tentativas       int             `json:"tentativas"`
+	ProximaTentativa *time.Time      `json:"proxima_tentativa,omitempty"`
+	StatusHTTP       *int            `json:"status_http,omitempty"`
+	Erro             string          `json:"erro,omitempty"`
+	CriadoEm         time.Time       `json:"criado_em"`
+	EntregueEm       *time.Time      `json:"entregue_em,omitempty"`
+	Payload          json.RawMessage `json:"payload"`
+}
+
+// validateWebhookURL aceita apenas endereços http(s) absolutos cujo host resolve para endereços
+// públicos. A conexão confere de novo o endereço (newWebhookClient): o DNS pode mudar depois do cadastro.
+func validateWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("URL de webhook inválida: %q (use http:// ou https://)", raw)
+	}
+	if cfg.Webhooks.AllowPrivate {
+		return nil
+	}
+
+	ips := []net.IPAddr{{IP: net.ParseIP(u.Hostname())}}
+	if ips[0].IP == nil {
+		if ips, err = lookupWebhookHost(ctx, u.Hostname()); err != nil || len(ips) == 0 {
+			return fmt.Errorf("URL de webhook inválida: host %q não encontrado", u.Hostname())
+		}
+	}
+	for _, ip := range ips {
+		if webhookIPInterno(ip.IP) {
+			return fmt.Errorf("URL de webhook inválida: %w (%s)", errWebhookEnderecoInterno, ip.IP)
+		}
+	}
+	return nil
+}
+
+// generateWebhookSecret gera o segredo das assinaturas no formato whsec_<segredo>
+func generateWebhookSecret() (string, error) {
+	segredo := make([]byte, 32)
+	if _, err := rand.Read(segredo); err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(segredo), nil
+}
+
+// signWebhook calcula o cabeçalho X-Webhook-Signature do corpo enviado no instante t
+func signWebhook(segredo string, t time.Time, corpo []byte) string {
+	mac := hmac.New(sha256.New, []byte(segredo))
+	fmt.Fprintf(mac, "%d.", t.Unix())
+	mac.Write(corpo)
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// queryRower é *sql.DB ou *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertWebhook cadastra um webhook; jobID nil vale para todos os jobs de criadoPor
+func insertWebhook(ctx context.Context, q queryRower, endereco, segredo, criadoPor string, jobID *int64) (*Webhook, error) {
+	wh := &Webhook{URL: strings.TrimSpace(endereco), JobID: jobID, CriadoPor: criadoPor}
+	err := q.QueryRowContext(ctx, `
+		INSERT INTO webhook (url, segredo, job_id, criado_por) VALUES ($1, $2, $3, $4)
+		RETURNING id, criado_em
+	`, wh.URL, segredo, jobID, criadoPor).Scan(&wh.ID, &wh.CriadoEm)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao cadastrar webhook: %w", err)
+	}
+	return wh, nil
+}
+
+// publicURL monta o endereço externo de um caminho da API (relativo sem webhooks.public_url)
+func publicURL(caminho string) string {
+	return strings.TrimRight(cfg.Webhooks.PublicURL, "/") + caminho
+}
+
+// newWebhookNotificacao resume o job terminado: contagens, avisos e o link de download
+func newWebhookNotificacao(job *Job, estado string, result *BatchResult, erro error) *WebhookNotificacao {
+	n := &WebhookNotificacao{
+		Evento:      eventoJobConcluido,
+		JobID:       job.ID,
+		Estado:      estado,
+		Formato:     job.Formato,
+		Tentativas:  job.Tentativas,
+		ConcluidoEm: time.Now().UTC(),
+		Avisos:      []string{},
+		JobURL:      publicURL(fmt.Sprintf("/jobs/%d", job.ID)),
+	}
+	if estado == jobConcluido {
+		n.ResultadoURL = n.JobURL + "/resultado"
+	} else {
+		n.Evento = eventoJobFalhou
+	}
+	if erro != nil {
+		n.Erro = erro.Error()
+	}
+
+	if result == nil {
+		return n
+	}
+	n.Linhas, n.Arquivos = result.Linhas, len(result.Arquivos)
+	for _, arquivo := range result.Arquivos {
+		n.Operacoes += arquivo.Operacoes
+		if arquivo.Erro != "" {
+			n.Avisos = append(n.Avisos, fmt.Sprintf("%s: %s", arquivo.Arquivo, arquivo.Erro))
+		}
+	}
+	if result.Duplicados != nil && !result.Duplicados.Empty() {
+		n.Avisos = append(n.Avisos, fmt.Sprintf("%d arquivo(s) e %d operação(ões) já processados em uploads anteriores",
+			len(result.Duplicados.ArquivosRepetidos), len(result.Duplicados.OperacoesRepetidas)))
+	}
+	if result.Incompleto {
+		n.Avisos = append(n.Avisos, fmt.Sprintf("%d operações sem enriquecimento por falha do banco", result.OperacoesIncompletas))
+	}
+	return n
+}
+
+// enqueueWebhookDeliveries registra uma entrega para cada webhook do job: o do próprio
+// upload e os globais de quem criou o job
+func enqueueWebhookDeliveries(ctx context.Context, db *sql.DB, job *Job, n *WebhookNotificacao) (int64, error) {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO webhook_entrega (webhook_id, job_id, evento, payload)
+		SELECT id, $1, $2, $3 FROM webhook
+		WHERE removido_em IS NULL AND (job_id = $1 OR (job_id IS NULL AND criado_por = $4))
+	`, job.ID, n.Evento, payload, job.CriadoPor)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao registrar notificações do job: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// newWebhookClient cria o cliente das entregas; redirecionamentos não são seguidos e contam como falha.
+// Sem webhooks.allow_private, a conexão é recusada quando o endereço já resolvido é interno, e as
+// entregas não passam por proxy, para a verificação valer para o destino de fato.
+func newWebhookClient(c WebhooksConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: c.Timeout.Duration}
+	if !c.AllowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || webhookIPInterno(ip) {
+				return fmt.Errorf("%w (%s)", errWebhookEnderecoInterno, host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   c.Timeout.Duration,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// deliverNextWebhook envia a próxima entrega pendente; entregou é falso quando não há nenhuma.
+// A linha fica travada (SKIP LOCKED) durante o envio: se o worker cair, a transação é desfeita
+// e a entrega é repetida por outro worker.
+func deliverNextWebhook(ctx context.Context, db *sql.DB, client *http.Client, c WebhooksConfig) (entregou bool, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var evento, endereco, segredo string
+	var payload []byte
+	var tentativas int
+	err = tx.QueryRowContext(ctx, `
+		SELECT e.id, e.evento, e.payload, e.tentativas, w.url, w.segredo
+		FROM webhook_entrega e JOIN webhook w ON w.id = e.webhook_id
+		WHERE e.estado = 'pendente' AND e.proxima_tentativa <= now() AND w.removido_em IS NULL
+		ORDER BY e.proxima_tentativa, e.id
+		LIMIT 1
+		FOR UPDATE OF e SKIP LOCKED
+	`).Scan(&id, &evento, &payload, &tentativas, &endereco, &segredo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("erro ao buscar entrega de webhook: %w", err)
+	}
+
+	status, envioErr := sendWebhook(ctx, client, id, evento, endereco, segredo, payload)
+	if ctx.Err() != nil {
+		return false, ctx.Err() // encerramento: a entrega volta a ficar pendente
+	}
+
+	tentativas++
+	estado, espera, erro := entregaEntregue, time.Duration(0), sql.NullString{}
+	if envioErr != nil {
+		erro = sql.NullString{String: envioErr.Error(), Valid: true}
+		estado, espera = entregaPendente, retryBackoff(c.RetryMin.Duration, c.RetryMax.Duration, tentativas)
+		if tentativas >= c.MaxAttempts {
+			estado = entregaFalhou
+		}
+		slog.WarnContext(ctx, "falha na entrega de webhook", "entrega", id, "tentativa", tentativas, "estado", estado, "erro", envioErr)
+	}
+	statusHTTP := sql.NullInt64{Int64: int64(status), Valid: status != 0}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_entrega SET estado = $2, tentativas = $3, status_http = $4, erro = $5,
+		       proxima_tentativa = now() + $6 * interval '1 millisecond',
+		       entregue_em = CASE WHEN $2 = 'entregue' THEN now() END
+		WHERE id = $1
+	`, id, estado, tentativas, statusHTTP, erro, espera.Milliseconds())
+	if err != nil {
+		return false, fmt.Errorf("erro ao registrar entrega de webhook: %w", err)
+	}
+	return true, tx.Commit()
+}
+
+// sendWebhook envia a notificação assinada; respostas fora de 2xx são erro
+func sendWebhook(ctx context.Context, client *http.Client, id int64, evento, endereco, segredo string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endereco, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "btc-api-webhook")
+	req.Header.Set(headerWebhookEvent, evento)
+	req.Header.Set(headerWebhookDelivery, strconv.FormatInt(id, 10))
+	req.Header.Set(headerWebhookSignature, signWebhook(segredo, time.Now(), payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseLimit))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// listWebhookDeliveries lê as últimas entregas que atendem ao filtro (coluna de webhook_entrega)
+func listWebhookDeliveries(ctx context.Context, db *sql.DB, coluna string, id int64, limite int) ([]WebhookEntrega, error) {
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT e.id, e.webhook_id, w.url, e.job_id, e.evento, e.estado, e.tentativas, e.proxima_tentativa,
+		       e.status_http, e.erro, e.criado_em, e.entregue_em, e.payload
+		FROM webhook_entrega e JOIN webhook w ON w.id = e.webhook_id
+		WHERE e.`+coluna+` = $1
+		ORDER BY e.id DESC
+		LIMIT $2
+	`, id, limite)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entregas := []WebhookEntrega{}
+	for rows.Next() {
+		var e WebhookEntrega
+		var proxima, entregueEm sql.NullTime
+		var status sql.NullInt64
+		var erro sql.NullString
+		var payload []byte
+		if err := rows.Scan(&e.ID, &e.WebhookID, &e.URL, &e.JobID, &e.Evento, &e.Estado, &e.Tentativas, &proxima,
+			&status, &erro, &e.CriadoEm, &entregueEm, &payload); err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		if e.Estado == entregaPendente && proxima.Valid {
+			e.ProximaTentativa = &proxima.Time
+		}
+		if status.Valid {
+			s := int(status.Int64)
+			e.StatusHTTP = &s
+		}
+		if entregueEm.Valid {
+			e.EntregueEm = &entregueEm.Time
+		}
+		e.Erro = erro.String
+		entregas = append(entregas, e)
+	}
+	return entregas, rows.Err()
+}
+
+// deliveryLimit lê ?limite= (padrão 50, máximo 500)
+func deliveryLimit(c *gin.Context) int {
+	limite, err := strconv.Atoi(c.Query("limite"))
+	if err != nil || limite <= 0 {
+		return 50
+	}
+	if limite > 500 {
+		return 500
+	}
+	return limite
+}
+
+// createWebhookHandler cadastra um webhook para todos os jobs da credencial; o segredo só é devolvido nesta resposta
+func createWebhookHandler(c *gin.Context) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Informe url"})
+		return
+	}
+	if err := validateWebhookURL(c.Request.Context(), req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := statementContext(c.Request.Context())
+	defer cancel()
+
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	segredo, err := generateWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao gerar segredo"})
+		return
+	}
+	wh, err := insertWebhook(ctx, db, req.URL, segredo, principalFrom(c).Nome, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "webhook cadastrado", "id", wh.ID, "url", wh.URL, "criado_por", wh.CriadoPor)
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": wh,
+		"segredo": segredo,
+		"message": "Guarde o segredo: ele assina as notificações e não será exibido novamente",
+	})
+}
+
+// listWebhooksHandler lista os webhooks globais da credencial (todos, para o administrador), incluindo os removidos
+func listWebhooksHandler(c *gin.Context) {
+	ctx, cancel := statementContext(c.Request.Context())
+	defer cancel()
+
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	p := principalFrom(c)
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, url, criado_por, criado_em, removido_em FROM webhook
+		WHERE job_id IS NULL AND ($1 OR criado_por = $2)
+		ORDER BY id
+	`, p.Has(roleAdmin), p.Nome)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var wh Webhook
+		var removidoEm sql.NullTime
+		if err := rows.Scan(&wh.ID, &wh.URL, &wh.CriadoPor, &wh.CriadoEm, &removidoEm); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if removidoEm.Valid {
+			wh.RemovidoEm = &removidoEm.Time
+		}
+		webhooks = append(webhooks, wh)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks})
+}
+
+// deleteWebhookHandler remove um webhook; entregas pendentes deixam de ser enviadas
+func deleteWebhookHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+
+	ctx, cancel := statementContext(c.Request.Context())
+	defer cancel()
+
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	p := principalFrom(c)
+	res, err := db.ExecContext(ctx,
+		"UPDATE webhook SET removido_em = now() WHERE id = $1 AND removido_em IS NULL AND ($2 OR criado_por = $3)",
+		id, p.Has(roleAdmin), p.Nome)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook não encontrado ou já removido"})
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "webhook removido", "id", id)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Webhook removido"})
+}
+
+// webhookDeliveriesHandler devolve o registro de entregas de um webhook da credencial
+func webhookDeliveriesHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id inválido"})
+		return
+	}
+
+	db, err := getDBConnection(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := statementContext(c.Request.Context())
+	var criadoPor string
+	err = db.QueryRowContext(ctx, "SELECT criado_por FROM webhook WHERE id = $1", id).Scan(&criadoPor)
+	cancel()
+	p := principalFrom(c)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && criadoPor != p.Nome && !p.Has(roleAdmin)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook não encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	entregas, err := listWebhookDeliveries(c.Request.Context(), db, "webhook_id", id, deliveryLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entregas": entregas})
+}
+
+// jobDeliveriesHandler devolve as notificações do job (webhook do upload e globais)
+func jobDeliveriesHandler(c *gin.Context) {
+	job, db, ok := loadJobFor(c)
+	if !ok {
+		return
+	}
+	entregas, err := listWebhookDeliveries(c.Request.Context(), db, "job_id", job.ID, deliveryLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entregas": entregas})
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var colunasEntregaPendente = []string{"id", "evento", "payload", "tentativas", "url", "segredo"}
+
+func webhooksDeTeste() WebhooksConfig {
+	return WebhooksConfig{
+		Timeout:     Duration{time.Second},
+		MaxAttempts: 3,
+		RetryMin:    Duration{30 * time.Second},
+		RetryMax:    Duration{time.Hour},
+	}
+}
+
+// TestSignWebhook testa a assinatura HMAC-SHA256 sobre "<t>.<corpo>"
+func TestSignWebhook(t *testing.T) {
+	corpo := []byte(`{"evento":"job.concluido"}`)
+	assinatura := signWebhook("whsec_teste", time.Unix(1700000000, 0), corpo)
+
+	mac := hmac.New(sha256.New, []byte("whsec_teste"))
+	mac.Write([]byte("1700000000." + string(corpo)))
+	assert.Equal(t, "t=1700000000,v1="+hex.EncodeToString(mac.Sum(nil)), assinatura)
+	assert.NotEqual(t, assinatura, signWebhook("outro", time.Unix(1700000000, 0), corpo))
+}
+
+// comDNSDeTeste resolve os hosts dos webhooks sem consultar a rede: erp.example.com é público,
+// interno.example.com aponta para a rede privada e os demais não existem
+func comDNSDeTeste(t *testing.T) {
+	t.Helper()
+	original := lookupWebhookHost
+	lookupWebhookHost = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "erp.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("203.0.113.10")}}, nil
+		case "interno.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("203.0.113.11")}, {IP: net.ParseIP("10.0.0.5")}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	t.Cleanup(func() { lookupWebhookHost = original })
+}
+
+// TestValidateWebhookURL testa a exigência de URL http(s) absoluta com destino público
+func TestValidateWebhookURL(t *testing.T) {
+	comDNSDeTeste(t)
+	ctx := context.Background()
+	assert.NoError(t, validateWebhookURL(ctx, "https://erp.example.com/btc"))
+	assert.Error(t, validateWebhookURL(ctx, "/hook"))
+	assert.Error(t, validateWebhookURL(ctx, "ftp://erp.example.com"))
+	assert.Error(t, validateWebhookURL(ctx, "https://"))
+	assert.ErrorContains(t, validateWebhookURL(ctx, "https://inexistente.example.com"), "não encontrado")
+
+	for _, endereco := range []string{
+		"http://10.0.0.5:8080/hook", "http://127.0.0.1/", "http://169.254.169.254/latest/meta-data",
+		"http://[::1]/", "http://[fd00::1]/", "http://0.0.0.0/", "http://100.100.100.200/", "https://interno.example.com/",
+	} {
+		assert.ErrorIs(t, validateWebhookURL(ctx, endereco), errWebhookEnderecoInterno, endereco)
+	}
+
+	comConfig(t, func(c *Config) { c.Webhooks.AllowPrivate = true })
+	assert.NoError(t, validateWebhookURL(ctx, "http://10.0.0.5:8080/hook"), "Liberado por webhooks.allow_private")
+}
+
+// TestWebhookClient_EnderecoInterno testa a recusa do destino interno na conexão, depois da resolução
+func TestWebhookClient_EnderecoInterno(t *testing.T) {
+	atendido := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { atendido = true }))
+	defer srv.Close()
+
+	c := webhooksDeTeste()
+	_, err := sendWebhook(context.Background(), newWebhookClient(c), 1, eventoJobConcluido, srv.URL, "whsec_teste", []byte(`{}`))
+	assert.ErrorIs(t, err, errWebhookEnderecoInterno)
+	assert.False(t, atendido)
+
+	c.AllowPrivate = true
+	status, err := sendWebhook(context.Background(), newWebhookClient(c), 1, eventoJobConcluido, srv.URL, "whsec_teste", []byte(`{}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, atendido)
+}
+
+// TestNewWebhookNotificacao testa contagens, avisos e links da notificação
+func TestNewWebhookNotificacao(t *testing.T) {
+	comConfig(t, func(c *Config) { c.Webhooks.PublicURL = "https://btc.example.com/" })
+	job := &Job{ID: 42, Formato: formatCSV, Tentativas: 2}
+
+	result := &BatchResult{
+		Linhas: 3,
+		Arquivos: []FileSummary{
+			{Arquivo: "a.xml", Operacoes: 2},
+			{Arquivo: "b.xml", Operacoes: 2, Erro: "sem operações válidas"},
+		},
+		Duplicados:           &DuplicateReport{ArquivosRepetidos: []ProcessedUpload{{}}},
+		Incompleto:           true,
+		OperacoesIncompletas: 1,
+	}
+	n := newWebhookNotificacao(job, jobConcluido, result, nil)
+	assert.Equal(t, eventoJobConcluido, n.Evento)
+	assert.Equal(t, 3, n.Linhas)
+	assert.Equal(t, 2, n.Arquivos)
+	assert.Equal(t, 4, n.Operacoes)
+	assert.Equal(t, []string{
+		"b.xml: sem operações válidas",
+		"1 arquivo(s) e 0 operação(ões) já processados em uploads anteriores",
+		"1 operações sem enriquecimento por falha do banco",
+	}, n.Avisos)
+	assert.Equal(t, "https://btc.example.com/jobs/42", n.JobURL)
+	assert.Equal(t, "https://btc.example.com/jobs/42/resultado", n.ResultadoURL)
+
+	n = newWebhookNotificacao(job, jobFalhou, nil, assert.AnError)
+	assert.Equal(t, eventoJobFalhou, n.Evento)
+	assert.Equal(t, assert.AnError.Error(), n.Erro)
+	assert.Empty(t, n.ResultadoURL, "Job que falhou não tem download")
+	assert.NotNil(t, n.Avisos)
+}
+
+// TestDeliverNextWebhook testa o envio assinado, a nova tentativa com espera e a desistência após max_attempts
+func TestDeliverNextWebhook(t *testing.T) {
+	mock := comBancoMock(t)
+	db, err := getDBConnection(context.Background())
+	require.NoError(t, err)
+	c := webhooksDeTeste()
+	c.AllowPrivate = true // servidor de teste em loopback
+	payload := []byte(`{"evento":"job.concluido","job_id":42}`)
+
+	status := http.StatusNoContent
+	var recebido *http.Request
+	var corpo []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recebido = r
+		corpo, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		w.Write([]byte("indisponível"))
+	}))
+	defer srv.Close()
+
+	expectEntrega := func(tentativas int) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("FOR UPDATE OF e SKIP LOCKED").
+			WillReturnRows(sqlmock.NewRows(colunasEntregaPendente).AddRow(9, eventoJobConcluido, payload, tentativas, srv.URL, "whsec_teste"))
+	}
+
+	expectEntrega(0)
+	mock.ExpectExec("UPDATE webhook_entrega").
+		WithArgs(int64(9), entregaEntregue, 1, sqlmock.AnyArg(), sqlmock.AnyArg(), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	entregou, err := deliverNextWebhook(context.Background(), db, newWebhookClient(c), c)
+	require.NoError(t, err)
+	assert.True(t, entregou)
+	assert.Equal(t, payload, corpo)
+	assert.Equal(t, eventoJobConcluido, recebido.Header.Get(headerWebhookEvent))
+	assert.Equal(t, "9", recebido.Header.Get(headerWebhookDelivery))
+	assinatura := recebido.Header.Get(headerWebhookSignature)
+	tempo, _, _ := strings.Cut(strings.TrimPrefix(assinatura, "t="), ",")
+	var unix int64
+	require.NoError(t, json.Unmarshal([]byte(tempo), &unix))
+	assert.Equal(t, signWebhook("whsec_teste", time.Unix(unix, 0), payload), assinatura)
+
+	status = http.StatusInternalServerError
+	expectEntrega(1)
+	mock.ExpectExec("UPDATE webhook_entrega").
+		WithArgs(int64(9), entregaPendente, 2, int64(http.StatusInternalServerError), "HTTP 500", int64(60000)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	entregou, err = deliverNextWebhook(context.Background(), db, newWebhookClient(c), c)
+	require.NoError(t, err)
+	assert.True(t, entregou)
+
+	expectEntrega(2)
+	mock.ExpectExec("UPDATE webhook_entrega").
+		WithArgs(int64(9), entregaFalhou, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	_, err = deliverNextWebhook(context.Background(), db, newWebhookClient(c), c)
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE OF e SKIP LOCKED").WillReturnRows(sqlmock.NewRows(colunasEntregaPendente))
+	mock.ExpectRollback()
+	entregou, err = deliverNextWebhook(context.Background(), db, newWebhookClient(c), c)
+	assert.NoError(t, err)
+	assert.False(t, entregou, "Nenhuma entrega pendente")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestWebhookHandlers testa o cadastro, a remoção e o registro de entregas restritos a quem cadastrou
+func TestWebhookHandlers(t *testing.T) {
+	mock := comBancoMock(t)
+	comDNSDeTeste(t)
+	comConfig(t, func(c *Config) { c.Auth.JWTSecret = "segredo" })
+	router := newRouter()
+	token := func(sub string, papeis ...string) string {
+		return "Bearer " + signJWT(t, `{"alg":"HS256"}`, map[string]interface{}{"sub": sub, "papeis": papeis, "exp": time.Now().Add(time.Hour).Unix()}, "segredo")
+	}
+	request := func(method, target, credencial, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", credencial)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	erp := token("erp", roleUploader)
+	criadoEm := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/webhooks", erp, `{"url":"erp.example.com"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/webhooks", erp, `{"url":"http://169.254.169.254/latest/meta-data"}`).Code)
+	assert.Equal(t, http.StatusForbidden, request("GET", "/webhooks", token("painel", roleViewer), "").Code)
+
+	mock.ExpectQuery("INSERT INTO webhook").
+		WithArgs("https://erp.example.com/btc", sqlmock.AnyArg(), nil, "erp").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "criado_em"}).AddRow(3, criadoEm))
+	w := request("POST", "/webhooks", erp, `{"url":"https://erp.example.com/btc"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var criado struct {
+		Segredo string  `json:"segredo"`
+		Webhook Webhook `json:"webhook"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &criado))
+	assert.True(t, strings.HasPrefix(criado.Segredo, "whsec_"))
+	assert.Equal(t, int64(3), criado.Webhook.ID)
+
+	mock.ExpectQuery("SELECT id, url, criado_por, criado_em, removido_em FROM webhook").WithArgs(false, "erp").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "criado_por", "criado_em", "removido_em"}).
+			AddRow(3, "https://erp.example.com/btc", "erp", criadoEm, nil))
+	w = request("GET", "/webhooks", erp, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "whsec_")
+
+	mock.ExpectQuery("SELECT criado_por FROM webhook").WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"criado_por"}).AddRow("erp"))
+	assert.Equal(t, http.StatusNotFound, request("GET", "/webhooks/3/entregas", token("outro", roleUploader), "").Code)
+
+	mock.ExpectQuery("SELECT criado_por FROM webhook").WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"criado_por"}).AddRow("erp"))
+	mock.ExpectQuery("FROM webhook_entrega e JOIN webhook w").WithArgs(int64(3), 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "webhook_id", "url", "job_id", "evento", "estado", "tentativas",
+			"proxima_tentativa", "status_http", "erro", "criado_em", "entregue_em", "payload"}).
+			AddRow(9, 3, "https://erp.example.com/btc", 42, eventoJobConcluido, entregaPendente, 1, criadoEm, 500, "HTTP 500", criadoEm, nil, `{"job_id":42}`))
+	w = request("GET", "/webhooks/3/entregas", erp, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"status_http":500`)
+	assert.Contains(t, w.Body.String(), `"payload":{"job_id":42}`)
+
+	mock.ExpectExec("UPDATE webhook SET removido_em").WithArgs(int64(3), false, "outro").WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, http.StatusNotFound, request("DELETE", "/webhooks/3", token("outro", roleUploader), "").Code)
+	mock.ExpectExec("UPDATE webhook SET removido_em").WithArgs(int64(3), false, "erp").WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Equal(t, http.StatusOK, request("DELETE", "/webhooks/3", erp, "").Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestCreateJobHandler_Webhook testa o webhook de um único upload, gravado na transação do job
+func TestCreateJobHandler_Webhook(t *testing.T) {
+	mock := comBancoMock(t)
+	comDNSDeTeste(t)
+	comConfig(t, func(c *Config) { c.Auth.JWTSecret = "segredo" })
+	router := newRouter()
+	credencial := "Bearer " + signJWT(t, `{"alg":"HS256"}`, map[string]interface{}{"sub": "erp", "papeis": []string{roleUploader}, "exp": time.Now().Add(time.Hour).Unix()}, "segredo")
+
+	upload := func(target string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "btc.xml")
+		part.Write([]byte(btcXML("1", "", operacaoXML("1001", "1001", "100", "2024-01-15 08:00:00", "2024-01-15 09:30:00"))))
+		writer.Close()
+
+		req, _ := http.NewRequest("POST", target, body)
+		req.Header.Set("Authorization", credencial)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, upload("/jobs?webhook=erp.example.com").Code)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO job ").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectExec("INSERT INTO job_arquivo").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO webhook").
+		WithArgs("https://erp.example.com/btc", sqlmock.AnyArg(), int64(42), "erp").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "criado_em"}).AddRow(5, time.Now()))
+	mock.ExpectCommit()
+
+	w := upload("/jobs?webhook=https://erp.example.com/btc")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"segredo":"whsec_`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Estilos definidos em xlsxStyles, referenciados pelo atributo s das células
+const (
+	xlsxStyleDefault  = 0
+	xlsxStyleHeader   = 1
+	xlsxStyleDate     = 2
+	xlsxStyleTime     = 3
+	xlsxStyleDuration = 4
+	xlsxStyleInteger  = 5
+	xlsxStyleDateTime = 6
+)
+
+// excelEpoch é a data base dos números seriais de data do Excel
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Viagens" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+// xlsxStyles define: 0 padrão, 1 cabeçalho (negrito, fundo azul), 2 data, 3 hora, 4 duração, 5 inteiro e 6 data e hora
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="3"><numFmt numFmtId="164" formatCode="[h]:mm:ss"/><numFmt numFmtId="165" formatCode="dd/mm/yyyy"/><numFmt numFmtId="166" formatCode="dd/mm/yyyy hh:mm:ss"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><color rgb="FFFFFFFF"/><name val="Calibri"/></font></fonts>
+<fills count="3"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill><fill><patternFill patternType="solid"><fgColor rgb="FF1F4E78"/><bgColor indexed="64"/></patternFill></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="7">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="2" borderId="0" xfId="0" applyFont="1" applyFill="1"/>
+<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="21" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="1" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="166" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>`
+
+// xlsxColumnName converte o índice da coluna (0 = A) na letra usada nas referências de célula
+func xlsxColumnName(i int) string {
+	name := ""
+	for i >= 0 {
+		name = string(rune('A'+i%26)) + name
+		i = i/26 - 1
+	}
+	return name
+}
+
+// parseClock converte hh:mm:ss (horas podem passar de 24) em fração de dia
+func parseClock(value string) (float64, bool) {
+	var h, m, s int
+	if _, err := fmt.Sscanf(value, "%d:%d:%d", &h, &m, &s); err != nil {
+		return 0, false
+	}
+	return float64(h*3600+m*60+s) / 86400, true
+}
+
+// xlsxCell devolve o valor numérico e o estilo de uma célula, ou ok falso para gravá-la como texto
+func xlsxCell(kind columnKind, value string) (number string, style int, ok bool) {
+	switch kind {
+	case kindInt:
+		if _, err := strconv.Atoi(value); err == nil {
+			return value, xlsxStyleInteger, true
+		}
+	case kindDecimal:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64), xlsxStyleDefault, true
+		}
+	case kindDate:
+		if t, err := time.Parse("02/01/2006", value); err == nil {
+			return strconv.Itoa(int(t.Sub(excelEpoch).Hours() / 24)), xlsxStyleDate, true
+		}
+	case kindDateTime:
+		if t, err := time.Parse("02/01/2006 15:04:05", value); err == nil {
+			return strconv.FormatFloat(t.Sub(excelEpoch).Hours()/24, 'f', -1, 64), xlsxStyleDateTime, true
+		}
+	case kindTime, kindDuration:
+		if f, ok := parseClock(value); ok {
+			style := xlsxStyleTime
+			if kind == kindDuration {
+				style = xlsxStyleDuration
+			}
+			return strconv.FormatFloat(f, 'f', -1, 64), style, true
+		}
+	}
+	return "", 0, false
+}
+
+// writeXLSX grava as linhas em uma planilha XLSX com cabeçalho formatado e células numéricas
+func writeXLSX(w io.Writer, columns []exportColumn, rows []GroupedData) error {
+	zw := zip.NewWriter(w)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, p.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	sheet := bufio.NewWriter(f)
+
+	lastCol := xlsxColumnName(len(columns) - 1)
+	fmt.Fprint(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n")
+	fmt.Fprint(sheet, `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	fmt.Fprint(sheet, `<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	fmt.Fprintf(sheet, `<cols><col min="1" max="%d" width="20" customWidth="1"/></cols>`, len(columns))
+	fmt.Fprint(sheet, `<sheetData>`)
+
+	writeText := func(ref, value string, style int) {
+		fmt.Fprintf(sheet, `<c r="%s" t="inlineStr" s="%d"><is><t>`, ref, style)
+		xml.EscapeText(sheet, []byte(value))
+		fmt.Fprint(sheet, `</t></is></c>`)
+	}
+
+	fmt.Fprint(sheet, `<row r="1">`)
+	for i, col := range columns {
+		writeText(xlsxColumnName(i)+"1", col.Header, xlsxStyleHeader)
+	}
+	fmt.Fprint(sheet, `</row>`)
+
+	for r, data := range rows {
+		rowNum := r + 2
+		fmt.Fprintf(sheet, `<row r="%d">`, rowNum)
+		for i, col := range columns {
+			value := col.Value(data)
+			if value == "" {
+				continue
+			}
+			ref := xlsxColumnName(i) + strconv.Itoa(rowNum)
+			if number, style, ok := xlsxCell(col.Kind, value); ok {
+				fmt.Fprintf(sheet, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, number)
+				continue
+			}
+			writeText(ref, value, xlsxStyleDefault)
+		}
+		fmt.Fprint(sheet, `</row>`)
+	}
+
+	fmt.Fprint(sheet, `</sheetData>`)
+	fmt.Fprintf(sheet, `<autoFilter ref="A1:%s%d"/>`, lastCol, len(rows)+1)
+	fmt.Fprint(sheet, `</worksheet>`)
+
+	if err := sheet.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}